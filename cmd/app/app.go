@@ -0,0 +1,566 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/ivanglie/chatgpt-bot/internal/conversation"
+	"github.com/ivanglie/chatgpt-bot/internal/memory"
+	"github.com/ivanglie/chatgpt-bot/internal/moderation"
+	"github.com/ivanglie/chatgpt-bot/internal/oai"
+	"github.com/ivanglie/chatgpt-bot/internal/scheduler"
+	"github.com/ivanglie/chatgpt-bot/internal/search"
+	"github.com/ivanglie/chatgpt-bot/internal/stats"
+	"github.com/ivanglie/chatgpt-bot/internal/tg"
+	"github.com/ivanglie/chatgpt-bot/internal/transcript"
+	"github.com/ivanglie/chatgpt-bot/internal/users"
+	"github.com/ivanglie/chatgpt-bot/internal/web"
+	"github.com/rs/zerolog"
+	log "github.com/rs/zerolog/log"
+	"golang.org/x/exp/slices"
+)
+
+// maxPages limits the web pages fetched for a request.
+const maxPages = 2
+
+// Actions of the inline buttons under responses.
+const (
+	actionRegenerate = "regen"
+	actionContinue   = "cont"
+	actionStop       = "stop"
+)
+
+// messenger is the Telegram bot as the app uses it, so that it can be instrumented.
+type messenger interface {
+	Send(chatID int64, threadID int, request string, buttons ...tgbotapi.InlineKeyboardButton) (tgbotapi.Message, error)
+	SendMenu(chatID int64, threadID int, text string, buttons ...tgbotapi.InlineKeyboardButton) (tgbotapi.Message, error)
+	SendDocument(chatID int64, threadID int, name string, data []byte, caption string) (tgbotapi.Message, error)
+	Download(fileID string, maxBytes int64) ([]byte, error)
+	Edit(chatID int64, messageID int, text string, buttons ...tgbotapi.InlineKeyboardButton) error
+	AnswerCallback(queryID, text string) error
+	AnswerInline(queryID, resultID, title, text string) error
+	AnswerInlineHint(queryID, hint string) error
+	Button(text, action string, chatID, userID int64) tgbotapi.InlineKeyboardButton
+	Action(query *tgbotapi.CallbackQuery) (action string, ok bool)
+	IsAdmin(chatID, userID int64) (bool, error)
+	Prompt(msg *tgbotapi.Message) (prompt string, ok bool)
+	UserName() string
+}
+
+// assistant is the model as the app uses it, so that it can be instrumented.
+type assistant interface {
+	Generate(ctx context.Context, key oai.Key, request oai.Request, progress oai.ProgressFunc) (oai.Response, error)
+	Complete(ctx context.Context, request string) (oai.Response, error)
+	Regenerate(ctx context.Context, key oai.Key, responseID int, progress oai.ProgressFunc) (oai.Response, error)
+	Continue(ctx context.Context, key oai.Key, responseID int, progress oai.ProgressFunc) (oai.Response, error)
+	Edit(ctx context.Context, key oai.Key, request oai.Request, progress oai.ProgressFunc) (oai.Response, error)
+	ResponseID(key oai.Key, requestID int) int
+	Bind(key oai.Key, requestID, responseID int)
+	Delete(key oai.Key)
+	Export(key oai.Key) ([]transcript.Message, error)
+	RunPrompt(ctx context.Context, chatID string, p oai.Prompt) (oai.Response, error)
+	Extract(ctx context.Context, instructions, text, name string, schema json.RawMessage, v any) error
+	Import(key oai.Key, messages []transcript.Message)
+	Router() *oai.Router
+	Tools() *oai.Tools
+}
+
+// app handles updates from Telegram.
+type app struct {
+	bot       messenger
+	ai        assistant
+	fetcher   *web.Fetcher
+	moderator *moderation.Moderator // nil if moderation is disabled
+	metrics   *appMetrics
+	convs     *conversation.Registry
+	memory    *memory.Memory       // nil if the memory is disabled
+	search    *search.Index        // nil if the search is disabled
+	scheduler *scheduler.Scheduler // runs the reminders and the recurring prompts
+	registry  *users.Registry      // users seen by the bot
+	stats     *stats.Stats
+	users     []string
+	admins    []int64 // Telegram IDs of the bot admins
+
+	logContent   bool // log the texts of requests and responses
+	importTokens int  // limit of tokens of the imported conversations, zero for no limit
+	reportDays   int  // period of the scheduled report of the statistics
+
+	generations *generations
+	inline      *inlineQueries
+	broadcasts  *broadcasts
+}
+
+func newApp(bot messenger, ai assistant, fetcher *web.Fetcher, moderator *moderation.Moderator, metrics *appMetrics,
+	convs *conversation.Registry, mem *memory.Memory, index *search.Index, sched *scheduler.Scheduler, registry *users.Registry, st *stats.Stats, users []string, admins []int64, logContent bool, importTokens int) *app {
+	a := &app{
+		bot:          bot,
+		ai:           ai,
+		fetcher:      fetcher,
+		moderator:    moderator,
+		metrics:      metrics,
+		convs:        convs,
+		memory:       mem,
+		search:       index,
+		scheduler:    sched,
+		registry:     registry,
+		stats:        st,
+		users:        users,
+		admins:       admins,
+		logContent:   logContent,
+		importTokens: importTokens,
+		generations:  newGenerations(),
+		inline:       newInlineQueries(),
+		broadcasts:   newBroadcasts(),
+	}
+
+	a.loadTools()
+
+	sched.Handle(jobReminder, a.fireReminder)
+	sched.Handle(jobPrompt, a.firePrompt)
+	sched.Handle(jobReport, a.fireReport)
+
+	return a
+}
+
+// loadTools enables and disables the tools in the chats as they were set with /tools.
+func (a *app) loadTools() {
+	chats, err := a.convs.Tools()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to load the tools of the chats")
+		return
+	}
+
+	for chatID, tools := range chats {
+		for name, enabled := range tools {
+			if err := a.ai.Tools().Enable(chatID, name, enabled); err != nil {
+				log.Warn().Err(err).Str("chat", chatID).Msg("failed to restore the tool of the chat")
+			}
+		}
+	}
+}
+
+// handle processes the update.
+func (a *app) handle(update tg.Update) {
+	ctx := a.context(update)
+	a.register(ctx, update)
+
+	if update.CallbackQuery != nil {
+		a.handleCallback(ctx, update)
+		return
+	}
+
+	if update.EditedMessage != nil {
+		a.handleEdit(ctx, update)
+		return
+	}
+
+	if update.InlineQuery != nil {
+		a.handleInline(ctx, update)
+		return
+	}
+
+	if update.ChosenInlineResult != nil {
+		a.handleChosenInline(ctx, update)
+		return
+	}
+
+	msg := update.Message
+	if msg == nil {
+		return
+	}
+
+	if msg.IsCommand() && a.handleCommand(ctx, msg, update.ThreadID) {
+		return
+	}
+
+	if msg.Document != nil && a.handleDocument(ctx, msg, update.ThreadID) {
+		return
+	}
+
+	prompt, ok := a.bot.Prompt(msg)
+	if !ok {
+		return
+	}
+
+	if !a.allowed(msg.From) {
+		zerolog.Ctx(ctx).Warn().Msg("access denied")
+		a.metrics.denials.Inc("message")
+		a.bot.Send(msg.Chat.ID, update.ThreadID, "Access denied.")
+
+		return
+	}
+
+	a.logText(ctx, "request", prompt)
+
+	if isReminder(prompt) {
+		go a.remind(ctx, msg, update.ThreadID, prompt, true)
+		return
+	}
+
+	a.answer(ctx, msg, update.ThreadID, prompt)
+}
+
+// answer generates the response to the prompt of the message in the background.
+func (a *app) answer(ctx context.Context, msg *tgbotapi.Message, threadID int, prompt string) {
+	req := oai.Request{Text: prompt, MessageID: msg.MessageID}
+	if msg.ReplyToMessage != nil {
+		req.ReplyToID = msg.ReplyToMessage.MessageID
+	}
+
+	key := a.key(ctx, msg.Chat.ID, msg.From.ID, threadID)
+
+	go func() {
+		if !a.allowInput(ctx, msg.Chat.ID, threadID, msg.From, prompt) {
+			return
+		}
+
+		sent, err := a.bot.Send(msg.Chat.ID, threadID, "…", a.bot.Button("Stop", actionStop, msg.Chat.ID, msg.From.ID))
+		if err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Msg("failed to send the placeholder")
+			return
+		}
+
+		a.respond(ctx, msg.Chat.ID, threadID, sent.MessageID, nil, msg.From, a.queued(key, func(ctx context.Context, progress oai.ProgressFunc) (oai.Response, error) {
+			req.Text = a.withPages(ctx, req.Text)
+
+			res, err := a.ai.Generate(ctx, key, req, progress)
+			if err == nil {
+				a.ai.Bind(key, msg.MessageID, sent.MessageID)
+				a.touch(ctx, key, prompt)
+				a.index(ctx, key, msg.From, prompt, res.Text)
+			}
+
+			return res, err
+		}))
+	}()
+}
+
+// handleEdit regenerates the response to the edited request in place.
+func (a *app) handleEdit(ctx context.Context, update tg.Update) {
+	msg := update.EditedMessage
+
+	prompt, ok := a.bot.Prompt(msg)
+	if !ok || !a.allowed(msg.From) {
+		return
+	}
+
+	key := a.key(ctx, msg.Chat.ID, msg.From.ID, update.ThreadID)
+
+	responseID := a.ai.ResponseID(key, msg.MessageID)
+	if responseID == 0 || a.generations.running(msg.Chat.ID, responseID) {
+		return
+	}
+
+	a.logText(ctx, "edited request", prompt)
+
+	go func() {
+		if !a.allowInput(ctx, msg.Chat.ID, update.ThreadID, msg.From, prompt) {
+			return
+		}
+
+		a.respond(ctx, msg.Chat.ID, update.ThreadID, responseID, nil, msg.From, a.queued(key, func(ctx context.Context, progress oai.ProgressFunc) (oai.Response, error) {
+			res, err := a.ai.Edit(ctx, key, oai.Request{Text: a.withPages(ctx, prompt), MessageID: msg.MessageID}, progress)
+			if err == nil {
+				a.touch(ctx, key, "")
+			}
+
+			return res, err
+		}))
+	}()
+}
+
+// handleCallback processes the inline buttons under responses.
+func (a *app) handleCallback(ctx context.Context, update tg.Update) {
+	query := update.CallbackQuery
+
+	action, ok := a.bot.Action(query)
+	if !ok || !a.allowed(query.From) {
+		a.metrics.denials.Inc("callback_query")
+		a.bot.AnswerCallback(query.ID, "This button is not for you.")
+		return
+	}
+
+	chatID, messageID := query.Message.Chat.ID, query.Message.MessageID
+	key := a.key(ctx, chatID, query.From.ID, update.ThreadID)
+
+	switch action {
+	case actionStop:
+		if !a.generations.stop(chatID, messageID) {
+			a.bot.AnswerCallback(query.ID, "The response is already completed.")
+			return
+		}
+
+		a.bot.AnswerCallback(query.ID, "Stopped.")
+	case actionRegenerate:
+		if a.generations.running(chatID, messageID) {
+			a.bot.AnswerCallback(query.ID, "The response is being generated.")
+			return
+		}
+
+		a.bot.AnswerCallback(query.ID, "")
+
+		go a.respond(ctx, chatID, update.ThreadID, messageID, query.Message, query.From, a.queued(key, func(ctx context.Context, progress oai.ProgressFunc) (oai.Response, error) {
+			return a.ai.Regenerate(ctx, key, messageID, progress)
+		}))
+	case actionContinue:
+		a.bot.AnswerCallback(query.ID, "")
+
+		go func() {
+			sent, err := a.bot.Send(chatID, update.ThreadID, "…", a.bot.Button("Stop", actionStop, chatID, query.From.ID))
+			if err != nil {
+				zerolog.Ctx(ctx).Error().Err(err).Msg("failed to send the placeholder")
+				return
+			}
+
+			a.respond(ctx, chatID, update.ThreadID, sent.MessageID, nil, query.From, a.queued(key, func(ctx context.Context, progress oai.ProgressFunc) (oai.Response, error) {
+				res, err := a.ai.Continue(ctx, key, messageID, progress)
+				if err == nil {
+					a.ai.Bind(key, messageID, sent.MessageID)
+				}
+
+				return res, err
+			}))
+		}()
+	default:
+		if !a.handleConversationCallback(ctx, query, update.ThreadID, action) && !a.handleMemoryCallback(ctx, query, action) &&
+			!a.handleReminderCallback(ctx, query, action) && !a.handleBroadcastCallback(ctx, query, action) {
+			a.bot.AnswerCallback(query.ID, "")
+		}
+	}
+}
+
+// generateFunc generates a response showing the progress.
+type generateFunc func(ctx context.Context, progress oai.ProgressFunc) (oai.Response, error)
+
+// queued makes the generation wait for the other generations of the conversation.
+func (a *app) queued(key oai.Key, generate generateFunc) generateFunc {
+	return func(ctx context.Context, progress oai.ProgressFunc) (oai.Response, error) {
+		unlock, err := a.generations.lock(ctx, key)
+		if err != nil {
+			return oai.Response{}, err
+		}
+		defer unlock()
+
+		return generate(ctx, progress)
+	}
+}
+
+// respond streams the generated response to the message of the bot
+// and adds the buttons to regenerate or continue it.
+// The previous response in the message, if any, is restored on failure and the failure is reported in a new message.
+func (a *app) respond(ctx context.Context, chatID int64, threadID, messageID int, previous *tgbotapi.Message, user *tgbotapi.User, generate generateFunc) {
+	ctx, done := a.generations.start(ctx, chatID, messageID)
+	defer done()
+
+	logger := zerolog.Ctx(ctx)
+
+	stop := a.bot.Button("Stop", actionStop, chatID, user.ID)
+	progress := throttle(func(text string) {
+		// The text is shown only after moderation
+		if a.moderator != nil {
+			return
+		}
+
+		if err := a.bot.Edit(chatID, messageID, text+" …", stop); err != nil {
+			logger.Error().Err(err).Msg("failed to show the progress")
+		}
+	})
+
+	res, err := generate(ctx, progress)
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to generate a response")
+
+		text := "Failed to generate a response."
+		if errors.Is(err, oai.ErrNotFound) {
+			text = "The response is not in the conversation anymore."
+		}
+
+		if previous != nil {
+			a.bot.Edit(chatID, messageID, previous.Text, keyboard(previous)...)
+			a.bot.Send(chatID, threadID, text)
+
+			return
+		}
+
+		a.bot.Edit(chatID, messageID, text)
+
+		return
+	}
+
+	a.logText(ctx, "response", res.Text)
+	logger.Info().Str("backend", res.Backend).Str("model", res.Model).Bool("truncated", res.Truncated).
+		Int("prompt_tokens", res.Usage.PromptTokens).Int("completion_tokens", res.Usage.CompletionTokens).Msg("response generated")
+
+	v := a.moderate(context.WithoutCancel(ctx), moderation.StageOutput, chatID, user, res.Text)
+	if v.Has(moderation.ActionBlock) {
+		res.Text = "The response is blocked by moderation."
+	} else if v.Has(moderation.ActionWarn) {
+		res.Text += fmt.Sprintf("\n\nWarning: the response is flagged for %s.", strings.Join(v.Categories, ", "))
+	}
+
+	if res.Reset {
+		res.Text = "The conversation was reset to free memory, so the earlier messages are forgotten.\n\n" + res.Text
+	}
+
+	buttons := []tgbotapi.InlineKeyboardButton{a.bot.Button("Regenerate", actionRegenerate, chatID, user.ID)}
+	if res.Truncated {
+		buttons = append(buttons, a.bot.Button("Continue", actionContinue, chatID, user.ID))
+	}
+
+	if err := a.bot.Edit(chatID, messageID, res.Text, buttons...); err != nil {
+		logger.Error().Err(err).Msg("failed to send the response")
+	}
+}
+
+// keyboard returns the inline buttons of the message.
+func keyboard(msg *tgbotapi.Message) []tgbotapi.InlineKeyboardButton {
+	var buttons []tgbotapi.InlineKeyboardButton
+	if msg.ReplyMarkup != nil {
+		for _, row := range msg.ReplyMarkup.InlineKeyboard {
+			buttons = append(buttons, row...)
+		}
+	}
+
+	return buttons
+}
+
+// withPages adds the text of the web pages linked in the prompt to it.
+func (a *app) withPages(ctx context.Context, prompt string) string {
+	urls := web.URLs(prompt)
+	if len(urls) > maxPages {
+		urls = urls[:maxPages]
+	}
+
+	for _, u := range urls {
+		page, err := a.fetcher.Fetch(ctx, u)
+		if err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Str("url", u).Msg("failed to fetch the page")
+			continue
+		}
+
+		source := page.URL
+		if page.Title != "" {
+			source += " (" + page.Title + ")"
+		}
+
+		prompt += fmt.Sprintf("\n\nContent of %s:\n%s", source, page.Text)
+	}
+
+	return prompt
+}
+
+// context returns the context of the update with the logger which adds
+// a correlation ID and the update details to the logs of its processing.
+func (a *app) context(update tg.Update) context.Context {
+	logger := log.With().Str("cid", correlationID()).Int("update_id", update.UpdateID).Logger()
+
+	if chat := update.FromChat(); chat != nil {
+		logger = logger.With().Int64("chat_id", chat.ID).Logger()
+	}
+
+	ctx := context.Background()
+
+	if user := update.SentFrom(); user != nil {
+		logger = logger.With().Int64("user_id", user.ID).Logger()
+		ctx = oai.WithUser(ctx, userKey(user))
+	}
+
+	logger.Debug().Str("type", updateType(update)).Msg("update received")
+
+	return logger.WithContext(ctx)
+}
+
+// register records the sender of the update in the registry of the users and counts the update.
+func (a *app) register(ctx context.Context, update tg.Update) {
+	user := update.SentFrom()
+	if user == nil || user.IsBot {
+		return
+	}
+
+	if err := a.stats.Update(userKey(user), updateType(update)); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to count the update")
+	}
+
+	// The users without access are not recorded, so that they do not receive the broadcasts
+	if !a.allowed(user) {
+		return
+	}
+
+	chat := update.FromChat()
+
+	err := a.registry.Seen(users.User{
+		ID:        user.ID,
+		UserName:  user.UserName,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		Language:  user.LanguageCode,
+	}, chat != nil && chat.IsPrivate())
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to register the user")
+	}
+}
+
+// logText logs the text of a request or a response if it is enabled.
+func (a *app) logText(ctx context.Context, kind, text string) {
+	if a.logContent {
+		zerolog.Ctx(ctx).Info().Str("text", text).Msg(kind)
+	}
+}
+
+// correlationID returns a random ID to correlate the logs of an update.
+func correlationID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// allowed reports whether the user has access to the bot.
+func (a *app) allowed(user *tgbotapi.User) bool {
+	return len(a.users) == 0 || user != nil && a.allowedName(user.UserName)
+}
+
+// allowedName reports whether the user with the username has access to the bot.
+func (a *app) allowedName(userName string) bool {
+	return len(a.users) == 0 || slices.Contains(a.users, userName)
+}
+
+// key returns the key of the active conversation of the user in the chat.
+func (a *app) key(ctx context.Context, chatID, userID int64, threadID int) oai.Key {
+	key := a.scope(chatID, userID, threadID)
+
+	c, err := a.convs.Active(key.String())
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to get the active conversation")
+	}
+
+	key.Conversation = c.ID
+
+	return key
+}
+
+// scope returns the key of the conversations of the user in the chat,
+// all of them in shared groups.
+func (a *app) scope(chatID, userID int64, threadID int) oai.Key {
+	key := oai.Key{ChatID: fmt.Sprintf("%d", chatID)}
+	if threadID != 0 {
+		key.ThreadID = fmt.Sprintf("%d", threadID)
+	}
+
+	shared, err := a.convs.Shared(key.ChatID)
+	if err != nil {
+		log.Error().Err(err).Str("chat", key.ChatID).Msg("failed to check the shared conversation")
+	}
+
+	if !shared {
+		key.UserID = fmt.Sprintf("%d", userID)
+	}
+
+	return key
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ivanglie/chatgpt-bot/internal/oai"
+)
+
+// backendsFile is a JSON file with additional backends, e.g.
+//
+//	{
+//	  "backends": [
+//	    {"name": "local", "api_type": "compatible", "base_url": "http://ollama:11434/v1", "models": {"gpt-4o-mini": "llama3.1"}}
+//	  ],
+//	  "chain": ["default", "local"],
+//	  "routes": {"gpt-4o": "default"}
+//	}
+type backendsFile struct {
+	Backends []struct {
+		Name         string            `json:"name"`
+		APIType      string            `json:"api_type"`
+		APIKey       string            `json:"api_key"`
+		BaseURL      string            `json:"base_url"`
+		Organization string            `json:"org"`
+		APIVersion   string            `json:"api_version"`
+		Deployments  map[string]string `json:"deployments"`
+		Models       map[string]string `json:"models"`
+		Timeout      string            `json:"timeout"`
+		Proxy        string            `json:"proxy"`
+	} `json:"backends"`
+	Chain  []string          `json:"chain"`  // all backends in order of the file by default
+	Routes map[string]string `json:"routes"` // model -> backend
+}
+
+// loadBackends adds the backends from the file to the router.
+func loadBackends(path string, router *oai.Router) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var f backendsFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("invalid backends file: %w", err)
+	}
+
+	for _, b := range f.Backends {
+		if b.Name == "" {
+			return errors.New("backend without name")
+		}
+
+		var timeout time.Duration
+		if b.Timeout != "" {
+			if timeout, err = time.ParseDuration(b.Timeout); err != nil {
+				return fmt.Errorf("invalid timeout of backend %s: %w", b.Name, err)
+			}
+		}
+
+		client, err := oai.NewClient(b.APIKey, oai.Config{
+			APIType:      b.APIType,
+			BaseURL:      b.BaseURL,
+			Organization: b.Organization,
+			APIVersion:   b.APIVersion,
+			Deployments:  b.Deployments,
+			Timeout:      timeout,
+			Proxy:        b.Proxy,
+		})
+		if err != nil {
+			return fmt.Errorf("backend %s: %w", b.Name, err)
+		}
+
+		router.Add(oai.Backend{Name: b.Name, Client: client, Models: b.Models})
+	}
+
+	if len(f.Chain) > 0 {
+		if err := router.SetChain(f.Chain...); err != nil {
+			return err
+		}
+	}
+
+	for model, name := range f.Routes {
+		if err := router.Route(model, name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
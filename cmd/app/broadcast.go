@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/ivanglie/chatgpt-bot/internal/tg"
+	"github.com/ivanglie/chatgpt-bot/internal/users"
+	"github.com/rs/zerolog"
+)
+
+// broadcastInterval is the interval between the messages of a broadcast,
+// below the global limit of Telegram of 30 messages per second.
+const broadcastInterval = time.Second / 25
+
+// Prefixes of the actions of the buttons to confirm or cancel a broadcast, followed by its ID.
+const (
+	actionBroadcastSend   = "bc-send-"
+	actionBroadcastCancel = "bc-cancel-"
+)
+
+const broadcastUsage = `Usage: /broadcast [now] [active=7d] [lang=en] message
+The message is sent to the users who have a private chat with the bot, active within the period and with the language if set.
+It is previewed to be confirmed unless now is set.`
+
+// broadcast is a message to the users selected by the filter.
+type broadcast struct {
+	text   string
+	filter users.Filter
+}
+
+// broadcasts keeps the broadcasts waiting for confirmation and sends one broadcast at a time.
+type broadcasts struct {
+	mu      sync.Mutex
+	pending map[string]broadcast
+	next    int
+
+	sending sync.Mutex
+}
+
+func newBroadcasts() *broadcasts {
+	return &broadcasts{pending: make(map[string]broadcast)}
+}
+
+// add keeps the broadcast until it is confirmed and returns its ID.
+func (b *broadcasts) add(bc broadcast) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.next++
+	id := strconv.Itoa(b.next)
+	b.pending[id] = bc
+
+	return id
+}
+
+// take removes the pending broadcast and returns it.
+func (b *broadcasts) take(id string) (broadcast, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	bc, ok := b.pending[id]
+	delete(b.pending, id)
+
+	return bc, ok
+}
+
+// handleBroadcast previews the message to the users to be confirmed or sends it right away.
+func (a *app) handleBroadcast(ctx context.Context, msg *tgbotapi.Message, threadID int) {
+	if !a.isBotAdmin(msg.From) {
+		return
+	}
+
+	bc, now, err := parseBroadcast(msg.CommandArguments(), time.Now())
+	if err != nil {
+		a.bot.Send(msg.Chat.ID, threadID, err.Error()+"\n\n"+broadcastUsage)
+		return
+	}
+
+	recipients, err := a.recipients(bc.filter)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to list the users")
+		return
+	}
+
+	if len(recipients) == 0 {
+		a.bot.Send(msg.Chat.ID, threadID, "No users to send the message to.")
+		return
+	}
+
+	if now {
+		sent, err := a.bot.Send(msg.Chat.ID, threadID, fmt.Sprintf("Sending to %d users…", len(recipients)))
+		if err == nil {
+			go a.deliver(ctx, msg.Chat.ID, sent.MessageID, bc)
+		}
+
+		return
+	}
+
+	id := a.broadcasts.add(bc)
+	a.bot.SendMenu(msg.Chat.ID, threadID, fmt.Sprintf("The message to %d users:\n\n%s", len(recipients), bc.text),
+		a.bot.Button("Send", actionBroadcastSend+id, msg.Chat.ID, msg.From.ID),
+		a.bot.Button("Cancel", actionBroadcastCancel+id, msg.Chat.ID, msg.From.ID))
+}
+
+// handleBroadcastCallback sends or cancels the previewed broadcast and reports whether the action is one of these.
+func (a *app) handleBroadcastCallback(ctx context.Context, query *tgbotapi.CallbackQuery, action string) bool {
+	id, send := strings.CutPrefix(action, actionBroadcastSend)
+	if !send {
+		var ok bool
+		if id, ok = strings.CutPrefix(action, actionBroadcastCancel); !ok {
+			return false
+		}
+	}
+
+	if !a.isBotAdmin(query.From) {
+		a.bot.AnswerCallback(query.ID, "Only the bot admins can broadcast.")
+		return true
+	}
+
+	bc, ok := a.broadcasts.take(id)
+	if !ok {
+		a.bot.AnswerCallback(query.ID, "The broadcast is already sent or canceled.")
+		return true
+	}
+
+	a.bot.AnswerCallback(query.ID, "")
+
+	chatID, messageID := query.Message.Chat.ID, query.Message.MessageID
+	if !send {
+		a.bot.Edit(chatID, messageID, "The broadcast is canceled.")
+		return true
+	}
+
+	a.bot.Edit(chatID, messageID, "Sending…")
+	go a.deliver(ctx, chatID, messageID, bc)
+
+	return true
+}
+
+// deliver sends the broadcast to the users, throttled to the limits of Telegram,
+// marks the users who blocked the bot and reports the counts in the message of the admin.
+func (a *app) deliver(ctx context.Context, chatID int64, messageID int, bc broadcast) {
+	a.broadcasts.sending.Lock()
+	defer a.broadcasts.sending.Unlock()
+
+	recipients, err := a.recipients(bc.filter)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to list the users")
+		a.bot.Edit(chatID, messageID, "Failed to list the users.")
+
+		return
+	}
+
+	ticker := time.NewTicker(broadcastInterval)
+	defer ticker.Stop()
+
+	var delivered, failed, blocked int
+	for _, u := range recipients {
+		<-ticker.C
+
+		_, err := a.bot.Send(u.ID, 0, bc.text)
+		if wait := tg.RetryAfter(err); wait > 0 {
+			time.Sleep(wait)
+			_, err = a.bot.Send(u.ID, 0, bc.text)
+		}
+
+		switch {
+		case err == nil:
+			delivered++
+		case tg.IsBlocked(err):
+			blocked++
+			if err := a.registry.SetBlocked(u.ID, true); err != nil {
+				zerolog.Ctx(ctx).Error().Err(err).Msg("failed to mark the user")
+			}
+		default:
+			failed++
+			zerolog.Ctx(ctx).Warn().Err(err).Int64("recipient", u.ID).Msg("failed to deliver the broadcast")
+		}
+	}
+
+	zerolog.Ctx(ctx).Info().Int("delivered", delivered).Int("failed", failed).Int("blocked", blocked).Msg("broadcast sent")
+	a.bot.Edit(chatID, messageID, fmt.Sprintf("The broadcast is sent to %d users: delivered %d, failed %d, blocked the bot %d.",
+		len(recipients), delivered, failed, blocked))
+}
+
+// recipients returns the users selected by the filter who have access to the bot,
+// as the list of the allowed users may have changed since they were recorded.
+func (a *app) recipients(filter users.Filter) ([]users.User, error) {
+	all, err := a.registry.List(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	res := all[:0]
+	for _, u := range all {
+		if a.allowedName(u.UserName) {
+			res = append(res, u)
+		}
+	}
+
+	return res, nil
+}
+
+// parseBroadcast parses the options and the message of the /broadcast command and reports whether to send it right away.
+func parseBroadcast(args string, now time.Time) (broadcast, bool, error) {
+	bc := broadcast{filter: users.Filter{Reachable: true}}
+
+	var sendNow bool
+
+	// The options precede the message, which keeps its line breaks
+	text := strings.TrimSpace(args)
+	for text != "" {
+		option, rest := text, ""
+		if i := strings.IndexAny(text, " \t\n"); i >= 0 {
+			option, rest = text[:i], text[i:]
+		}
+
+		key, value, _ := strings.Cut(option, "=")
+		switch {
+		case option == "now":
+			sendNow = true
+		case key == "active" && value != "":
+			d, err := parsePeriod(value)
+			if err != nil {
+				return broadcast{}, false, err
+			}
+
+			bc.filter.ActiveSince = now.Add(-d)
+		case key == "lang" && value != "":
+			bc.filter.Language = value
+		default:
+			bc.text = text
+			return bc, sendNow, nil
+		}
+
+		text = strings.TrimSpace(rest)
+	}
+
+	return broadcast{}, false, errors.New("the message is empty")
+}
+
+// parsePeriod parses a duration with days, e.g. 7d, or as time.ParseDuration, e.g. 12h.
+func parsePeriod(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid period %q", s)
+		}
+
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid period %q", s)
+	}
+
+	return d, nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/rs/zerolog"
+	"golang.org/x/exp/slices"
+)
+
+// adminCommands are the commands of the bot admins, whose handlers check the admins instead of the bot users.
+var adminCommands = []string{"flagged", "broadcast", "stats"}
+
+// handleCommand processes the bot commands and reports whether the command is handled.
+// Only the bot users can use the commands other than the admin ones.
+func (a *app) handleCommand(ctx context.Context, msg *tgbotapi.Message, threadID int) bool {
+	zerolog.Ctx(ctx).Debug().Str("command", msg.Command()).Msg("command received")
+
+	handlers := map[string]func(ctx context.Context, msg *tgbotapi.Message, threadID int){
+		"mode":    a.handleMode,
+		"tools":   a.handleTools,
+		"backend": a.handleBackend,
+		"flagged": a.handleFlagged,
+		"new":     a.handleNew,
+		"list":    a.handleList,
+		"switch": func(ctx context.Context, msg *tgbotapi.Message, threadID int) {
+			a.handleChoose(ctx, msg, threadID, actionSwitch, "Choose a conversation to continue:")
+		},
+		"delete": func(ctx context.Context, msg *tgbotapi.Message, threadID int) {
+			a.handleChoose(ctx, msg, threadID, actionDelete, "Choose a conversation to delete:")
+		},
+		"export":    a.handleExport,
+		"import":    a.handleImport,
+		"remember":  a.handleRemember,
+		"memory":    a.handleMemory,
+		"search":    a.handleSearch,
+		"remind":    a.handleRemind,
+		"reminders": a.handleReminders,
+		"timezone":  a.handleTimezone,
+		"schedule":  a.handleSchedule,
+		"broadcast": a.handleBroadcast,
+		"stats":     a.handleStats,
+	}
+
+	handle, ok := handlers[msg.Command()]
+	if !ok {
+		return false
+	}
+
+	if !slices.Contains(adminCommands, msg.Command()) && !a.allowed(msg.From) {
+		zerolog.Ctx(ctx).Warn().Msg("access denied")
+		a.metrics.denials.Inc("command")
+		a.bot.Send(msg.Chat.ID, threadID, "Access denied.")
+
+		return true
+	}
+
+	handle(ctx, msg, threadID)
+
+	return true
+}
+
+// handleMode switches a group between a shared conversation and per-user conversations.
+func (a *app) handleMode(ctx context.Context, msg *tgbotapi.Message, threadID int) {
+	if msg.Chat.IsPrivate() {
+		a.bot.Send(msg.Chat.ID, threadID, "The mode can be changed in groups only.")
+		return
+	}
+
+	isAdmin, err := a.bot.IsAdmin(msg.Chat.ID, msg.From.ID)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to check the admin")
+		return
+	}
+
+	if !isAdmin {
+		a.bot.Send(msg.Chat.ID, threadID, "Only group admins can change the mode.")
+		return
+	}
+
+	var shared bool
+	switch strings.TrimSpace(msg.CommandArguments()) {
+	case "shared":
+		shared = true
+	case "personal":
+	default:
+		a.bot.Send(msg.Chat.ID, threadID, "Usage: /mode shared|personal")
+		return
+	}
+
+	if err := a.convs.SetShared(fmt.Sprintf("%d", msg.Chat.ID), shared); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to change the mode")
+		a.bot.Send(msg.Chat.ID, threadID, "Failed to change the mode.")
+
+		return
+	}
+
+	if shared {
+		a.bot.Send(msg.Chat.ID, threadID, "The conversation is shared by all members of the group now.")
+	} else {
+		a.bot.Send(msg.Chat.ID, threadID, "Each member of the group has a personal conversation now.")
+	}
+}
+
+// handleTools lists the tools or enables and disables them in the chat.
+func (a *app) handleTools(ctx context.Context, msg *tgbotapi.Message, threadID int) {
+	tools := a.ai.Tools()
+	chatID := fmt.Sprintf("%d", msg.Chat.ID)
+
+	args := strings.Fields(msg.CommandArguments())
+	if len(args) == 0 {
+		var sb strings.Builder
+		sb.WriteString("Tools:")
+
+		for _, name := range tools.Names() {
+			state := "off"
+			if tools.Enabled(chatID, name) {
+				state = "on"
+			}
+
+			fmt.Fprintf(&sb, "\n%s: %s", name, state)
+		}
+
+		sb.WriteString("\n\nUsage: /tools on|off <name>")
+		a.bot.Send(msg.Chat.ID, threadID, sb.String())
+
+		return
+	}
+
+	if len(args) != 2 || args[0] != "on" && args[0] != "off" {
+		a.bot.Send(msg.Chat.ID, threadID, "Usage: /tools on|off <name>")
+		return
+	}
+
+	if !a.canConfigure(ctx, msg, threadID) {
+		return
+	}
+
+	if err := tools.Enable(chatID, args[1], args[0] == "on"); err != nil {
+		a.bot.Send(msg.Chat.ID, threadID, fmt.Sprintf("Unknown tool %s.", args[1]))
+		return
+	}
+
+	if err := a.convs.SetTool(chatID, args[1], args[0] == "on"); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to save the tool of the chat")
+	}
+
+	a.bot.Send(msg.Chat.ID, threadID, fmt.Sprintf("The tool %s is %s.", args[1], args[0]))
+}
+
+// handleBackend shows or chooses the backend for the chat.
+func (a *app) handleBackend(ctx context.Context, msg *tgbotapi.Message, threadID int) {
+	router := a.ai.Router()
+	chatID := fmt.Sprintf("%d", msg.Chat.ID)
+
+	name := strings.TrimSpace(msg.CommandArguments())
+	if name == "" {
+		current := router.Preferred(chatID)
+		if current == "" {
+			current = "auto"
+		}
+
+		a.bot.Send(msg.Chat.ID, threadID, fmt.Sprintf("Backend: %s\nAvailable: auto, %s\n\nUsage: /backend <name>",
+			current, strings.Join(router.Names(), ", ")))
+
+		return
+	}
+
+	if !a.canConfigure(ctx, msg, threadID) {
+		return
+	}
+
+	if name == "auto" {
+		name = ""
+	}
+
+	if err := router.Prefer(chatID, name); err != nil {
+		a.bot.Send(msg.Chat.ID, threadID, fmt.Sprintf("Unknown backend %s.", name))
+		return
+	}
+
+	a.bot.Send(msg.Chat.ID, threadID, "The backend is changed.")
+}
+
+// canConfigure reports whether the user can change the settings of the chat:
+// anyone in private chats and admins in groups.
+func (a *app) canConfigure(ctx context.Context, msg *tgbotapi.Message, threadID int) bool {
+	if msg.Chat.IsPrivate() {
+		return true
+	}
+
+	isAdmin, err := a.bot.IsAdmin(msg.Chat.ID, msg.From.ID)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to check the admin")
+		return false
+	}
+
+	if !isAdmin {
+		a.bot.Send(msg.Chat.ID, threadID, "Only group admins can change the settings.")
+	}
+
+	return isAdmin
+}
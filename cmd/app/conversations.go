@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/ivanglie/chatgpt-bot/internal/conversation"
+	"github.com/ivanglie/chatgpt-bot/internal/oai"
+	"github.com/rs/zerolog"
+)
+
+// Prefixes of the actions of the buttons to choose a conversation, followed by its ID.
+const (
+	actionSwitch = "sw-"
+	actionDelete = "del-"
+)
+
+// handleNew starts a new conversation in the chat.
+func (a *app) handleNew(ctx context.Context, msg *tgbotapi.Message, threadID int) {
+	scope := a.scope(msg.Chat.ID, msg.From.ID, threadID)
+
+	if _, err := a.convs.Start(scope.String()); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to start a conversation")
+		a.bot.Send(msg.Chat.ID, threadID, "Failed to start a new conversation.")
+
+		return
+	}
+
+	a.bot.Send(msg.Chat.ID, threadID, "Started a new conversation. Use /switch to return to the previous one.")
+}
+
+// handleList lists the conversations in the chat with their last activity.
+func (a *app) handleList(ctx context.Context, msg *tgbotapi.Message, threadID int) {
+	scope := a.scope(msg.Chat.ID, msg.From.ID, threadID)
+
+	list, active, err := a.convs.List(scope.String())
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to list the conversations")
+		return
+	}
+
+	if len(list) == 0 {
+		a.bot.Send(msg.Chat.ID, threadID, "No conversations yet.")
+		return
+	}
+
+	var sb strings.Builder
+	for _, c := range list {
+		marker := "  "
+		if c.ID == active {
+			marker = "▶ "
+		}
+
+		fmt.Fprintf(&sb, "%s%s, %s\n", marker, c.Name(), c.Updated.Format("2006-01-02 15:04"))
+	}
+
+	a.bot.Send(msg.Chat.ID, threadID, sb.String())
+}
+
+// handleChoose shows the conversations as buttons with the action followed by their IDs.
+func (a *app) handleChoose(ctx context.Context, msg *tgbotapi.Message, threadID int, action, text string) {
+	scope := a.scope(msg.Chat.ID, msg.From.ID, threadID)
+
+	list, active, err := a.convs.List(scope.String())
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to list the conversations")
+		return
+	}
+
+	var buttons []tgbotapi.InlineKeyboardButton
+	for _, c := range list {
+		if action == actionSwitch && c.ID == active {
+			continue
+		}
+
+		buttons = append(buttons, a.bot.Button(c.Name(), action+c.ID, msg.Chat.ID, msg.From.ID))
+	}
+
+	if len(buttons) == 0 {
+		a.bot.Send(msg.Chat.ID, threadID, "There are no other conversations.")
+		return
+	}
+
+	a.bot.SendMenu(msg.Chat.ID, threadID, text, buttons...)
+}
+
+// handleConversationCallback switches to or deletes the chosen conversation
+// and reports whether the action is one of these.
+func (a *app) handleConversationCallback(ctx context.Context, query *tgbotapi.CallbackQuery, threadID int, action string) bool {
+	chatID, messageID := query.Message.Chat.ID, query.Message.MessageID
+	scope := a.scope(chatID, query.From.ID, threadID)
+
+	var (
+		c    conversation.Conversation
+		err  error
+		text string
+	)
+
+	if id, ok := strings.CutPrefix(action, actionSwitch); ok {
+		c, err = a.convs.Switch(scope.String(), id)
+		text = "Switched to the conversation: " + c.Name()
+	} else if id, ok := strings.CutPrefix(action, actionDelete); ok {
+		c, err = a.convs.Delete(scope.String(), id)
+		if err == nil {
+			key := scope
+			key.Conversation = id
+			a.ai.Delete(key)
+			a.unindex(ctx, scope, id)
+		}
+
+		text = "Deleted the conversation: " + c.Name()
+	} else {
+		return false
+	}
+
+	if errors.Is(err, conversation.ErrNotFound) {
+		a.bot.AnswerCallback(query.ID, "The conversation is already deleted.")
+		return true
+	}
+
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to change the conversations")
+		a.bot.AnswerCallback(query.ID, "Failed to change the conversations.")
+
+		return true
+	}
+
+	a.bot.AnswerCallback(query.ID, "")
+	a.bot.Edit(chatID, messageID, text)
+
+	return true
+}
+
+// touch records the activity in the conversation of the key and titles it with the request.
+func (a *app) touch(ctx context.Context, key oai.Key, request string) {
+	id := key.Conversation
+	key.Conversation = ""
+
+	if err := a.convs.Touch(key.String(), id, request); err != nil && !errors.Is(err, conversation.ErrNotFound) {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to update the conversation")
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/ivanglie/chatgpt-bot/internal/oai"
+	"github.com/ivanglie/chatgpt-bot/internal/transcript"
+	"github.com/rs/zerolog"
+)
+
+// handleExport sends the active conversation as a Markdown, JSON or HTML document.
+func (a *app) handleExport(ctx context.Context, msg *tgbotapi.Message, threadID int) {
+	format := strings.ToLower(strings.TrimSpace(msg.CommandArguments()))
+	if format == "" {
+		format = "md"
+	}
+
+	scope := a.scope(msg.Chat.ID, msg.From.ID, threadID)
+
+	c, err := a.convs.Active(scope.String())
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to get the active conversation")
+		return
+	}
+
+	key := scope
+	key.Conversation = c.ID
+
+	messages, err := a.ai.Export(key)
+	if errors.Is(err, oai.ErrNotFound) {
+		a.bot.Send(msg.Chat.ID, threadID, "The conversation is empty.")
+		return
+	}
+
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to export the conversation")
+		return
+	}
+
+	t := transcript.Transcript{Title: c.Title, Exported: time.Now(), Messages: messages}
+
+	var data []byte
+	switch format {
+	case "md", "markdown":
+		format, data = "md", transcript.Markdown(t)
+	case "json":
+		data, err = transcript.JSON(t)
+	case "html":
+		data, err = transcript.HTML(t)
+	default:
+		a.bot.Send(msg.Chat.ID, threadID, "Usage: /export [md|json|html]")
+		return
+	}
+
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to render the conversation")
+		return
+	}
+
+	name := "conversation-" + t.Exported.Format("20060102-1504") + "." + format
+	if _, err := a.bot.SendDocument(msg.Chat.ID, threadID, name, data, c.Name()); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to send the conversation")
+		a.bot.Send(msg.Chat.ID, threadID, "Failed to send the conversation.")
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ivanglie/chatgpt-bot/internal/oai"
+)
+
+// progressInterval is the minimum interval between edits of a streamed response,
+// so that the bot stays within the rate limits of Telegram.
+const progressInterval = time.Second
+
+// generations keeps the responses which are being generated, so that users can stop them,
+// and runs the generations of a conversation one at a time.
+type generations struct {
+	mu      sync.Mutex
+	cancels map[string]context.CancelFunc
+	queues  map[oai.Key]*queue
+}
+
+// queue is the turn of the generations of a conversation.
+type queue struct {
+	turn    chan struct{}
+	waiting int // generations running or waiting for their turn
+}
+
+func newGenerations() *generations {
+	return &generations{cancels: make(map[string]context.CancelFunc), queues: make(map[oai.Key]*queue)}
+}
+
+// lock waits until the other generations of the conversation are done, so that the generation sees their turns,
+// and returns the function to call when the generation is done. It fails if ctx is done while waiting.
+func (g *generations) lock(ctx context.Context, key oai.Key) (func(), error) {
+	g.mu.Lock()
+	q, ok := g.queues[key]
+	if !ok {
+		q = &queue{turn: make(chan struct{}, 1)}
+		g.queues[key] = q
+	}
+	q.waiting++
+	g.mu.Unlock()
+
+	leave := func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+
+		if q.waiting--; q.waiting == 0 {
+			delete(g.queues, key)
+		}
+	}
+
+	select {
+	case q.turn <- struct{}{}:
+		return func() {
+			<-q.turn
+			leave()
+		}, nil
+	case <-ctx.Done():
+		leave()
+		return nil, ctx.Err()
+	}
+}
+
+// start registers the generation of the message and returns its context
+// derived from ctx and the function to call when the generation is done.
+func (g *generations) start(ctx context.Context, chatID int64, messageID int) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	id := fmt.Sprintf("%d:%d", chatID, messageID)
+
+	g.mu.Lock()
+	g.cancels[id] = cancel
+	g.mu.Unlock()
+
+	return ctx, func() {
+		g.mu.Lock()
+		delete(g.cancels, id)
+		g.mu.Unlock()
+
+		cancel()
+	}
+}
+
+// running reports whether the message is being generated.
+func (g *generations) running(chatID int64, messageID int) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	_, ok := g.cancels[fmt.Sprintf("%d:%d", chatID, messageID)]
+
+	return ok
+}
+
+// stop cancels the generation of the message.
+func (g *generations) stop(chatID int64, messageID int) bool {
+	g.mu.Lock()
+	cancel, ok := g.cancels[fmt.Sprintf("%d:%d", chatID, messageID)]
+	g.mu.Unlock()
+
+	if ok {
+		cancel()
+	}
+
+	return ok
+}
+
+// throttle returns the progress function which calls fn at most once per progressInterval.
+func throttle(fn func(text string)) oai.ProgressFunc {
+	var last time.Time
+
+	return func(text string) {
+		if time.Since(last) < progressInterval {
+			return
+		}
+
+		last = time.Now()
+		fn(text)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/ivanglie/chatgpt-bot/internal/oai"
+	"github.com/ivanglie/chatgpt-bot/internal/store"
+	"github.com/ivanglie/chatgpt-bot/internal/tg"
+	log "github.com/rs/zerolog/log"
+)
+
+// stallTimeout is the time without polling for updates after which the bot is not healthy.
+// It is longer than the timeout of long polling.
+const stallTimeout = 3 * time.Minute
+
+// checkTimeout limits a readiness check.
+const checkTimeout = 5 * time.Second
+
+// health probes the bot and its dependencies.
+type health struct {
+	bot     *tg.TelegramBot
+	ai      *oai.OpenAI
+	store   *store.Store
+	started time.Time
+}
+
+// check is the result of a probe in the JSON detail.
+type check struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+func newCheck(err error) check {
+	if err != nil {
+		return check{Status: "fail", Error: err.Error()}
+	}
+
+	return check{Status: "ok"}
+}
+
+// healthz reports whether the process is alive and the update loop is not stalled.
+func (h *health) healthz(w http.ResponseWriter, _ *http.Request) {
+	var err error
+
+	lastPoll := h.bot.LastPoll()
+	if lastPoll.IsZero() {
+		lastPoll = h.started
+	}
+
+	if stalled := time.Since(lastPoll); stalled > stallTimeout {
+		err = fmt.Errorf("no updates polled for %s", stalled.Round(time.Second))
+	}
+
+	c := newCheck(err)
+	writeHealth(w, c.Status, map[string]any{
+		"status":    c.Status,
+		"error":     c.Error,
+		"uptime":    time.Since(h.started).Round(time.Second).String(),
+		"last_poll": lastPoll.Format(time.RFC3339),
+	})
+}
+
+// readyz reports whether Telegram, the API and the store are reachable.
+func (h *health) readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+	defer cancel()
+
+	checks := map[string]check{
+		"telegram": newCheck(h.bot.Ping()),
+		"openai":   newCheck(h.ai.Ping(ctx)),
+		"store":    newCheck(h.store.Ping()),
+	}
+
+	status := "ok"
+	for name, c := range checks {
+		if c.Status != "ok" {
+			log.Error().Str("check", name).Str("error", c.Error).Msg("readiness check failed")
+			status = "fail"
+		}
+	}
+
+	writeHealth(w, status, map[string]any{"status": status, "checks": checks})
+}
+
+// writeHealth writes the JSON detail with 503 status if the check failed.
+func writeHealth(w http.ResponseWriter, status string, detail map[string]any) {
+	w.Header().Set("Content-Type", "application/json")
+	if status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	if err := json.NewEncoder(w).Encode(detail); err != nil {
+		log.Error().Err(err).Msg("failed to write the health detail")
+	}
+}
+
+// healthcheck requests /healthz of the running bot at the address
+// and returns the exit code for the HEALTHCHECK of Docker.
+func healthcheck(addr string) int {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		host = "localhost"
+	}
+
+	client := http.Client{Timeout: checkTimeout}
+
+	res, err := client.Get("http://" + net.JoinHostPort(host, port) + "/healthz")
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		fmt.Println(res.Status)
+		return 1
+	}
+
+	return 0
+}
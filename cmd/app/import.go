@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/ivanglie/chatgpt-bot/internal/tg"
+	"github.com/ivanglie/chatgpt-bot/internal/transcript"
+	"github.com/rs/zerolog"
+)
+
+// maxImportBytes limits the size of the imported files.
+const maxImportBytes = 1 << 20
+
+const importUsage = "Send a JSON file exported with /export json or an OpenAI messages array " +
+	"to continue it as a new conversation. In groups add the caption /import or reply /import to the file."
+
+// handleImport imports the document the command replies to.
+func (a *app) handleImport(ctx context.Context, msg *tgbotapi.Message, threadID int) {
+	if msg.ReplyToMessage == nil || msg.ReplyToMessage.Document == nil {
+		a.bot.Send(msg.Chat.ID, threadID, importUsage)
+		return
+	}
+
+	a.importDocument(ctx, msg, msg.ReplyToMessage.Document, threadID)
+}
+
+// handleDocument imports the document sent to the bot and reports whether it is meant to be imported:
+// JSON files in private chats and files with the /import caption in groups.
+func (a *app) handleDocument(ctx context.Context, msg *tgbotapi.Message, threadID int) bool {
+	doc := msg.Document
+
+	caption := strings.Fields(msg.Caption)
+	command := len(caption) > 0 && (caption[0] == "/import" || strings.EqualFold(caption[0], "/import@"+a.bot.UserName()))
+
+	json := doc.MimeType == "application/json" || strings.EqualFold(path.Ext(doc.FileName), ".json")
+	if !command && !(msg.Chat.IsPrivate() && json) {
+		return false
+	}
+
+	a.importDocument(ctx, msg, doc, threadID)
+
+	return true
+}
+
+// importDocument validates the conversation in the document and makes it the active one.
+func (a *app) importDocument(ctx context.Context, msg *tgbotapi.Message, doc *tgbotapi.Document, threadID int) {
+	if !a.allowed(msg.From) {
+		zerolog.Ctx(ctx).Warn().Msg("access denied")
+		a.metrics.denials.Inc("import")
+		a.bot.Send(msg.Chat.ID, threadID, "Access denied.")
+
+		return
+	}
+
+	if doc.FileSize > maxImportBytes {
+		a.bot.Send(msg.Chat.ID, threadID, fmt.Sprintf("The file is too large, the limit is %d KB.", maxImportBytes>>10))
+		return
+	}
+
+	data, err := a.bot.Download(doc.FileID, maxImportBytes)
+	if errors.Is(err, tg.ErrFileTooLarge) {
+		a.bot.Send(msg.Chat.ID, threadID, fmt.Sprintf("The file is too large, the limit is %d KB.", maxImportBytes>>10))
+		return
+	}
+
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to download the file")
+		a.bot.Send(msg.Chat.ID, threadID, "Failed to download the file.")
+
+		return
+	}
+
+	t, err := transcript.Parse(data)
+	if err == nil {
+		err = transcript.Validate(t, a.isBotAdmin(msg.From), a.importTokens)
+	}
+
+	if errors.Is(err, transcript.ErrSystemMessage) {
+		a.bot.Send(msg.Chat.ID, threadID, "Only the bot admins can import system messages.")
+		return
+	}
+
+	if err != nil {
+		zerolog.Ctx(ctx).Info().Err(err).Msg("invalid conversation")
+		a.bot.Send(msg.Chat.ID, threadID, "Failed to import the conversation: "+err.Error()+".")
+
+		return
+	}
+
+	scope := a.scope(msg.Chat.ID, msg.From.ID, threadID)
+
+	c, err := a.convs.Start(scope.String())
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to start a conversation")
+		a.bot.Send(msg.Chat.ID, threadID, "Failed to import the conversation.")
+
+		return
+	}
+
+	key := scope
+	key.Conversation = c.ID
+	a.ai.Import(key, t.Messages)
+
+	title := t.Title
+	for _, m := range t.Messages {
+		if title != "" {
+			break
+		}
+
+		if m.Role == transcript.RoleUser {
+			title = m.Content
+		}
+	}
+
+	a.touch(ctx, key, title)
+
+	zerolog.Ctx(ctx).Info().Int("messages", len(t.Messages)).Msg("conversation imported")
+	a.bot.Send(msg.Chat.ID, threadID, fmt.Sprintf("Imported %d messages as a new conversation. Send a message to continue it.", len(t.Messages)))
+}
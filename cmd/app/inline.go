@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/ivanglie/chatgpt-bot/internal/moderation"
+	"github.com/ivanglie/chatgpt-bot/internal/tg"
+	"github.com/rs/zerolog"
+)
+
+const (
+	inlineDebounce  = 800 * time.Millisecond // waits for the user to stop typing
+	inlineWindow    = 7 * time.Second        // to answer since the query is received, well within the validity of inline queries
+	inlineTimeout   = 30 * time.Second       // of the answer generated for the cache after the window
+	inlineCacheSize = 1000
+)
+
+// inlinePending is the hint for a query whose answer is not ready within the window.
+const inlinePending = "Not ready yet, send the query again in a moment"
+
+// inlineQueries debounces inline queries and caches their answers.
+type inlineQueries struct {
+	mu      sync.Mutex
+	latest  map[int64]string  // user ID -> ID of the latest query
+	answers map[string]string // query -> answer
+}
+
+func newInlineQueries() *inlineQueries {
+	return &inlineQueries{latest: make(map[int64]string), answers: make(map[string]string)}
+}
+
+// wait registers the query as the latest one of the user and waits for the debounce interval.
+// It returns false if the user has sent a newer query meanwhile.
+func (q *inlineQueries) wait(userID int64, queryID string) bool {
+	q.mu.Lock()
+	q.latest[userID] = queryID
+	q.mu.Unlock()
+
+	time.Sleep(inlineDebounce)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.latest[userID] != queryID {
+		return false
+	}
+
+	delete(q.latest, userID)
+
+	return true
+}
+
+func (q *inlineQueries) answer(query string) (string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	answer, ok := q.answers[query]
+
+	return answer, ok
+}
+
+func (q *inlineQueries) store(query, answer string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.answers) >= inlineCacheSize {
+		q.answers = make(map[string]string)
+	}
+
+	q.answers[query] = answer
+}
+
+// handleInline answers the inline query with a generated response.
+func (a *app) handleInline(ctx context.Context, update tg.Update) {
+	query := update.InlineQuery
+	text := strings.TrimSpace(query.Query)
+
+	if text == "" {
+		return
+	}
+
+	id := resultID(text)
+
+	if !a.allowed(query.From) {
+		zerolog.Ctx(ctx).Warn().Msg("access denied")
+		a.metrics.denials.Inc("inline_query")
+
+		if err := a.bot.AnswerInline(query.ID, id, "Access denied.", "Access denied."); err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Msg("failed to answer the inline query")
+		}
+
+		return
+	}
+
+	if answer, ok := a.inline.answer(text); ok {
+		if err := a.bot.AnswerInline(query.ID, id, text, answer); err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Msg("failed to answer the inline query")
+		}
+
+		return
+	}
+
+	window := time.NewTimer(inlineWindow)
+
+	go func() {
+		defer window.Stop()
+
+		if !a.inline.wait(query.From.ID, query.ID) {
+			return
+		}
+
+		a.logText(ctx, "inline request", text)
+
+		// The answer is still generated after the window, so that it is cached for the query sent again
+		answers := make(chan string, 1)
+		go func() {
+			answers <- a.completeInline(ctx, query.From, text)
+		}()
+
+		var err error
+		select {
+		case answer := <-answers:
+			if answer == "" {
+				err = a.bot.AnswerInlineHint(query.ID, "Failed to generate a response")
+			} else {
+				err = a.bot.AnswerInline(query.ID, id, text, answer)
+			}
+		case <-window.C:
+			zerolog.Ctx(ctx).Warn().Msg("the inline answer is not ready in time")
+			err = a.bot.AnswerInlineHint(query.ID, inlinePending)
+		}
+
+		if err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Msg("failed to answer the inline query")
+		}
+	}()
+}
+
+// completeInline generates the answer to the inline query and caches it. It returns an empty answer on failure.
+func (a *app) completeInline(ctx context.Context, user *tgbotapi.User, text string) string {
+	ctx, cancel := context.WithTimeout(ctx, inlineTimeout)
+	defer cancel()
+
+	if a.moderate(ctx, moderation.StageInput, 0, user, text).Has(moderation.ActionBlock) {
+		return "The message is blocked by moderation."
+	}
+
+	res, err := a.ai.Complete(ctx, text)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to generate a response")
+		return ""
+	}
+
+	if a.moderate(ctx, moderation.StageOutput, 0, user, res.Text).Has(moderation.ActionBlock) {
+		res.Text = "The response is blocked by moderation."
+	}
+
+	a.inline.store(text, res.Text)
+
+	return res.Text
+}
+
+// handleChosenInline records the inline result sent by the user.
+func (a *app) handleChosenInline(ctx context.Context, update tg.Update) {
+	result := update.ChosenInlineResult
+	zerolog.Ctx(ctx).Info().Str("result_id", result.ResultID).Msg("inline result chosen")
+	a.logText(ctx, "chosen inline query", result.Query)
+}
+
+// resultID returns the ID of the inline result for the query.
+func resultID(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:16])
+}
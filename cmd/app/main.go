@@ -1,23 +1,109 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/ivanglie/chatgpt-bot/internal/conversation"
+	"github.com/ivanglie/chatgpt-bot/internal/memory"
+	"github.com/ivanglie/chatgpt-bot/internal/moderation"
 	"github.com/ivanglie/chatgpt-bot/internal/oai"
+	"github.com/ivanglie/chatgpt-bot/internal/redact"
+	"github.com/ivanglie/chatgpt-bot/internal/scheduler"
+	"github.com/ivanglie/chatgpt-bot/internal/search"
+	"github.com/ivanglie/chatgpt-bot/internal/stats"
+	"github.com/ivanglie/chatgpt-bot/internal/store"
 	"github.com/ivanglie/chatgpt-bot/internal/tg"
+	"github.com/ivanglie/chatgpt-bot/internal/users"
+	"github.com/ivanglie/chatgpt-bot/internal/web"
 	"github.com/jessevdk/go-flags"
 	"github.com/rs/zerolog"
 	log "github.com/rs/zerolog/log"
 	"golang.org/x/exp/slices"
 )
 
+// Intervals of the background jobs.
+const (
+	janitorInterval   = time.Minute      // checks for idle conversations
+	schedulerInterval = 10 * time.Second // checks for due jobs
+)
+
 var (
 	opts struct {
 		BotToken     string   `long:"bottoken" env:"BOT_TOKEN" description:"bot token for Telegram"`
 		OnenAIAPIKey string   `long:"openaiapikey" env:"OPENAI_API_KEY" description:"key for OpenAI API"`
 		BotUsers     []string `long:"botusers" env:"BOT_USERS" env-delim:"," description:"bot users"`
+		Tools        []string `long:"tools" env:"TOOLS" env-delim:"," description:"tools enabled by default (datetime, calculator, fetch)"`
+		BotAdmins    []int64  `long:"botadmins" env:"BOT_ADMINS" env-delim:"," description:"Telegram IDs of bot admins"`
+		Listen       string   `long:"listen" env:"LISTEN" default:":18080" description:"address of the HTTP server with metrics and health checks"`
+		Healthcheck  bool     `long:"healthcheck" description:"check the health of the running bot and exit"`
+		Store        string   `long:"store" env:"STORE" description:"file of the persistent store, in memory if empty"`
 		Dbg          bool     `long:"dbg" env:"DEBUG" description:"use debug"`
+
+		Log struct {
+			Format  string `long:"format" env:"FORMAT" choice:"json" choice:"console" default:"json" description:"format of the logs"`
+			Content bool   `long:"content" env:"CONTENT" description:"log the texts of requests and responses"`
+		} `group:"log" namespace:"log" env-namespace:"LOG"`
+
+		OpenAI struct {
+			APIType      string            `long:"apitype" env:"API_TYPE" choice:"openai" choice:"azure" choice:"compatible" default:"openai" description:"type of the API"`
+			BaseURL      string            `long:"baseurl" env:"BASE_URL" description:"base URL of the API"`
+			Organization string            `long:"org" env:"ORG" description:"organization for OpenAI API"`
+			APIVersion   string            `long:"apiversion" env:"API_VERSION" description:"version of Azure OpenAI API"`
+			Deployments  map[string]string `long:"deployment" env:"DEPLOYMENTS" env-delim:"," description:"model:deployment for Azure OpenAI"`
+			Model        string            `long:"model" env:"MODEL" default:"gpt-4o-mini" description:"model"`
+			Timeout      time.Duration     `long:"timeout" env:"TIMEOUT" default:"2m" description:"timeout of a request to the API"`
+			Proxy        string            `long:"proxy" env:"PROXY" description:"proxy URL for the API"`
+			Backends     string            `long:"backends" env:"BACKENDS" description:"JSON file with additional backends and the fallback chain"`
+		} `group:"openai" namespace:"openai" env-namespace:"OPENAI"`
+
+		Moderation struct {
+			Enabled    bool     `long:"enabled" env:"ENABLED" description:"moderate requests and responses with OpenAI API"`
+			Rules      []string `long:"rule" env:"RULES" env-delim:"," description:"category:threshold:action, action is block, warn or notify, * for any category; block flagged texts if empty"`
+			BaseURL    string   `long:"baseurl" env:"BASE_URL" description:"base URL of a separate moderation API, the one of OpenAI if only the key is set; the chat API is used if neither is set"`
+			APIKey     string   `long:"apikey" env:"API_KEY" description:"key for a separate moderation API, the one of the chat API if empty"`
+			FailClosed bool     `long:"failclosed" env:"FAIL_CLOSED" description:"block the texts when the moderation API fails instead of letting them through"`
+		} `group:"moderation" namespace:"moderation" env-namespace:"MODERATION"`
+
+		History struct {
+			IdleTTL      time.Duration `long:"ttl" env:"TTL" default:"24h" description:"forget conversations idle for longer, 0 to keep them"`
+			Max          int           `long:"max" env:"MAX" default:"10000" description:"maximum number of conversations in memory, 0 for no limit"`
+			MaxBytes     int           `long:"maxbytes" env:"MAX_BYTES" default:"268435456" description:"maximum estimated memory of conversations in bytes, 0 for no limit"`
+			ImportTokens int           `long:"importtokens" env:"IMPORT_TOKENS" default:"16000" description:"maximum estimated tokens of imported conversations, 0 for no limit"`
+		} `group:"history" namespace:"history" env-namespace:"HISTORY"`
+
+		Memory struct {
+			Enabled bool `long:"enabled" env:"ENABLED" description:"remember the facts about the users with /remember and the remember tool to recall them in new conversations"`
+		} `group:"memory" namespace:"memory" env-namespace:"MEMORY"`
+
+		Schedule struct {
+			CatchUp string `long:"catchup" env:"CATCHUP" choice:"skip" choice:"once" choice:"all" default:"once" description:"runs of the scheduled prompts missed while the bot was down"`
+		} `group:"schedule" namespace:"schedule" env-namespace:"SCHEDULE"`
+
+		Search struct {
+			Enabled bool   `long:"enabled" env:"ENABLED" description:"index the conversations with the embeddings API for the /search command"`
+			Model   string `long:"model" env:"MODEL" default:"text-embedding-3-small" description:"embedding model"`
+		} `group:"search" namespace:"search" env-namespace:"SEARCH"`
+
+		Stats struct {
+			Prices []string `long:"price" env:"PRICES" env-delim:"," description:"model:input:output prices in USD per million tokens, replace the default price of the model"`
+			Report string   `long:"report" env:"REPORT" description:"cron schedule of the CSV report of the statistics sent to the admin chat, e.g. 0 9 * * 1, disabled if empty"`
+			Chat   int64    `long:"chat" env:"CHAT" description:"chat of the scheduled report, the first bot admin if zero"`
+			Days   int      `long:"days" env:"DAYS" default:"7" description:"days covered by the scheduled report"`
+		} `group:"stats" namespace:"stats" env-namespace:"STATS"`
+
+		Redact struct {
+			Enabled  bool              `long:"enabled" env:"ENABLED" description:"replace emails, phone and card numbers and API keys with placeholders in requests to the API"`
+			Patterns map[string]string `long:"pattern" env:"PATTERNS" env-delim:";" description:"name:regexp of values to redact, replaces the default pattern with the same name, empty regexp disables it"`
+		} `group:"redact" namespace:"redact" env-namespace:"REDACT"`
 	}
 
 	version = "unknown"
@@ -29,62 +115,279 @@ func main() {
 	p := flags.NewParser(&opts, flags.Default)
 	if _, err := p.Parse(); err != nil {
 		if err.(*flags.Error).Type != flags.ErrHelp {
-			log.Printf("[ERROR] chatgpt-bot error: %v", err)
+			log.Error().Err(err).Msg("failed to parse the arguments")
 		}
 		os.Exit(2)
 	}
 
-	setupLog(opts.Dbg)
+	if opts.Healthcheck {
+		os.Exit(healthcheck(opts.Listen))
+	}
 
-	telegramBot, err := tg.New(opts.BotToken, opts.Dbg, 0, 60)
+	setupLog(opts.Dbg, opts.Log.Format)
+
+	telegramBot, err := tg.New(opts.BotToken, opts.Dbg, 0, 60, log.Logger.With().Str("component", "tg").Logger())
 	if err != nil {
 		log.Panic().Msg(err.Error())
 	}
 
-	openAI, err := oai.New(opts.OnenAIAPIKey, 1000, "")
+	openAI, err := oai.New(opts.OnenAIAPIKey, 1000, "", oai.Config{
+		APIType:      opts.OpenAI.APIType,
+		BaseURL:      opts.OpenAI.BaseURL,
+		Organization: opts.OpenAI.Organization,
+		APIVersion:   opts.OpenAI.APIVersion,
+		Deployments:  opts.OpenAI.Deployments,
+		Model:        opts.OpenAI.Model,
+		Timeout:      opts.OpenAI.Timeout,
+		Proxy:        opts.OpenAI.Proxy,
+	}, log.Logger.With().Str("component", "oai").Logger())
 	if err != nil {
 		log.Panic().Msg(err.Error())
 	}
 
-	users := opts.BotUsers
-	log.Debug().Msgf("users: %v, len: %d", users, len(users))
+	var redactor *redact.Redactor
+	if opts.Redact.Enabled {
+		patterns, err := redact.Patterns(opts.Redact.Patterns)
+		if err != nil {
+			log.Panic().Msg(err.Error())
+		}
+
+		redactor = redact.New(patterns)
+		openAI.SetRedactor(redactor)
+	}
 
-	updates := telegramBot.GetUpdatesChan()
+	if opts.OpenAI.Backends != "" {
+		if err := loadBackends(opts.OpenAI.Backends, openAI.Router()); err != nil {
+			log.Panic().Msg(err.Error())
+		}
+	}
+
+	st, err := store.Open(opts.Store)
+	if err != nil {
+		log.Panic().Msg(err.Error())
+	}
 
-	for update := range updates {
-		if update.Message == nil || update.Message.IsCommand() {
-			continue
+	var moderator *moderation.Moderator
+	if opts.Moderation.Enabled {
+		if moderator, err = newModerator(st, redactor); err != nil {
+			log.Panic().Msg(err.Error())
 		}
+	}
+
+	fetcher := web.New(10*time.Second, 2<<20, 8000)
+
+	for _, tool := range []oai.Tool{oai.DateTime{}, oai.Calculator{}, web.Tool{Fetcher: fetcher}} {
+		openAI.Tools().Register(tool, slices.Contains(opts.Tools, tool.Name()))
+	}
+
+	var mem *memory.Memory
+	if opts.Memory.Enabled {
+		mem = memory.New(st)
+		openAI.SetMemory(mem)
+		openAI.Tools().Register(memory.Tool{Memory: mem}, true)
+	}
+
+	var index *search.Index
+	if opts.Search.Enabled {
+		if index, err = newIndex(redactor); err != nil {
+			log.Panic().Msg(err.Error())
+		}
+	}
+
+	prices, err := stats.ParsePrices(opts.Stats.Prices)
+	if err != nil {
+		log.Panic().Msg(err.Error())
+	}
+	statistics := stats.New(st, prices)
+
+	log.Debug().Strs("users", opts.BotUsers).Msg("bot users")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	metrics := newMetrics(openAI)
+	openAI.Router().Wrap(metrics.client)
+	openAI.SetLimits(oai.Limits{
+		IdleTTL:          opts.History.IdleTTL,
+		MaxConversations: opts.History.Max,
+		MaxBytes:         opts.History.MaxBytes,
+		OnEvict:          func(reason string) { metrics.evictions.Inc(reason) },
+	})
+	go openAI.RunJanitor(ctx, janitorInterval)
+	if index != nil {
+		go index.Run(ctx)
+	}
+	if moderator != nil {
+		go checkModerator(ctx, moderator)
+	}
+	go serve(opts.Listen, metrics, &health{bot: telegramBot, ai: openAI, store: st, started: time.Now()})
+
+	bot := instrumentedBot{TelegramBot: telegramBot, metrics: metrics}
+	sched := scheduler.New(st, time.Local, log.Logger.With().Str("component", "scheduler").Logger())
+	if err := sched.SetCatchUp(opts.Schedule.CatchUp); err != nil {
+		log.Panic().Msg(err.Error())
+	}
+	a := newApp(bot, instrumentedAI{OpenAI: openAI, metrics: metrics, stats: statistics}, fetcher, moderator, metrics, conversation.New(st), mem, index, sched, users.New(st), statistics,
+		opts.BotUsers, opts.BotAdmins, opts.Log.Content, opts.History.ImportTokens)
+	if err := a.scheduleReport(opts.Stats.Report, reportChat(), opts.Stats.Days); err != nil {
+		log.Panic().Msg(err.Error())
+	}
+
+	// The jobs run after their handlers are set by the app
+	go sched.Run(ctx, schedulerInterval)
 
-		if user := update.Message.From.UserName; len(users) != 0 && !slices.Contains(users, user) {
-			log.Error().Msgf("user %s is not allowed", update.Message.From.String())
-			telegramBot.Send(update.Message.Chat.ID, "Access denied.")
+	updates := bot.GetUpdatesChan()
 
-			continue
+	for {
+		select {
+		case <-ctx.Done():
+			if err := statistics.Flush(); err != nil {
+				log.Error().Err(err).Msg("failed to save the statistics")
+			}
+			log.Info().Msg("stopped")
+			return
+		case update := <-updates:
+			a.handle(update)
 		}
+	}
+}
+
+// reportChat returns the chat of the scheduled report of the statistics.
+func reportChat() int64 {
+	if opts.Stats.Chat == 0 && len(opts.BotAdmins) > 0 {
+		return opts.BotAdmins[0]
+	}
 
-		log.Debug().Msgf("user: %s, request: %s", update.Message.From.String(), update.Message.Text)
+	return opts.Stats.Chat
+}
+
+// serve runs the HTTP server with the metrics and the health checks.
+func serve(addr string, metrics *appMetrics, h *health) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.registry)
+	mux.HandleFunc("/healthz", h.healthz)
+	mux.HandleFunc("/readyz", h.readyz)
 
-		userID := fmt.Sprintf("%d", update.Message.From.ID)
-		chatID := fmt.Sprintf("%d", update.Message.Chat.ID)
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	if err := server.ListenAndServe(); err != nil {
+		log.Error().Err(err).Msg("HTTP server failed")
+	}
+}
 
-		res, err := openAI.Generate(userID, chatID, update.Message.Text)
+// newModerator makes the moderator for the OpenAI API endpoint which redacts the texts if the redactor is not nil.
+func newModerator(st *store.Store, redactor *redact.Redactor) (*moderation.Moderator, error) {
+	var rules []moderation.Rule
+	for _, r := range opts.Moderation.Rules {
+		rule, err := moderation.ParseRule(r)
 		if err != nil {
-			log.Error().Msg(err.Error())
-			continue
+			return nil, err
 		}
 
-		log.Debug().Msgf("user: %s, response: %s", update.Message.From.String(), res)
+		rules = append(rules, rule)
+	}
 
-		telegramBot.Send(update.Message.Chat.ID, res)
+	config := oai.Config{
+		APIType:      opts.OpenAI.APIType,
+		BaseURL:      opts.OpenAI.BaseURL,
+		Organization: opts.OpenAI.Organization,
+		APIVersion:   opts.OpenAI.APIVersion,
+		Timeout:      opts.OpenAI.Timeout,
+		Proxy:        opts.OpenAI.Proxy,
 	}
+	key := opts.OnenAIAPIKey
+
+	if opts.Moderation.BaseURL != "" || opts.Moderation.APIKey != "" {
+		// The default base URL is the one of OpenAI
+		config = oai.Config{APIType: oai.APITypeOpenAI, BaseURL: opts.Moderation.BaseURL, Timeout: opts.OpenAI.Timeout, Proxy: opts.OpenAI.Proxy}
+		if opts.Moderation.APIKey != "" {
+			key = opts.Moderation.APIKey
+		}
+	} else if opts.OpenAI.APIType == oai.APITypeCompatible {
+		log.Warn().Msg("moderation uses the compatible chat API which may not serve moderations, set the moderation API if it fails")
+	}
+
+	client, err := oai.NewClient(key, config)
+	if err != nil {
+		return nil, err
+	}
+
+	m := moderation.New(client, rules, st)
+	m.FailClosed = opts.Moderation.FailClosed
+	if redactor != nil {
+		m.Redact = func(text string) string { return redactor.Redact(redact.NewMapping(), text) }
+	}
+
+	return m, nil
 }
 
-func setupLog(dbg bool) {
-	if dbg {
-		log.Level(zerolog.DebugLevel)
+// checkModerator logs whether the moderation API works, so that the texts are not left unmoderated unnoticed.
+func checkModerator(ctx context.Context, m *moderation.Moderator) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	if _, err := m.Check(ctx, "ping"); err != nil {
+		if m.FailClosed {
+			log.Error().Err(err).Msg("the moderation API is unavailable, all texts are blocked until it works")
+		} else {
+			log.Error().Err(err).Msg("the moderation API is unavailable, texts are not moderated until it works")
+		}
+
 		return
 	}
 
-	log.Level(zerolog.InfoLevel)
+	log.Info().Msg("the moderation API is available")
+}
+
+// newIndex makes the search index of the conversations which redacts the texts if the redactor is not nil.
+// The embeddings are kept in a file per user, so that indexing an exchange does not rewrite the whole store.
+func newIndex(redactor *redact.Redactor) (*search.Index, error) {
+	st, err := store.OpenDir(searchDir(opts.Store))
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := oai.NewClient(opts.OnenAIAPIKey, oai.Config{
+		APIType:      opts.OpenAI.APIType,
+		BaseURL:      opts.OpenAI.BaseURL,
+		Organization: opts.OpenAI.Organization,
+		APIVersion:   opts.OpenAI.APIVersion,
+		Deployments:  opts.OpenAI.Deployments,
+		Timeout:      opts.OpenAI.Timeout,
+		Proxy:        opts.OpenAI.Proxy,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	index := search.New(client, opts.Search.Model, st, log.Logger.With().Str("component", "search").Logger())
+	if redactor != nil {
+		index.Redact = func(text string) string { return redactor.Redact(redact.NewMapping(), text) }
+	}
+
+	return index, nil
+}
+
+// searchDir returns the directory of the search index next to the file of the store, none if the store is in memory.
+func searchDir(storePath string) string {
+	if storePath == "" {
+		return ""
+	}
+
+	return strings.TrimSuffix(storePath, filepath.Ext(storePath)) + "-search"
+}
+
+// setupLog sets up the global logger which is also used for the contexts without a logger.
+func setupLog(dbg bool, format string) {
+	var w io.Writer = os.Stderr
+	if format == "console" {
+		w = zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339}
+	}
+
+	level := zerolog.InfoLevel
+	if dbg {
+		level = zerolog.DebugLevel
+	}
+
+	log.Logger = zerolog.New(w).Level(level).With().Timestamp().Logger()
+	zerolog.DefaultContextLogger = &log.Logger
 }
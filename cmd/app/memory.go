@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/ivanglie/chatgpt-bot/internal/memory"
+	"github.com/rs/zerolog"
+)
+
+// Actions of the buttons to forget the facts: the prefix followed by the ID of the fact and forgetting all of them.
+const (
+	actionForget    = "mem-"
+	actionForgetAll = "mem-all"
+)
+
+// handleRemember saves the fact about the user.
+func (a *app) handleRemember(ctx context.Context, msg *tgbotapi.Message, threadID int) {
+	if !a.canRemember(msg, threadID) {
+		return
+	}
+
+	text := strings.TrimSpace(msg.CommandArguments())
+	if text == "" {
+		a.bot.Send(msg.Chat.ID, threadID, "Usage: /remember fact, e.g. /remember I write Go, answer with code examples")
+		return
+	}
+
+	if _, err := a.memory.Remember(userKey(msg.From), text); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to remember the fact")
+		a.bot.Send(msg.Chat.ID, threadID, "Failed to remember the fact.")
+
+		return
+	}
+
+	a.bot.Send(msg.Chat.ID, threadID, "Remembered. New conversations will take it into account.")
+}
+
+// handleMemory lists the facts about the user with the buttons to forget them.
+// The facts are personal, so they are shown in private chats only.
+func (a *app) handleMemory(ctx context.Context, msg *tgbotapi.Message, threadID int) {
+	if !a.canRemember(msg, threadID) {
+		return
+	}
+
+	if !msg.Chat.IsPrivate() {
+		a.bot.Send(msg.Chat.ID, threadID, "Use /memory in a private chat with the bot.")
+		return
+	}
+
+	facts, err := a.memory.Facts(userKey(msg.From))
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to get the facts")
+		return
+	}
+
+	if len(facts) == 0 {
+		a.bot.Send(msg.Chat.ID, threadID, "I remember nothing about you. Use /remember to tell me a fact.")
+		return
+	}
+
+	var (
+		sb      strings.Builder
+		buttons []tgbotapi.InlineKeyboardButton
+	)
+
+	sb.WriteString("What I remember about you:\n")
+
+	for i, f := range facts {
+		fmt.Fprintf(&sb, "%d. %s\n", i+1, f.Text)
+		buttons = append(buttons, a.bot.Button(fmt.Sprintf("Forget %d. %s", i+1, short(f.Text)), actionForget+strconv.Itoa(f.ID), msg.Chat.ID, msg.From.ID))
+	}
+
+	buttons = append(buttons, a.bot.Button("Forget everything", actionForgetAll, msg.Chat.ID, msg.From.ID))
+
+	a.bot.SendMenu(msg.Chat.ID, threadID, sb.String(), buttons...)
+}
+
+// handleMemoryCallback forgets the chosen facts and reports whether the action is to forget.
+func (a *app) handleMemoryCallback(ctx context.Context, query *tgbotapi.CallbackQuery, action string) bool {
+	suffix, ok := strings.CutPrefix(action, actionForget)
+	if !ok {
+		return false
+	}
+
+	if a.memory == nil {
+		a.bot.AnswerCallback(query.ID, "Memory is disabled.")
+		return true
+	}
+
+	var (
+		err  error
+		text string
+	)
+
+	userID := userKey(query.From)
+	if action == actionForgetAll {
+		err, text = a.memory.Clear(userID), "Forgot everything about you."
+	} else if id, convErr := strconv.Atoi(suffix); convErr != nil {
+		err = memory.ErrNotFound
+	} else {
+		err, text = a.memory.Forget(userID, id), "Forgotten."
+	}
+
+	if errors.Is(err, memory.ErrNotFound) {
+		a.bot.AnswerCallback(query.ID, "The fact is already forgotten.")
+		return true
+	}
+
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to forget the facts")
+		a.bot.AnswerCallback(query.ID, "Failed to forget.")
+
+		return true
+	}
+
+	a.bot.AnswerCallback(query.ID, text)
+
+	if action == actionForgetAll {
+		a.bot.Edit(query.Message.Chat.ID, query.Message.MessageID, text)
+	}
+
+	return true
+}
+
+// canRemember reports whether the memory is enabled.
+func (a *app) canRemember(msg *tgbotapi.Message, threadID int) bool {
+	if a.memory == nil {
+		a.bot.Send(msg.Chat.ID, threadID, "Memory is disabled.")
+		return false
+	}
+
+	return true
+}
+
+// userKey returns the key of the facts about the Telegram user.
+func userKey(user *tgbotapi.User) string {
+	return strconv.FormatInt(user.ID, 10)
+}
+
+// short returns the beginning of the text for a button.
+func short(text string) string {
+	const maxButton = 30
+	if utf8.RuneCountInString(text) <= maxButton {
+		return text
+	}
+
+	return string([]rune(text)[:maxButton-1]) + "…"
+}
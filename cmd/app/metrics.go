@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/ivanglie/chatgpt-bot/internal/metrics"
+	"github.com/ivanglie/chatgpt-bot/internal/oai"
+	"github.com/ivanglie/chatgpt-bot/internal/stats"
+	"github.com/ivanglie/chatgpt-bot/internal/tg"
+	"github.com/rs/zerolog"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// appMetrics are the metrics of the bot served at /metrics.
+type appMetrics struct {
+	registry *metrics.Registry
+
+	updates        *metrics.Counter
+	answers        *metrics.Counter
+	denials        *metrics.Counter
+	telegramErrors *metrics.Counter
+	openaiLatency  *metrics.Histogram
+	openaiErrors   *metrics.Counter
+	tokens         *metrics.Counter
+	evictions      *metrics.Counter
+}
+
+func newMetrics(ai *oai.OpenAI) *appMetrics {
+	r := metrics.NewRegistry()
+
+	m := &appMetrics{
+		registry:       r,
+		updates:        r.NewCounter("chatgpt_bot_updates_total", "Updates received from Telegram.", "type"),
+		answers:        r.NewCounter("chatgpt_bot_answers_total", "Responses generated.", "kind"),
+		denials:        r.NewCounter("chatgpt_bot_access_denials_total", "Requests of users without access.", "type"),
+		telegramErrors: r.NewCounter("chatgpt_bot_telegram_errors_total", "Failed requests to Telegram.", "method"),
+		openaiLatency:  r.NewHistogram("chatgpt_bot_openai_request_duration_seconds", "Latency of requests to the API.", metrics.DefaultBuckets, "backend", "model"),
+		openaiErrors:   r.NewCounter("chatgpt_bot_openai_errors_total", "Failed requests to the API.", "backend", "type", "code"),
+		tokens:         r.NewCounter("chatgpt_bot_tokens_total", "Tokens consumed.", "backend", "model", "kind"),
+		evictions:      r.NewCounter("chatgpt_bot_conversation_evictions_total", "Conversations evicted from memory.", "reason"),
+	}
+
+	r.NewGaugeFunc("chatgpt_bot_conversations", "Conversations in memory.", func() float64 {
+		return float64(ai.Conversations())
+	})
+
+	return m
+}
+
+// instrumentedBot counts the updates and the errors of the Telegram bot.
+type instrumentedBot struct {
+	*tg.TelegramBot
+	metrics *appMetrics
+}
+
+// GetUpdatesChan counts the updates by type.
+func (b instrumentedBot) GetUpdatesChan() <-chan tg.Update {
+	updates := make(chan tg.Update)
+
+	go func() {
+		defer close(updates)
+
+		for update := range b.TelegramBot.GetUpdatesChan() {
+			b.metrics.updates.Inc(updateType(update))
+			updates <- update
+		}
+	}()
+
+	return updates
+}
+
+func (b instrumentedBot) Send(chatID int64, threadID int, request string, buttons ...tgbotapi.InlineKeyboardButton) (tgbotapi.Message, error) {
+	msg, err := b.TelegramBot.Send(chatID, threadID, request, buttons...)
+	b.count("sendMessage", err)
+
+	return msg, err
+}
+
+func (b instrumentedBot) SendMenu(chatID int64, threadID int, text string, buttons ...tgbotapi.InlineKeyboardButton) (tgbotapi.Message, error) {
+	msg, err := b.TelegramBot.SendMenu(chatID, threadID, text, buttons...)
+	b.count("sendMessage", err)
+
+	return msg, err
+}
+
+func (b instrumentedBot) SendDocument(chatID int64, threadID int, name string, data []byte, caption string) (tgbotapi.Message, error) {
+	msg, err := b.TelegramBot.SendDocument(chatID, threadID, name, data, caption)
+	b.count("sendDocument", err)
+
+	return msg, err
+}
+
+func (b instrumentedBot) Download(fileID string, maxBytes int64) ([]byte, error) {
+	data, err := b.TelegramBot.Download(fileID, maxBytes)
+	if !errors.Is(err, tg.ErrFileTooLarge) {
+		b.count("getFile", err)
+	}
+
+	return data, err
+}
+
+func (b instrumentedBot) Edit(chatID int64, messageID int, text string, buttons ...tgbotapi.InlineKeyboardButton) error {
+	err := b.TelegramBot.Edit(chatID, messageID, text, buttons...)
+	b.count("editMessageText", err)
+
+	return err
+}
+
+func (b instrumentedBot) AnswerCallback(queryID, text string) error {
+	err := b.TelegramBot.AnswerCallback(queryID, text)
+	b.count("answerCallbackQuery", err)
+
+	return err
+}
+
+func (b instrumentedBot) AnswerInline(queryID, resultID, title, text string) error {
+	err := b.TelegramBot.AnswerInline(queryID, resultID, title, text)
+	b.count("answerInlineQuery", err)
+
+	return err
+}
+
+func (b instrumentedBot) AnswerInlineHint(queryID, hint string) error {
+	err := b.TelegramBot.AnswerInlineHint(queryID, hint)
+	b.count("answerInlineQuery", err)
+
+	return err
+}
+
+func (b instrumentedBot) count(method string, err error) {
+	if err != nil {
+		b.metrics.telegramErrors.Inc(method)
+	}
+}
+
+// updateType returns the type of the update for the metrics.
+func updateType(update tg.Update) string {
+	switch {
+	case update.Message != nil:
+		return "message"
+	case update.EditedMessage != nil:
+		return "edited_message"
+	case update.CallbackQuery != nil:
+		return "callback_query"
+	case update.InlineQuery != nil:
+		return "inline_query"
+	case update.ChosenInlineResult != nil:
+		return "chosen_inline_result"
+	default:
+		return "other"
+	}
+}
+
+// instrumentedAI counts the responses, the tokens they consumed and the failed requests.
+type instrumentedAI struct {
+	*oai.OpenAI
+	metrics *appMetrics
+	stats   *stats.Stats
+}
+
+func (o instrumentedAI) Generate(ctx context.Context, key oai.Key, request oai.Request, progress oai.ProgressFunc) (oai.Response, error) {
+	res, err := o.OpenAI.Generate(ctx, key, request, progress)
+	return o.count(ctx, "generate", res, err)
+}
+
+func (o instrumentedAI) Complete(ctx context.Context, request string) (oai.Response, error) {
+	res, err := o.OpenAI.Complete(ctx, request)
+	return o.count(ctx, "complete", res, err)
+}
+
+func (o instrumentedAI) RunPrompt(ctx context.Context, chatID string, p oai.Prompt) (oai.Response, error) {
+	res, err := o.OpenAI.RunPrompt(ctx, chatID, p)
+	return o.count(ctx, "prompt", res, err)
+}
+
+func (o instrumentedAI) Regenerate(ctx context.Context, key oai.Key, responseID int, progress oai.ProgressFunc) (oai.Response, error) {
+	res, err := o.OpenAI.Regenerate(ctx, key, responseID, progress)
+	return o.count(ctx, "regenerate", res, err)
+}
+
+func (o instrumentedAI) Continue(ctx context.Context, key oai.Key, responseID int, progress oai.ProgressFunc) (oai.Response, error) {
+	res, err := o.OpenAI.Continue(ctx, key, responseID, progress)
+	return o.count(ctx, "continue", res, err)
+}
+
+func (o instrumentedAI) Edit(ctx context.Context, key oai.Key, request oai.Request, progress oai.ProgressFunc) (oai.Response, error) {
+	res, err := o.OpenAI.Edit(ctx, key, request, progress)
+	return o.count(ctx, "edit", res, err)
+}
+
+func (o instrumentedAI) count(ctx context.Context, kind string, res oai.Response, err error) (oai.Response, error) {
+	if err != nil {
+		if !errors.Is(err, context.Canceled) {
+			o.record(ctx, o.stats.Error(kind))
+		}
+
+		return res, err
+	}
+
+	o.metrics.answers.Inc(kind)
+	o.metrics.tokens.Add(float64(res.Usage.PromptTokens), res.Backend, res.Model, "prompt")
+	o.metrics.tokens.Add(float64(res.Usage.CompletionTokens), res.Backend, res.Model, "completion")
+	o.record(ctx, o.stats.Usage(oai.User(ctx), kind, res.Backend, res.Model, res.Usage.PromptTokens, res.Usage.CompletionTokens))
+
+	return res, err
+}
+
+// record logs the error of the statistics.
+func (o instrumentedAI) record(ctx context.Context, err error) {
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to record the statistics")
+	}
+}
+
+// instrumentedClient measures the latency and counts the errors of the requests to a backend.
+type instrumentedClient struct {
+	oai.OpenAIClient
+	backend string
+	metrics *appMetrics
+}
+
+// client instruments the client of the backend.
+func (m *appMetrics) client(b oai.Backend) oai.OpenAIClient {
+	return instrumentedClient{OpenAIClient: b.Client, backend: b.Name, metrics: m}
+}
+
+func (c instrumentedClient) CreateChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	start := time.Now()
+	res, err := c.OpenAIClient.CreateChatCompletion(ctx, req)
+	c.observe(req.Model, start, err)
+
+	return res, err
+}
+
+// CreateChatCompletionStream measures the latency until the response starts.
+func (c instrumentedClient) CreateChatCompletionStream(ctx context.Context, req openai.ChatCompletionRequest) (*openai.ChatCompletionStream, error) {
+	start := time.Now()
+	s, err := c.OpenAIClient.CreateChatCompletionStream(ctx, req)
+	c.observe(req.Model, start, err)
+
+	return s, err
+}
+
+func (c instrumentedClient) observe(model string, start time.Time, err error) {
+	c.metrics.openaiLatency.Observe(time.Since(start).Seconds(), c.backend, model)
+
+	if err != nil {
+		typ, code := errorType(err)
+		c.metrics.openaiErrors.Inc(c.backend, typ, code)
+	}
+}
+
+// errorType returns the type and the HTTP status code of the error of the API.
+func errorType(err error) (typ, code string) {
+	var (
+		apiErr *openai.APIError
+		reqErr *openai.RequestError
+	)
+
+	switch {
+	case errors.As(err, &apiErr):
+		typ = apiErr.Type
+		if typ == "" {
+			typ = "api"
+		}
+
+		return typ, strconv.Itoa(apiErr.HTTPStatusCode)
+	case errors.As(err, &reqErr):
+		return "request", strconv.Itoa(reqErr.HTTPStatusCode)
+	case errors.Is(err, context.Canceled):
+		return "canceled", ""
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout", ""
+	default:
+		return "network", ""
+	}
+}
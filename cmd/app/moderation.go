@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/ivanglie/chatgpt-bot/internal/moderation"
+	"github.com/rs/zerolog"
+	"golang.org/x/exp/slices"
+)
+
+// moderate checks the text, records the flagged event and notifies the admins if needed.
+// The texts are not moderated if moderation is disabled, or if it is unavailable unless it fails closed.
+func (a *app) moderate(ctx context.Context, stage string, chatID int64, user *tgbotapi.User, text string) moderation.Verdict {
+	if a.moderator == nil {
+		return moderation.Verdict{}
+	}
+
+	v, err := a.moderator.Check(ctx, text)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Bool("fail_closed", a.moderator.FailClosed).Msg("moderation failed")
+
+		if a.moderator.FailClosed {
+			return moderation.Verdict{Actions: []string{moderation.ActionBlock}}
+		}
+
+		return moderation.Verdict{}
+	}
+
+	if len(v.Actions) == 0 {
+		return v
+	}
+
+	zerolog.Ctx(ctx).Info().Str("stage", stage).Strs("categories", v.Categories).Strs("actions", v.Actions).Msg("text flagged")
+
+	err = a.moderator.Record(moderation.Event{
+		Stage:      stage,
+		UserID:     user.ID,
+		ChatID:     chatID,
+		Text:       text,
+		Categories: v.Categories,
+		Actions:    v.Actions,
+	})
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to record the flagged text")
+	}
+
+	if v.Has(moderation.ActionNotify) {
+		notice := fmt.Sprintf("Flagged %s of %s in chat %d: %s\n\n%s", stage, user.String(), chatID, strings.Join(v.Categories, ", "), text)
+		for _, admin := range a.admins {
+			if _, err := a.bot.Send(admin, 0, notice); err != nil {
+				zerolog.Ctx(ctx).Error().Err(err).Int64("admin", admin).Msg("failed to notify the admin")
+			}
+		}
+	}
+
+	return v
+}
+
+// allowInput moderates the request and warns the user if needed. It returns false if the request is blocked.
+func (a *app) allowInput(ctx context.Context, chatID int64, threadID int, user *tgbotapi.User, text string) bool {
+	v := a.moderate(ctx, moderation.StageInput, chatID, user, text)
+
+	switch {
+	case v.Has(moderation.ActionBlock):
+		a.bot.Send(chatID, threadID, "The message is blocked by moderation.")
+		return false
+	case v.Has(moderation.ActionWarn):
+		a.bot.Send(chatID, threadID, fmt.Sprintf("Warning: the message is flagged for %s.", strings.Join(v.Categories, ", ")))
+	}
+
+	return true
+}
+
+// handleFlagged shows the last flagged events to the bot admins.
+func (a *app) handleFlagged(ctx context.Context, msg *tgbotapi.Message, threadID int) {
+	if !a.isBotAdmin(msg.From) {
+		return
+	}
+
+	if a.moderator == nil {
+		a.bot.Send(msg.Chat.ID, threadID, "Moderation is disabled.")
+		return
+	}
+
+	events, err := a.moderator.Events(10)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to get the flagged texts")
+		return
+	}
+
+	if len(events) == 0 {
+		a.bot.Send(msg.Chat.ID, threadID, "No flagged messages.")
+		return
+	}
+
+	var sb strings.Builder
+	for _, e := range events {
+		fmt.Fprintf(&sb, "%s %s, user %d, chat %d: %s (%s)\n%s\n\n",
+			e.Time.Format("2006-01-02 15:04"), e.Stage, e.UserID, e.ChatID,
+			strings.Join(e.Categories, ", "), strings.Join(e.Actions, ", "), e.Text)
+	}
+
+	a.bot.Send(msg.Chat.ID, threadID, sb.String())
+}
+
+// isBotAdmin reports whether the user is an admin of the bot.
+func (a *app) isBotAdmin(user *tgbotapi.User) bool {
+	return user != nil && slices.Contains(a.admins, user.ID)
+}
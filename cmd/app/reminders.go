@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/ivanglie/chatgpt-bot/internal/scheduler"
+	"github.com/rs/zerolog"
+)
+
+// jobReminder is the kind of the scheduled reminders.
+const jobReminder = "reminder"
+
+// actionCancelReminder is the prefix of the action of the button to cancel a reminder, followed by its job ID.
+const actionCancelReminder = "rem-"
+
+// Layouts of the time of a reminder.
+const (
+	reminderTime   = "2006-01-02T15:04" // extracted by the model in the time zone of the user
+	reminderFormat = "Mon, 2006-01-02 15:04 MST"
+)
+
+const remindUsage = "Usage: /remind when and what, e.g. /remind tomorrow at 9 to review the release notes"
+
+// reminderSchema is the JSON schema of a reminder extracted from a request.
+const reminderSchema = `{
+	"type": "object",
+	"properties": {
+		"reminder": {"type": "boolean", "description": "whether the request asks to be reminded at a certain time"},
+		"time": {"type": "string", "description": "local date and time of the reminder as YYYY-MM-DDTHH:MM"},
+		"text": {"type": "string", "description": "what to remind of, without the time, in the language of the request"}
+	},
+	"required": ["reminder", "time", "text"],
+	"additionalProperties": false
+}`
+
+// reminder is a reminder extracted from a request by the model.
+type reminder struct {
+	Reminder bool   `json:"reminder"`
+	Time     string `json:"time"`
+	Text     string `json:"text"`
+}
+
+// isReminder reports whether the prompt looks like a request for a reminder.
+func isReminder(prompt string) bool {
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(prompt)), "remind me ")
+}
+
+// handleRemind schedules the reminder described in natural language.
+func (a *app) handleRemind(ctx context.Context, msg *tgbotapi.Message, threadID int) {
+	text := strings.TrimSpace(msg.CommandArguments())
+	if text == "" {
+		a.bot.Send(msg.Chat.ID, threadID, remindUsage)
+		return
+	}
+
+	go a.remind(ctx, msg, threadID, text, false)
+}
+
+// remind asks the model for the time and the subject of the reminder in the text and schedules it.
+// If the text is not a reminder, it is answered as a prompt when fallback is true.
+func (a *app) remind(ctx context.Context, msg *tgbotapi.Message, threadID int, text string, fallback bool) {
+	if !a.allowInput(ctx, msg.Chat.ID, threadID, msg.From, text) {
+		return
+	}
+
+	loc := a.scheduler.Location(msg.From.ID)
+	now := time.Now().In(loc)
+
+	instructions := fmt.Sprintf("Extract the reminder the user asks for. Now is %s, %s, in the time zone %s. "+
+		"Set reminder to false if the request does not ask to be reminded or has no time.",
+		now.Format(reminderTime), now.Weekday(), loc)
+
+	var r reminder
+	if err := a.ai.Extract(ctx, instructions, text, "reminder", json.RawMessage(reminderSchema), &r); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to extract the reminder")
+		a.bot.Send(msg.Chat.ID, threadID, "Failed to schedule the reminder.")
+
+		return
+	}
+
+	due, err := time.ParseInLocation(reminderTime, r.Time, loc)
+	if !r.Reminder || strings.TrimSpace(r.Text) == "" || err != nil {
+		if fallback {
+			a.answer(ctx, msg, threadID, text)
+			return
+		}
+
+		a.bot.Send(msg.Chat.ID, threadID, "I couldn't tell when to remind you. "+remindUsage)
+
+		return
+	}
+
+	if !due.After(now) {
+		a.bot.Send(msg.Chat.ID, threadID, "The time of the reminder, "+due.Format(reminderFormat)+", has already passed.")
+		return
+	}
+
+	job, err := a.scheduler.Add(scheduler.Job{
+		Kind:     jobReminder,
+		ChatID:   msg.Chat.ID,
+		ThreadID: threadID,
+		UserID:   msg.From.ID,
+		Text:     r.Text,
+		Due:      due,
+	})
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to schedule the reminder")
+		a.bot.Send(msg.Chat.ID, threadID, "Failed to schedule the reminder.")
+
+		return
+	}
+
+	zerolog.Ctx(ctx).Info().Str("job", job.ID).Time("due", due).Msg("reminder scheduled")
+	a.bot.Send(msg.Chat.ID, threadID, fmt.Sprintf("I'll remind you on %s: %s", due.Format(reminderFormat), r.Text),
+		a.bot.Button("Cancel", actionCancelReminder+job.ID, msg.Chat.ID, msg.From.ID))
+}
+
+// fireReminder sends the due reminder to its chat.
+func (a *app) fireReminder(ctx context.Context, job scheduler.Job, now time.Time) error {
+	text := "⏰ Reminder: " + job.Text
+	if now.Sub(job.Due) > time.Minute {
+		text += "\n(it was due on " + job.Due.In(a.scheduler.Location(job.UserID)).Format(reminderFormat) + ")"
+	}
+
+	_, err := a.bot.Send(job.ChatID, job.ThreadID, text)
+
+	return err
+}
+
+// handleReminders lists the pending reminders of the user in the chat with the buttons to cancel them.
+func (a *app) handleReminders(ctx context.Context, msg *tgbotapi.Message, threadID int) {
+	jobs, err := a.scheduler.Jobs(jobReminder, msg.Chat.ID, msg.From.ID)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to list the reminders")
+		return
+	}
+
+	if len(jobs) == 0 {
+		a.bot.Send(msg.Chat.ID, threadID, "You have no reminders here. "+remindUsage)
+		return
+	}
+
+	loc := a.scheduler.Location(msg.From.ID)
+
+	var (
+		sb      strings.Builder
+		buttons []tgbotapi.InlineKeyboardButton
+	)
+
+	for i, job := range jobs {
+		fmt.Fprintf(&sb, "%d. %s: %s\n", i+1, job.Due.In(loc).Format(reminderFormat), job.Text)
+		buttons = append(buttons, a.bot.Button(fmt.Sprintf("Cancel %d. %s", i+1, short(job.Text)), actionCancelReminder+job.ID, msg.Chat.ID, msg.From.ID))
+	}
+
+	a.bot.SendMenu(msg.Chat.ID, threadID, sb.String(), buttons...)
+}
+
+// handleReminderCallback cancels the chosen reminder and reports whether the action is to cancel one.
+func (a *app) handleReminderCallback(ctx context.Context, query *tgbotapi.CallbackQuery, action string) bool {
+	id, ok := strings.CutPrefix(action, actionCancelReminder)
+	if !ok {
+		return false
+	}
+
+	job, err := a.scheduler.Get(id)
+	if err == nil && job.UserID != query.From.ID {
+		err = scheduler.ErrNotFound
+	}
+
+	if err == nil {
+		_, err = a.scheduler.Cancel(id)
+	}
+
+	if errors.Is(err, scheduler.ErrNotFound) {
+		a.bot.AnswerCallback(query.ID, "The reminder is already sent or canceled.")
+		return true
+	}
+
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to cancel the reminder")
+		a.bot.AnswerCallback(query.ID, "Failed to cancel the reminder.")
+
+		return true
+	}
+
+	a.bot.AnswerCallback(query.ID, "Canceled: "+short(job.Text))
+
+	return true
+}
+
+// handleTimezone shows or sets the time zone of the user for the reminders.
+func (a *app) handleTimezone(ctx context.Context, msg *tgbotapi.Message, threadID int) {
+	name := strings.TrimSpace(msg.CommandArguments())
+	if name == "" {
+		a.bot.Send(msg.Chat.ID, threadID, fmt.Sprintf("Your time zone is %s. Use /timezone name to change it, e.g. /timezone Europe/Berlin",
+			a.scheduler.Location(msg.From.ID)))
+
+		return
+	}
+
+	loc, err := a.scheduler.SetLocation(msg.From.ID, name)
+	if err != nil {
+		zerolog.Ctx(ctx).Debug().Err(err).Msg("failed to set the time zone")
+		a.bot.Send(msg.Chat.ID, threadID, "Unknown time zone. Use an IANA name, e.g. Europe/Berlin")
+
+		return
+	}
+
+	a.bot.Send(msg.Chat.ID, threadID, fmt.Sprintf("Your time zone is %s now, the time there is %s.", loc, time.Now().In(loc).Format("15:04")))
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/ivanglie/chatgpt-bot/internal/moderation"
+	"github.com/ivanglie/chatgpt-bot/internal/oai"
+	"github.com/ivanglie/chatgpt-bot/internal/scheduler"
+	"github.com/rs/zerolog"
+)
+
+// jobPrompt is the kind of the recurring prompts.
+const jobPrompt = "prompt"
+
+// maxScheduled limits the recurring prompts of a chat.
+const maxScheduled = 10
+
+const scheduleUsage = `Usage:
+/schedule list - the recurring prompts of the chat
+/schedule add cron prompt - e.g. /schedule add 0 9 * * 1-5 Prepare the standup agenda
+/schedule persona id [text] - set or clear the system prompt
+/schedule context id - attach the text of the replied message, clear without a reply
+/schedule history id - the last runs
+/schedule delete id
+The cron schedule is minute, hour, day of month, month and day of week in your time zone (see /timezone) or @hourly, @daily, @weekly, @monthly.`
+
+// handleSchedule manages the recurring prompts of the chat. Only the group admins can change them.
+func (a *app) handleSchedule(ctx context.Context, msg *tgbotapi.Message, threadID int) {
+	args := strings.Fields(msg.CommandArguments())
+
+	var sub string
+	if len(args) > 0 {
+		sub = args[0]
+	}
+
+	switch sub {
+	case "", "list":
+		a.listScheduled(ctx, msg, threadID)
+	case "history":
+		a.scheduleHistory(ctx, msg, threadID, args)
+	case "add", "persona", "context", "delete":
+		if !a.canConfigure(ctx, msg, threadID) {
+			return
+		}
+
+		switch sub {
+		case "add":
+			a.addScheduled(ctx, msg, threadID)
+		case "delete":
+			a.deleteScheduled(ctx, msg, threadID, args)
+		default:
+			a.configureScheduled(ctx, msg, threadID, sub)
+		}
+	default:
+		a.bot.Send(msg.Chat.ID, threadID, scheduleUsage)
+	}
+}
+
+// addScheduled adds the recurring prompt of the arguments: the cron schedule followed by the prompt.
+func (a *app) addScheduled(ctx context.Context, msg *tgbotapi.Message, threadID int) {
+	_, rest, _ := strings.Cut(strings.TrimSpace(msg.CommandArguments()), "add")
+	fields := strings.Fields(rest)
+
+	n := 5
+	if len(fields) > 0 && strings.HasPrefix(fields[0], "@") {
+		n = 1
+	}
+
+	if len(fields) <= n {
+		a.bot.Send(msg.Chat.ID, threadID, scheduleUsage)
+		return
+	}
+
+	spec := strings.Join(fields[:n], " ")
+	if _, err := scheduler.ParseCron(spec); err != nil {
+		a.bot.Send(msg.Chat.ID, threadID, "Invalid schedule: "+err.Error())
+		return
+	}
+
+	// The prompt keeps its line breaks
+	prompt := strings.TrimSpace(rest)
+	for _, f := range fields[:n] {
+		prompt = strings.TrimSpace(strings.TrimPrefix(prompt, f))
+	}
+
+	jobs, err := a.scheduler.Jobs(jobPrompt, msg.Chat.ID, 0)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to list the scheduled prompts")
+		return
+	}
+
+	if len(jobs) >= maxScheduled {
+		a.bot.Send(msg.Chat.ID, threadID, fmt.Sprintf("The chat has %d scheduled prompts already, delete one first.", len(jobs)))
+		return
+	}
+
+	if !a.allowInput(ctx, msg.Chat.ID, threadID, msg.From, prompt) {
+		return
+	}
+
+	loc := a.scheduler.Location(msg.From.ID)
+
+	job, err := a.scheduler.Add(scheduler.Job{
+		Kind:     jobPrompt,
+		ChatID:   msg.Chat.ID,
+		ThreadID: threadID,
+		UserID:   msg.From.ID,
+		Text:     prompt,
+		Cron:     spec,
+		Location: loc.String(),
+	})
+	if err != nil {
+		a.bot.Send(msg.Chat.ID, threadID, "Failed to schedule the prompt: "+err.Error())
+		return
+	}
+
+	zerolog.Ctx(ctx).Info().Str("job", job.ID).Str("cron", spec).Msg("prompt scheduled")
+	a.bot.Send(msg.Chat.ID, threadID, fmt.Sprintf("Scheduled the prompt %s, the next run is on %s.", job.ID, job.Due.In(loc).Format(reminderFormat)))
+}
+
+// configureScheduled sets the persona or the context of the recurring prompt.
+func (a *app) configureScheduled(ctx context.Context, msg *tgbotapi.Message, threadID int, sub string) {
+	args := strings.Fields(msg.CommandArguments())
+	if len(args) < 2 {
+		a.bot.Send(msg.Chat.ID, threadID, scheduleUsage)
+		return
+	}
+
+	job, ok := a.scheduled(ctx, msg, threadID, args[1])
+	if !ok {
+		return
+	}
+
+	var text string
+	switch sub {
+	case "persona":
+		_, rest, _ := strings.Cut(msg.CommandArguments(), args[1])
+		job.Persona = strings.TrimSpace(rest)
+		text = "The persona is set."
+		if job.Persona == "" {
+			text = "The persona is cleared."
+		}
+	case "context":
+		job.Context = ""
+		if reply := msg.ReplyToMessage; reply != nil {
+			job.Context = strings.TrimSpace(reply.Text + reply.Caption)
+		}
+
+		text = "The context is attached."
+		if job.Context == "" {
+			text = "The context is cleared. Reply to a message to attach its text."
+		}
+	}
+
+	if err := a.scheduler.Update(job); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to update the scheduled prompt")
+		a.bot.Send(msg.Chat.ID, threadID, "Failed to update the scheduled prompt.")
+
+		return
+	}
+
+	a.bot.Send(msg.Chat.ID, threadID, text)
+}
+
+// deleteScheduled deletes the recurring prompt.
+func (a *app) deleteScheduled(ctx context.Context, msg *tgbotapi.Message, threadID int, args []string) {
+	if len(args) < 2 {
+		a.bot.Send(msg.Chat.ID, threadID, scheduleUsage)
+		return
+	}
+
+	job, ok := a.scheduled(ctx, msg, threadID, args[1])
+	if !ok {
+		return
+	}
+
+	if _, err := a.scheduler.Cancel(job.ID); err != nil && !errors.Is(err, scheduler.ErrNotFound) {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to delete the scheduled prompt")
+		return
+	}
+
+	a.bot.Send(msg.Chat.ID, threadID, "Deleted the scheduled prompt "+job.ID+".")
+}
+
+// listScheduled lists the recurring prompts of the chat with their next and last runs.
+func (a *app) listScheduled(ctx context.Context, msg *tgbotapi.Message, threadID int) {
+	jobs, err := a.scheduler.Jobs(jobPrompt, msg.Chat.ID, 0)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to list the scheduled prompts")
+		return
+	}
+
+	if len(jobs) == 0 {
+		a.bot.Send(msg.Chat.ID, threadID, "No scheduled prompts. "+scheduleUsage)
+		return
+	}
+
+	var sb strings.Builder
+	for _, job := range jobs {
+		fmt.Fprintf(&sb, "%s: %s (%s), next on %s\n%s\n", job.ID, job.Cron, job.Location,
+			job.Due.In(a.scheduler.Location(msg.From.ID)).Format(reminderFormat), snippet(job.Text))
+
+		if job.Persona != "" {
+			fmt.Fprintf(&sb, "Persona: %s\n", snippet(job.Persona))
+		}
+
+		if job.Context != "" {
+			fmt.Fprintf(&sb, "Context: %s\n", snippet(job.Context))
+		}
+
+		if runs, err := a.scheduler.Runs(job.ID); err == nil && len(runs) > 0 {
+			fmt.Fprintf(&sb, "Last run: %s\n", a.runStatus(runs[0], msg.From.ID))
+		}
+
+		sb.WriteString("\n")
+	}
+
+	a.bot.Send(msg.Chat.ID, threadID, sb.String())
+}
+
+// scheduleHistory shows the last runs of the recurring prompt.
+func (a *app) scheduleHistory(ctx context.Context, msg *tgbotapi.Message, threadID int, args []string) {
+	if len(args) < 2 {
+		a.bot.Send(msg.Chat.ID, threadID, scheduleUsage)
+		return
+	}
+
+	job, ok := a.scheduled(ctx, msg, threadID, args[1])
+	if !ok {
+		return
+	}
+
+	runs, err := a.scheduler.Runs(job.ID)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to get the runs")
+		return
+	}
+
+	if len(runs) == 0 {
+		a.bot.Send(msg.Chat.ID, threadID, "The prompt has not run yet.")
+		return
+	}
+
+	var sb strings.Builder
+	for _, run := range runs {
+		sb.WriteString(a.runStatus(run, msg.From.ID) + "\n")
+	}
+
+	a.bot.Send(msg.Chat.ID, threadID, sb.String())
+}
+
+// runStatus describes the run in the time zone of the user.
+func (a *app) runStatus(run scheduler.Run, userID int64) string {
+	loc := a.scheduler.Location(userID)
+
+	switch {
+	case run.Skipped:
+		return run.Due.In(loc).Format(reminderFormat) + " skipped, missed while the bot was down"
+	case run.Error != "":
+		return run.Time.In(loc).Format(reminderFormat) + " failed: " + run.Error
+	default:
+		return run.Time.In(loc).Format(reminderFormat) + " ok"
+	}
+}
+
+// scheduled returns the recurring prompt of the chat with the ID or reports that there is none.
+func (a *app) scheduled(ctx context.Context, msg *tgbotapi.Message, threadID int, id string) (scheduler.Job, bool) {
+	job, err := a.scheduler.Get(id)
+	if err == nil && (job.Kind != jobPrompt || job.ChatID != msg.Chat.ID) {
+		err = scheduler.ErrNotFound
+	}
+
+	if errors.Is(err, scheduler.ErrNotFound) {
+		a.bot.Send(msg.Chat.ID, threadID, "No scheduled prompt "+id+" in this chat.")
+		return scheduler.Job{}, false
+	}
+
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to get the scheduled prompt")
+		return scheduler.Job{}, false
+	}
+
+	return job, true
+}
+
+// firePrompt runs the recurring prompt and posts the response to its chat.
+func (a *app) firePrompt(ctx context.Context, job scheduler.Job, _ time.Time) error {
+	res, err := a.ai.RunPrompt(ctx, fmt.Sprintf("%d", job.ChatID), oai.Prompt{Text: job.Text, Persona: job.Persona, Context: job.Context})
+	if err != nil {
+		a.bot.Send(job.ChatID, job.ThreadID, fmt.Sprintf("The scheduled prompt %s failed, see /schedule history %s", job.ID, job.ID))
+		return err
+	}
+
+	if v := a.moderate(ctx, moderation.StageOutput, job.ChatID, &tgbotapi.User{ID: job.UserID}, res.Text); v.Has(moderation.ActionBlock) {
+		return errors.New("the response is blocked by moderation")
+	}
+
+	_, err = a.bot.Send(job.ChatID, job.ThreadID, "🗓 "+snippet(job.Text)+"\n\n"+res.Text)
+
+	return err
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/ivanglie/chatgpt-bot/internal/conversation"
+	"github.com/ivanglie/chatgpt-bot/internal/oai"
+	"github.com/ivanglie/chatgpt-bot/internal/search"
+	"github.com/rs/zerolog"
+)
+
+const (
+	maxResults     = 5
+	maxResultText  = 100 // length of the snippets of a result in runes
+	searchDateTime = "2006-01-02 15:04"
+)
+
+// handleSearch finds the earlier exchanges of the user in the chat by meaning
+// and offers the buttons to resume their conversations.
+func (a *app) handleSearch(ctx context.Context, msg *tgbotapi.Message, threadID int) {
+	if a.search == nil {
+		a.bot.Send(msg.Chat.ID, threadID, "Search is disabled.")
+		return
+	}
+
+	query := strings.TrimSpace(msg.CommandArguments())
+	if query == "" {
+		a.bot.Send(msg.Chat.ID, threadID, "Usage: /search what you talked about, e.g. /search pasta recipe")
+		return
+	}
+
+	scope := a.scope(msg.Chat.ID, msg.From.ID, threadID)
+
+	go func() {
+		results, err := a.search.Search(ctx, userKey(msg.From), scope.String(), query, maxResults)
+		if err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Msg("failed to search")
+			a.bot.Send(msg.Chat.ID, threadID, "Failed to search.")
+
+			return
+		}
+
+		list, _, err := a.convs.List(scope.String())
+		if err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Msg("failed to list the conversations")
+			return
+		}
+
+		convs := make(map[string]conversation.Conversation, len(list))
+		for _, c := range list {
+			convs[c.ID] = c
+		}
+
+		var (
+			sb      strings.Builder
+			buttons []tgbotapi.InlineKeyboardButton
+			resumed = make(map[string]bool)
+			n       int
+		)
+
+		for _, r := range results {
+			c, ok := convs[r.Conversation]
+			if !ok {
+				continue // deleted
+			}
+
+			n++
+			fmt.Fprintf(&sb, "%d. %s, %s\n» %s\n« %s\n\n", n, r.Time.Format(searchDateTime), c.Name(),
+				snippet(r.Request), snippet(r.Response))
+
+			if !resumed[c.ID] {
+				resumed[c.ID] = true
+				buttons = append(buttons, a.bot.Button(fmt.Sprintf("Resume %d. %s", n, short(c.Name())), actionSwitch+c.ID, msg.Chat.ID, msg.From.ID))
+			}
+		}
+
+		if n == 0 {
+			a.bot.Send(msg.Chat.ID, threadID, "Nothing found.")
+			return
+		}
+
+		a.bot.SendMenu(msg.Chat.ID, threadID, sb.String(), buttons...)
+	}()
+}
+
+// index queues the exchange of the user in the conversation of the key to be searched later.
+func (a *app) index(ctx context.Context, key oai.Key, user *tgbotapi.User, request, response string) {
+	if a.search == nil {
+		return
+	}
+
+	id := key.Conversation
+	key.Conversation = ""
+
+	e := search.Entry{Scope: key.String(), Conversation: id, Request: request, Response: response, Time: time.Now()}
+	if !a.search.Add(userKey(user), e) {
+		zerolog.Ctx(ctx).Warn().Msg("the search index is busy, the exchange is not indexed")
+	}
+}
+
+// unindex removes the deleted conversation from the search index.
+func (a *app) unindex(ctx context.Context, scope oai.Key, id string) {
+	if a.search == nil {
+		return
+	}
+
+	if err := a.search.Delete(scope.String(), id); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to remove the conversation from the search index")
+	}
+}
+
+// snippet returns the text on one line shortened for a search result.
+func snippet(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if r := []rune(text); len(r) > maxResultText {
+		return string(r[:maxResultText-1]) + "…"
+	}
+
+	return text
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/ivanglie/chatgpt-bot/internal/scheduler"
+	"github.com/ivanglie/chatgpt-bot/internal/stats"
+	"github.com/ivanglie/chatgpt-bot/internal/users"
+	"github.com/rs/zerolog"
+)
+
+// jobReport is the kind of the scheduled reports of the statistics.
+const jobReport = "report"
+
+// statsDays is the default period of /stats in days.
+const statsDays = 30
+
+const statsUsage = `Usage: /stats [days] [csv]
+The statistics for the last 30 days by default, as a CSV document if csv is set.`
+
+// handleStats sends the statistics of the bot to a bot admin.
+func (a *app) handleStats(ctx context.Context, msg *tgbotapi.Message, threadID int) {
+	if !a.isBotAdmin(msg.From) {
+		return
+	}
+
+	days, csv, err := parseStats(msg.CommandArguments())
+	if err != nil {
+		a.bot.Send(msg.Chat.ID, threadID, err.Error()+"\n\n"+statsUsage)
+		return
+	}
+
+	if csv {
+		a.sendReport(ctx, msg.Chat.ID, threadID, days)
+		return
+	}
+
+	r, err := a.stats.Report(days)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to report the statistics")
+		a.bot.Send(msg.Chat.ID, threadID, "Failed to get the statistics.")
+
+		return
+	}
+
+	a.bot.Send(msg.Chat.ID, threadID, formatStats(r, a.userNames(ctx)))
+}
+
+// parseStats parses the arguments of /stats: the number of days and csv.
+func parseStats(args string) (days int, csv bool, err error) {
+	days = statsDays
+
+	for _, arg := range strings.Fields(args) {
+		if arg == "csv" {
+			csv = true
+			continue
+		}
+
+		n, err := strconv.Atoi(strings.TrimSuffix(arg, "d"))
+		if err != nil || n < 1 || n > stats.Retention {
+			return 0, false, fmt.Errorf("invalid number of days %q, expected 1 to %d", arg, stats.Retention)
+		}
+
+		days = n
+	}
+
+	return days, csv, nil
+}
+
+// sendReport sends the statistics of the last days to the chat as a CSV document.
+func (a *app) sendReport(ctx context.Context, chatID int64, threadID, days int) error {
+	data, err := a.stats.CSV(days)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to report the statistics")
+		a.bot.Send(chatID, threadID, "Failed to get the statistics.")
+
+		return err
+	}
+
+	name := "stats-" + time.Now().Format("20060102") + ".csv"
+	if _, err := a.bot.SendDocument(chatID, threadID, name, data, fmt.Sprintf("Statistics for the last %d days", days)); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to send the statistics")
+		return err
+	}
+
+	return nil
+}
+
+// fireReport sends the scheduled report of the statistics.
+func (a *app) fireReport(ctx context.Context, job scheduler.Job, _ time.Time) error {
+	return a.sendReport(ctx, job.ChatID, job.ThreadID, a.reportDays)
+}
+
+// scheduleReport keeps the single scheduled report of the statistics on the cron schedule to the chat,
+// replacing a report with other settings. An empty schedule disables the report.
+func (a *app) scheduleReport(spec string, chatID int64, days int) error {
+	if spec != "" && chatID == 0 {
+		return errors.New("no chat for the report of the statistics, set the chat or a bot admin")
+	}
+
+	a.reportDays = days
+
+	jobs, err := a.scheduler.JobsOf(jobReport)
+	if err != nil {
+		return err
+	}
+
+	var kept bool
+	for _, job := range jobs {
+		if !kept && spec != "" && job.Cron == spec && job.ChatID == chatID {
+			kept = true
+			continue
+		}
+
+		if _, err := a.scheduler.Cancel(job.ID); err != nil {
+			return err
+		}
+	}
+
+	if kept || spec == "" {
+		return nil
+	}
+
+	_, err = a.scheduler.Add(scheduler.Job{
+		Kind:     jobReport,
+		ChatID:   chatID,
+		Text:     "statistics report",
+		Cron:     spec,
+		Location: a.scheduler.Location(0).String(),
+	})
+
+	return err
+}
+
+// userNames returns the names of the users by their keys.
+func (a *app) userNames(ctx context.Context) map[string]string {
+	all, err := a.registry.List(users.Filter{})
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to list the users")
+	}
+
+	names := make(map[string]string, len(all))
+	for _, u := range all {
+		names[strconv.FormatInt(u.ID, 10)] = u.Name()
+	}
+
+	return names
+}
+
+// formatStats formats the report with the names of the users.
+func formatStats(r stats.Report, names map[string]string) string {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "Statistics for the last %d days\n\n", r.Days)
+	fmt.Fprintf(&sb, "Active users: %d today, %d in 7 days, %d in 30 days\n", r.ActiveDay, r.ActiveWeek, r.ActiveMonth)
+
+	types := keys(r.Updates)
+	counts := make([]string, 0, len(types))
+	for _, typ := range types {
+		counts = append(counts, fmt.Sprintf("%d %s", r.Updates[typ], strings.ReplaceAll(typ, "_", " ")))
+	}
+	if len(counts) == 0 {
+		counts = append(counts, "none")
+	}
+	fmt.Fprintf(&sb, "Updates: %s\n", strings.Join(counts, ", "))
+
+	var requests, failed int
+	for _, n := range r.Requests {
+		requests += n
+	}
+	for _, n := range r.Errors {
+		failed += n
+	}
+
+	fmt.Fprintf(&sb, "\nRequests: %d, errors: %d (%.1f%%)\n", requests, failed, 100*r.ErrorRate(""))
+	for _, kind := range keys(r.Requests, r.Errors) {
+		fmt.Fprintf(&sb, "• %s: %d, errors: %d (%.1f%%)\n", kind, r.Requests[kind], r.Errors[kind], 100*r.ErrorRate(kind))
+	}
+
+	if len(r.Models) > 0 {
+		var cost float64
+		sb.WriteString("\nModels:\n")
+
+		for _, m := range r.Models {
+			price := "price unknown"
+			if m.Priced {
+				price = fmt.Sprintf("$%.2f", m.Cost)
+				cost += m.Cost
+			}
+
+			fmt.Fprintf(&sb, "• %s: %d requests, %s prompt and %s completion tokens, %s\n",
+				m.Model, m.Requests, compact(m.PromptTokens), compact(m.CompletionTokens), price)
+		}
+
+		fmt.Fprintf(&sb, "Cost: $%.2f\n", cost)
+	}
+
+	if len(r.Backends) > 0 {
+		sb.WriteString("\nBackends:\n")
+
+		names := make([]string, 0, len(r.Backends))
+		for b := range r.Backends {
+			names = append(names, b)
+		}
+		sort.Strings(names)
+
+		for _, b := range names {
+			u := r.Backends[b]
+			fmt.Fprintf(&sb, "• %s: %d requests, %s tokens\n", b, u.Requests, compact(u.PromptTokens+u.CompletionTokens))
+		}
+	}
+
+	if len(r.Users) > 0 {
+		sb.WriteString("\nTop users:\n")
+
+		for i, u := range r.Users {
+			name, ok := names[u.User]
+			if !ok || name == "" {
+				name = u.User
+			}
+
+			fmt.Fprintf(&sb, "%d. %s: %d updates, %s tokens\n", i+1, name, u.Updates, compact(u.Tokens))
+		}
+	}
+
+	return strings.TrimSpace(sb.String())
+}
+
+// keys returns the sorted keys of the maps.
+func keys(maps ...map[string]int) []string {
+	seen := make(map[string]bool)
+	var res []string
+
+	for _, m := range maps {
+		for k := range m {
+			if !seen[k] {
+				seen[k] = true
+				res = append(res, k)
+			}
+		}
+	}
+
+	sort.Strings(res)
+
+	return res
+}
+
+// compact formats the number with k for thousands and M for millions.
+func compact(n int) string {
+	switch {
+	case n >= 1e6:
+		return strconv.FormatFloat(float64(n)/1e6, 'f', 1, 64) + "M"
+	case n >= 1e3:
+		return strconv.FormatFloat(float64(n)/1e3, 'f', 1, 64) + "k"
+	default:
+		return strconv.Itoa(n)
+	}
+}
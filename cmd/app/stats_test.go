@@ -0,0 +1,49 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ivanglie/chatgpt-bot/internal/scheduler"
+	"github.com/ivanglie/chatgpt-bot/internal/store"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApp_ScheduleReport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+
+	// Each start of the bot schedules the report again
+	restart := func(spec string, chatID int64) []scheduler.Job {
+		st, err := store.Open(path)
+		require.NoError(t, err)
+
+		a := &app{scheduler: scheduler.New(st, time.UTC, zerolog.Nop())}
+		require.NoError(t, a.scheduleReport(spec, chatID, 7))
+
+		jobs, err := a.scheduler.JobsOf(jobReport)
+		require.NoError(t, err)
+
+		return jobs
+	}
+
+	for range 3 {
+		jobs := restart("0 9 * * *", 100)
+		require.Len(t, jobs, 1)
+		assert.Equal(t, int64(100), jobs[0].ChatID)
+	}
+
+	jobs := restart("0 10 * * 1", 200)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, "0 10 * * 1", jobs[0].Cron)
+	assert.Equal(t, int64(200), jobs[0].ChatID)
+
+	assert.Empty(t, restart("", 200))
+
+	st, err := store.Open("")
+	require.NoError(t, err)
+	a := &app{scheduler: scheduler.New(st, time.UTC, zerolog.Nop())}
+	assert.NotNil(t, a.scheduleReport("0 9 * * *", 0, 7))
+}
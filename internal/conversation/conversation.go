@@ -0,0 +1,290 @@
+package conversation
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ivanglie/chatgpt-bot/internal/store"
+)
+
+// Buckets of the store.
+const (
+	bucket       = "conversations" // conversations of the chats
+	sharedBucket = "shared"        // group chats with a conversation shared by all members
+	toolsBucket  = "tools"         // tools enabled or disabled in the chats
+)
+
+// maxTitle limits the length of a title in runes.
+const maxTitle = 40
+
+// DefaultTitle is the title of a conversation without messages.
+const DefaultTitle = "New conversation"
+
+// ErrNotFound is returned for an unknown conversation.
+var ErrNotFound = errors.New("conversation not found")
+
+// Conversation is a named conversation of a chat.
+type Conversation struct {
+	ID      string    `json:"id"`
+	Title   string    `json:"title,omitempty"` // made of the first request, empty until then
+	Updated time.Time `json:"updated"`         // time of the last activity
+}
+
+// Name returns the title or the default one.
+func (c Conversation) Name() string {
+	if c.Title == "" {
+		return DefaultTitle
+	}
+
+	return c.Title
+}
+
+// scope are the conversations of a chat and the active one.
+type scope struct {
+	Active        string         `json:"active"`
+	Next          int            `json:"next"` // number for the ID of the next conversation
+	Conversations []Conversation `json:"conversations"`
+}
+
+// Registry keeps the conversations of the chats and the active ones in the store,
+// so that they survive restarts if the store is persistent.
+type Registry struct {
+	mu    sync.Mutex
+	store *store.Store
+	now   func() time.Time
+}
+
+// New makes a registry in the store.
+func New(st *store.Store) *Registry {
+	return &Registry{store: st, now: time.Now}
+}
+
+// Active returns the active conversation of the scope, e.g. a chat, starting one if there are none.
+func (r *Registry) Active(scopeKey string) (Conversation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, err := r.load(scopeKey)
+	if err != nil {
+		return Conversation{}, err
+	}
+
+	if i := s.find(s.Active); i >= 0 {
+		return s.Conversations[i], nil
+	}
+
+	c := s.start(r.now())
+
+	return c, r.store.Put(bucket, scopeKey, s)
+}
+
+// Start starts a new conversation in the scope and makes it active.
+func (r *Registry) Start(scopeKey string) (Conversation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, err := r.load(scopeKey)
+	if err != nil {
+		return Conversation{}, err
+	}
+
+	c := s.start(r.now())
+
+	return c, r.store.Put(bucket, scopeKey, s)
+}
+
+// List returns the conversations of the scope, the most recent first, and the ID of the active one.
+func (r *Registry) List(scopeKey string) ([]Conversation, string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, err := r.load(scopeKey)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return s.recent(), s.Active, nil
+}
+
+// Switch makes the conversation active.
+func (r *Registry) Switch(scopeKey, id string) (Conversation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, err := r.load(scopeKey)
+	if err != nil {
+		return Conversation{}, err
+	}
+
+	i := s.find(id)
+	if i < 0 {
+		return Conversation{}, ErrNotFound
+	}
+
+	s.Active = id
+
+	return s.Conversations[i], r.store.Put(bucket, scopeKey, s)
+}
+
+// Delete removes the conversation. If it was active, the most recent one becomes active.
+func (r *Registry) Delete(scopeKey, id string) (Conversation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, err := r.load(scopeKey)
+	if err != nil {
+		return Conversation{}, err
+	}
+
+	i := s.find(id)
+	if i < 0 {
+		return Conversation{}, ErrNotFound
+	}
+
+	c := s.Conversations[i]
+	s.Conversations = append(s.Conversations[:i], s.Conversations[i+1:]...)
+
+	if s.Active == id {
+		s.Active = ""
+		if recent := s.recent(); len(recent) > 0 {
+			s.Active = recent[0].ID
+		}
+	}
+
+	return c, r.store.Put(bucket, scopeKey, s)
+}
+
+// Touch records the activity in the conversation and titles it with the request if it has no title.
+func (r *Registry) Touch(scopeKey, id, request string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, err := r.load(scopeKey)
+	if err != nil {
+		return err
+	}
+
+	i := s.find(id)
+	if i < 0 {
+		return ErrNotFound
+	}
+
+	c := &s.Conversations[i]
+	c.Updated = r.now()
+	if c.Title == "" {
+		c.Title = title(request)
+	}
+
+	return r.store.Put(bucket, scopeKey, s)
+}
+
+// Shared reports whether the members of the chat share a conversation.
+func (r *Registry) Shared(chatID string) (bool, error) {
+	var shared bool
+	_, err := r.store.Get(sharedBucket, chatID, &shared)
+
+	return shared, err
+}
+
+// SetShared makes the members of the chat share a conversation or have one each.
+func (r *Registry) SetShared(chatID string, shared bool) error {
+	if !shared {
+		return r.store.Delete(sharedBucket, chatID)
+	}
+
+	return r.store.Put(sharedBucket, chatID, true)
+}
+
+// Tools returns the tools enabled or disabled in the chats, chat ID -> tool name -> enabled.
+func (r *Registry) Tools() (map[string]map[string]bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	chats := make(map[string]map[string]bool)
+	for _, chatID := range r.store.Keys(toolsBucket) {
+		var tools map[string]bool
+		if _, err := r.store.Get(toolsBucket, chatID, &tools); err != nil {
+			return nil, err
+		}
+
+		chats[chatID] = tools
+	}
+
+	return chats, nil
+}
+
+// SetTool enables or disables the tool in the chat.
+func (r *Registry) SetTool(chatID, name string, enabled bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tools := make(map[string]bool)
+	if _, err := r.store.Get(toolsBucket, chatID, &tools); err != nil {
+		return err
+	}
+
+	tools[name] = enabled
+
+	return r.store.Put(toolsBucket, chatID, tools)
+}
+
+func (r *Registry) load(scopeKey string) (*scope, error) {
+	s := &scope{}
+	if _, err := r.store.Get(bucket, scopeKey, s); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// find returns the index of the conversation or -1.
+func (s *scope) find(id string) int {
+	for i, c := range s.Conversations {
+		if c.ID == id {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// recent returns the conversations by the last activity, the newer ones first on ties.
+func (s *scope) recent() []Conversation {
+	list := make([]Conversation, len(s.Conversations))
+	for i, c := range s.Conversations {
+		list[len(list)-1-i] = c
+	}
+
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Updated.After(list[j].Updated) })
+
+	return list
+}
+
+// start adds a conversation and makes it active.
+func (s *scope) start(now time.Time) Conversation {
+	s.Next++
+	c := Conversation{ID: strconv.Itoa(s.Next), Updated: now}
+	s.Conversations = append(s.Conversations, c)
+	s.Active = c.ID
+
+	return c
+}
+
+// title makes a title of the first line of the request.
+func title(request string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(request), "\n")
+	line = strings.Join(strings.Fields(line), " ")
+
+	if utf8.RuneCountInString(line) <= maxTitle {
+		return line
+	}
+
+	runes := []rune(line)
+
+	return strings.TrimSpace(string(runes[:maxTitle-1])) + "…"
+}
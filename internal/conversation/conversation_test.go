@@ -0,0 +1,148 @@
+package conversation
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ivanglie/chatgpt-bot/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	st, err := store.Open("")
+	require.NoError(t, err)
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	r := New(st)
+	r.now = func() time.Time { return now }
+
+	first, err := r.Active("chat")
+	require.NoError(t, err)
+	assert.Equal(t, "1", first.ID)
+	assert.Equal(t, DefaultTitle, first.Name())
+
+	again, err := r.Active("chat")
+	require.NoError(t, err)
+	assert.Equal(t, first, again)
+
+	now = now.Add(time.Minute)
+	require.NoError(t, r.Touch("chat", "1", "  What is the weather\nin Moscow?"))
+	require.NoError(t, r.Touch("chat", "1", "And tomorrow?"))
+
+	second, err := r.Start("chat")
+	require.NoError(t, err)
+	assert.Equal(t, "2", second.ID)
+
+	list, active, err := r.List("chat")
+	require.NoError(t, err)
+	assert.Equal(t, "2", active)
+	require.Len(t, list, 2)
+	assert.Equal(t, "2", list[0].ID)
+	assert.Equal(t, "What is the weather", list[1].Title)
+
+	c, err := r.Switch("chat", "1")
+	require.NoError(t, err)
+	assert.Equal(t, "What is the weather", c.Title)
+
+	_, err = r.Switch("chat", "3")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, err = r.Delete("chat", "1")
+	require.NoError(t, err)
+
+	c, err = r.Active("chat")
+	require.NoError(t, err)
+	assert.Equal(t, "2", c.ID)
+
+	_, err = r.Delete("chat", "2")
+	require.NoError(t, err)
+
+	c, err = r.Active("chat")
+	require.NoError(t, err)
+	assert.Equal(t, "3", c.ID)
+
+	list, _, err = r.List("other")
+	require.NoError(t, err)
+	assert.Empty(t, list)
+}
+
+func TestRegistry_Persistent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+
+	st, err := store.Open(path)
+	require.NoError(t, err)
+
+	_, err = New(st).Start("chat")
+	require.NoError(t, err)
+	_, err = New(st).Start("chat")
+	require.NoError(t, err)
+
+	st, err = store.Open(path)
+	require.NoError(t, err)
+
+	c, err := New(st).Active("chat")
+	require.NoError(t, err)
+	assert.Equal(t, "2", c.ID)
+}
+
+func TestRegistry_Shared(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+
+	st, err := store.Open(path)
+	require.NoError(t, err)
+
+	r := New(st)
+	shared, err := r.Shared("-100")
+	require.NoError(t, err)
+	assert.False(t, shared)
+
+	require.NoError(t, r.SetShared("-100", true))
+	require.NoError(t, r.SetShared("-200", true))
+	require.NoError(t, r.SetShared("-200", false))
+
+	st, err = store.Open(path)
+	require.NoError(t, err)
+
+	r = New(st)
+	shared, err = r.Shared("-100")
+	require.NoError(t, err)
+	assert.True(t, shared)
+
+	shared, err = r.Shared("-200")
+	require.NoError(t, err)
+	assert.False(t, shared)
+}
+
+func TestRegistry_Tools(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+
+	st, err := store.Open(path)
+	require.NoError(t, err)
+
+	r := New(st)
+	tools, err := r.Tools()
+	require.NoError(t, err)
+	assert.Empty(t, tools)
+
+	require.NoError(t, r.SetTool("-100", "fetch", true))
+	require.NoError(t, r.SetTool("-100", "calculator", false))
+	require.NoError(t, r.SetTool("-100", "fetch", false))
+	require.NoError(t, r.SetTool("1", "datetime", true))
+
+	st, err = store.Open(path)
+	require.NoError(t, err)
+
+	tools, err = New(st).Tools()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]map[string]bool{
+		"-100": {"fetch": false, "calculator": false},
+		"1":    {"datetime": true},
+	}, tools)
+}
+
+func TestTitle(t *testing.T) {
+	assert.Equal(t, "Hello world", title(" Hello   world "))
+	assert.Equal(t, "Пожалуйста, расскажи подробно о том, ка…", title("Пожалуйста, расскажи подробно о том, как работает этот бот"))
+}
@@ -0,0 +1,238 @@
+// Package memory keeps the long-term facts about the users and their preferences.
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/ivanglie/chatgpt-bot/internal/oai"
+	"github.com/ivanglie/chatgpt-bot/internal/store"
+)
+
+// bucket is the bucket of the store with the facts of the users.
+const bucket = "memory"
+
+const (
+	maxFacts    = 50  // facts per user, the oldest ones are forgotten
+	maxFact     = 300 // length of a fact in runes
+	maxRecalled = 10  // facts recalled for a request
+)
+
+// ErrNotFound is returned for an unknown fact.
+var ErrNotFound = errors.New("fact not found")
+
+// Fact is a fact about the user or a preference of the user.
+type Fact struct {
+	ID      int       `json:"id"`
+	Text    string    `json:"text"`
+	Created time.Time `json:"created"`
+}
+
+// facts are the facts of a user.
+type facts struct {
+	Next  int    `json:"next"` // ID of the next fact
+	Facts []Fact `json:"facts"`
+}
+
+// Memory keeps the facts of the users in the store, strictly separated by user.
+type Memory struct {
+	mu    sync.Mutex
+	store *store.Store
+	now   func() time.Time
+}
+
+// New makes a memory in the store.
+func New(st *store.Store) *Memory {
+	return &Memory{store: st, now: time.Now}
+}
+
+// Remember saves the fact about the user. A fact which is already known is not duplicated.
+func (m *Memory) Remember(userID, text string) (Fact, error) {
+	text = strings.Join(strings.Fields(text), " ")
+	if text == "" {
+		return Fact{}, errors.New("empty fact")
+	}
+
+	if utf8.RuneCountInString(text) > maxFact {
+		text = string([]rune(text)[:maxFact])
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, err := m.load(userID)
+	if err != nil {
+		return Fact{}, err
+	}
+
+	for _, fact := range f.Facts {
+		if strings.EqualFold(fact.Text, text) {
+			return fact, nil
+		}
+	}
+
+	f.Next++
+	fact := Fact{ID: f.Next, Text: text, Created: m.now()}
+	f.Facts = append(f.Facts, fact)
+
+	if len(f.Facts) > maxFacts {
+		f.Facts = f.Facts[len(f.Facts)-maxFacts:]
+	}
+
+	return fact, m.store.Put(bucket, userID, f)
+}
+
+// Facts returns the facts about the user, oldest first.
+func (m *Memory) Facts(userID string) ([]Fact, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, err := m.load(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.Facts, nil
+}
+
+// Forget deletes the fact about the user.
+func (m *Memory) Forget(userID string, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, err := m.load(userID)
+	if err != nil {
+		return err
+	}
+
+	for i, fact := range f.Facts {
+		if fact.ID == id {
+			f.Facts = append(f.Facts[:i], f.Facts[i+1:]...)
+			return m.store.Put(bucket, userID, f)
+		}
+	}
+
+	return ErrNotFound
+}
+
+// Clear deletes all facts about the user.
+func (m *Memory) Clear(userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.store.Delete(bucket, userID)
+}
+
+// Recall returns the facts about the user most relevant to the request:
+// the ones sharing more words with it first, then the recent ones.
+func (m *Memory) Recall(userID, request string) ([]string, error) {
+	all, err := m.Facts(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	words := make(map[string]bool)
+	for _, w := range tokenize(request) {
+		words[w] = true
+	}
+
+	scores := make(map[int]int, len(all))
+	for _, fact := range all {
+		for _, w := range tokenize(fact.Text) {
+			if words[w] {
+				scores[fact.ID]++
+			}
+		}
+	}
+
+	ranked := append([]Fact(nil), all...)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if si, sj := scores[ranked[i].ID], scores[ranked[j].ID]; si != sj {
+			return si > sj
+		}
+
+		return ranked[i].ID > ranked[j].ID
+	})
+
+	if len(ranked) > maxRecalled {
+		ranked = ranked[:maxRecalled]
+	}
+
+	texts := make([]string, 0, len(ranked))
+	for _, fact := range ranked {
+		texts = append(texts, fact.Text)
+	}
+
+	return texts, nil
+}
+
+func (m *Memory) load(userID string) (*facts, error) {
+	f := &facts{}
+	if _, err := m.store.Get(bucket, userID, f); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// tokenize returns the lowercase words of the text longer than two letters.
+func tokenize(text string) []string {
+	var words []string
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if utf8.RuneCountInString(w) > 2 {
+			words = append(words, w)
+		}
+	}
+
+	return words
+}
+
+// Tool is a tool which saves the facts about the user the model is talking to.
+type Tool struct {
+	Memory *Memory
+}
+
+// Name implements oai.Tool.
+func (Tool) Name() string { return "remember" }
+
+// Description implements oai.Tool.
+func (Tool) Description() string {
+	return "Saves a lasting fact about the user or their preference, e.g. the language they write code in, " +
+		"to recall it in future conversations. Use it only when the user shares such a fact."
+}
+
+// Schema implements oai.Tool.
+func (Tool) Schema() json.RawMessage {
+	return json.RawMessage(`{"type":"object","properties":{"fact":{"type":"string","description":"the fact in a short sentence about the user"}},"required":["fact"]}`)
+}
+
+// Call implements oai.Tool.
+func (t Tool) Call(ctx context.Context, arguments string) (string, error) {
+	var args struct {
+		Fact string `json:"fact"`
+	}
+
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return "", err
+	}
+
+	userID := oai.User(ctx)
+	if userID == "" {
+		return "", errors.New("unknown user")
+	}
+
+	if _, err := t.Memory.Remember(userID, args.Fact); err != nil {
+		return "", err
+	}
+
+	return "Saved.", nil
+}
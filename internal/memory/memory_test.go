@@ -0,0 +1,111 @@
+package memory
+
+import (
+	"context"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/ivanglie/chatgpt-bot/internal/oai"
+	"github.com/ivanglie/chatgpt-bot/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemory(t *testing.T) {
+	st, err := store.Open("")
+	require.NoError(t, err)
+
+	m := New(st)
+
+	first, err := m.Remember("1", "  I write   Go ")
+	require.NoError(t, err)
+	assert.Equal(t, "I write Go", first.Text)
+
+	again, err := m.Remember("1", "i write go")
+	require.NoError(t, err)
+	assert.Equal(t, first.ID, again.ID)
+
+	_, err = m.Remember("1", "Answer with code examples")
+	require.NoError(t, err)
+
+	_, err = m.Remember("1", " ")
+	assert.Error(t, err)
+
+	facts, err := m.Facts("1")
+	require.NoError(t, err)
+	assert.Len(t, facts, 2)
+
+	other, err := m.Facts("2")
+	require.NoError(t, err)
+	assert.Empty(t, other)
+	assert.ErrorIs(t, m.Forget("2", first.ID), ErrNotFound)
+
+	require.NoError(t, m.Forget("1", first.ID))
+	facts, _ = m.Facts("1")
+	assert.Equal(t, "Answer with code examples", facts[0].Text)
+
+	require.NoError(t, m.Clear("1"))
+	facts, _ = m.Facts("1")
+	assert.Empty(t, facts)
+}
+
+func TestMemory_Limit(t *testing.T) {
+	st, _ := store.Open("")
+	m := New(st)
+
+	for i := 0; i < maxFacts+5; i++ {
+		_, err := m.Remember("1", "fact "+strconv.Itoa(i))
+		require.NoError(t, err)
+	}
+
+	facts, _ := m.Facts("1")
+	assert.Len(t, facts, maxFacts)
+	assert.Equal(t, "fact 5", facts[0].Text)
+}
+
+func TestMemory_Recall(t *testing.T) {
+	st, _ := store.Open("")
+	m := New(st)
+
+	for _, text := range []string{"Lives in Berlin", "Writes Go at work", "Prefers short answers"} {
+		_, err := m.Remember("1", text)
+		require.NoError(t, err)
+	}
+
+	facts, err := m.Recall("1", "How do I sort a slice in Go at work?")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Writes Go at work", "Prefers short answers", "Lives in Berlin"}, facts)
+
+	facts, err = m.Recall("2", "Go")
+	require.NoError(t, err)
+	assert.Empty(t, facts)
+}
+
+func TestMemory_Persistent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+
+	st, _ := store.Open(path)
+	_, err := New(st).Remember("1", "I write Go")
+	require.NoError(t, err)
+
+	st, _ = store.Open(path)
+	facts, err := New(st).Facts("1")
+	require.NoError(t, err)
+	assert.Len(t, facts, 1)
+}
+
+func TestTool(t *testing.T) {
+	st, _ := store.Open("")
+	tool := Tool{Memory: New(st)}
+
+	_, err := tool.Call(context.Background(), `{"fact": "I write Go"}`)
+	assert.Error(t, err)
+
+	res, err := tool.Call(oai.WithUser(context.Background(), "1"), `{"fact": "I write Go"}`)
+	require.NoError(t, err)
+	assert.Equal(t, "Saved.", res)
+
+	facts, _ := tool.Memory.Facts("1")
+	assert.Len(t, facts, 1)
+}
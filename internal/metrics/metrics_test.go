@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+
+	c := r.NewCounter("updates_total", "Updates received.", "type")
+	c.Inc("message")
+	c.Add(2, "callback")
+	c.Inc("message")
+
+	plain := r.NewCounter("denials_total", "Access denials.")
+
+	h := r.NewHistogram("latency_seconds", "Latency.", []float64{1, 0.5}, "backend")
+	h.Observe(0.2, "default")
+	h.Observe(0.5, "default")
+	h.Observe(3, "default")
+
+	r.NewGaugeFunc("conversations", "Conversations.", func() float64 { return 7 })
+
+	assert.Equal(t, float64(2), c.Value("message"))
+	assert.Equal(t, float64(0), plain.Value())
+	assert.Equal(t, uint64(3), h.Count("default"))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	assert.Equal(t, `# HELP updates_total Updates received.
+# TYPE updates_total counter
+updates_total{type="callback"} 2
+updates_total{type="message"} 2
+# HELP denials_total Access denials.
+# TYPE denials_total counter
+denials_total 0
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{backend="default",le="0.5"} 2
+latency_seconds_bucket{backend="default",le="1"} 2
+latency_seconds_bucket{backend="default",le="+Inf"} 3
+latency_seconds_sum{backend="default"} 3.7
+latency_seconds_count{backend="default"} 3
+# HELP conversations Conversations.
+# TYPE conversations gauge
+conversations 7
+`, w.Body.String())
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/plain")
+}
+
+func TestCounter_Labels(t *testing.T) {
+	c := NewRegistry().NewCounter("errors_total", "Errors.", "type", "code")
+	assert.Panics(t, func() { c.Inc("api") })
+}
+
+func TestCounter_Escape(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("chats_total", "Chats.", "title")
+	c.Inc("Чат \"команды\" ☕")
+	c.Inc("a\\b\nc\td")
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	assert.Contains(t, w.Body.String(), `chats_total{title="Чат \"команды\" ☕"} 1`)
+	assert.Contains(t, w.Body.String(), "chats_total{title=\"a\\\\b\\nc\td\"} 1")
+}
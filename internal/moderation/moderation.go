@@ -0,0 +1,244 @@
+package moderation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ivanglie/chatgpt-bot/internal/store"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// eventsBucket is the bucket of the store with the flagged events.
+const eventsBucket = "moderation"
+
+const (
+	maxEventText = 1000                // limits the text kept in a flagged event
+	maxEvents    = 1000                // kept, the oldest ones are deleted
+	retention    = 90 * 24 * time.Hour // of the flagged events
+)
+
+// Actions for flagged texts.
+const (
+	ActionBlock  = "block"  // the text is neither sent to the model nor to the user
+	ActionWarn   = "warn"   // the user is warned
+	ActionNotify = "notify" // the admins are notified
+)
+
+// Stages of moderation.
+const (
+	StageInput  = "input"
+	StageOutput = "output"
+)
+
+// Client is interface for the moderation API with the possibility to mock it.
+type Client interface {
+	Moderations(ctx context.Context, request openai.ModerationRequest) (openai.ModerationResponse, error)
+}
+
+// Rule applies the action if the score of the category reaches the threshold.
+type Rule struct {
+	Category  string // e.g. hate or violence/graphic, * for any category
+	Threshold float64
+	Action    string
+}
+
+// ParseRule parses a rule in the category:threshold:action format.
+func ParseRule(s string) (Rule, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return Rule{}, fmt.Errorf("invalid rule %s, expected category:threshold:action", s)
+	}
+
+	threshold, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil {
+		return Rule{}, fmt.Errorf("invalid threshold in rule %s: %w", s, err)
+	}
+
+	switch parts[2] {
+	case ActionBlock, ActionWarn, ActionNotify:
+	default:
+		return Rule{}, fmt.Errorf("invalid action in rule %s", s)
+	}
+
+	return Rule{Category: parts[0], Threshold: threshold, Action: parts[2]}, nil
+}
+
+// Verdict is the result of the moderation of a text.
+type Verdict struct {
+	Actions    []string // sorted, empty if the text is fine
+	Categories []string // sorted categories which matched the rules
+}
+
+// Has reports whether the action applies.
+func (v Verdict) Has(action string) bool {
+	for _, a := range v.Actions {
+		if a == action {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Event is a flagged text stored for admin review.
+type Event struct {
+	Time       time.Time `json:"time"`
+	Stage      string    `json:"stage"`
+	UserID     int64     `json:"user_id"`
+	ChatID     int64     `json:"chat_id"`
+	Text       string    `json:"text"`
+	Categories []string  `json:"categories"`
+	Actions    []string  `json:"actions"`
+}
+
+// Moderator checks texts with the moderation API.
+type Moderator struct {
+	// FailClosed blocks the texts when the API fails, they are not moderated otherwise.
+	FailClosed bool
+	// Redact is applied to the texts sent to the API, nil to send them as is.
+	Redact func(string) string
+
+	client Client
+	rules  []Rule
+	store  *store.Store
+	now    func() time.Time
+}
+
+// New makes a moderator. Without rules, the texts flagged by the API are blocked.
+func New(client Client, rules []Rule, s *store.Store) *Moderator {
+	return &Moderator{client: client, rules: rules, store: s, now: time.Now}
+}
+
+// Check moderates the text.
+func (m *Moderator) Check(ctx context.Context, text string) (Verdict, error) {
+	if m.Redact != nil {
+		text = m.Redact(text)
+	}
+
+	res, err := m.client.Moderations(ctx, openai.ModerationRequest{Input: text})
+	if err != nil {
+		return Verdict{}, err
+	}
+
+	actions := make(map[string]bool)
+	categories := make(map[string]bool)
+
+	for _, r := range res.Results {
+		if len(m.rules) == 0 {
+			if r.Flagged {
+				actions[ActionBlock] = true
+				for category, flagged := range flags(r.Categories) {
+					if flagged {
+						categories[category] = true
+					}
+				}
+			}
+
+			continue
+		}
+
+		for category, score := range scores(r.CategoryScores) {
+			for _, rule := range m.rules {
+				if (rule.Category == "*" || rule.Category == category) && score >= rule.Threshold {
+					actions[rule.Action] = true
+					categories[category] = true
+				}
+			}
+		}
+	}
+
+	return Verdict{Actions: keys(actions), Categories: keys(categories)}, nil
+}
+
+// Record stores the flagged event for admin review and deletes the events
+// older than the retention or above the maximum number of events.
+func (m *Moderator) Record(e Event) error {
+	if r := []rune(e.Text); len(r) > maxEventText {
+		e.Text = string(r[:maxEventText]) + "…"
+	}
+
+	now := m.now()
+	if e.Time.IsZero() {
+		e.Time = now
+	}
+
+	if err := m.store.Put(eventsBucket, eventKey(e.Time), e); err != nil {
+		return err
+	}
+
+	return m.prune(now)
+}
+
+// prune deletes the events older than the retention and the oldest ones above the maximum number.
+func (m *Moderator) prune(now time.Time) error {
+	oldest := eventKey(now.Add(-retention))
+
+	keys := m.store.Keys(eventsBucket)
+	for i, key := range keys {
+		if key >= oldest && len(keys)-i <= maxEvents {
+			break
+		}
+
+		if err := m.store.Delete(eventsBucket, key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// eventKey returns the key of the event at the time, so that the keys sort by time.
+func eventKey(t time.Time) string {
+	return fmt.Sprintf("%020d", t.UnixNano())
+}
+
+// Events returns the last flagged events, the newest first.
+func (m *Moderator) Events(limit int) ([]Event, error) {
+	keys := m.store.Keys(eventsBucket)
+
+	var events []Event
+	for i := len(keys) - 1; i >= 0 && len(events) < limit; i-- {
+		var e Event
+		if _, err := m.store.Get(eventsBucket, keys[i], &e); err != nil {
+			return nil, err
+		}
+
+		events = append(events, e)
+	}
+
+	return events, nil
+}
+
+// scores returns the scores by the names of the categories.
+func scores(s openai.ResultCategoryScores) map[string]float64 {
+	var m map[string]float64
+	data, _ := json.Marshal(s)
+	json.Unmarshal(data, &m)
+
+	return m
+}
+
+// flags returns the flags by the names of the categories.
+func flags(c openai.ResultCategories) map[string]bool {
+	var m map[string]bool
+	data, _ := json.Marshal(c)
+	json.Unmarshal(data, &m)
+
+	return m
+}
+
+func keys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,126 @@
+package moderation
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ivanglie/chatgpt-bot/internal/redact"
+	"github.com/ivanglie/chatgpt-bot/internal/store"
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+type MockModerations struct {
+	inputs []string
+}
+
+func (m *MockModerations) Moderations(_ context.Context, req openai.ModerationRequest) (openai.ModerationResponse, error) {
+	m.inputs = append(m.inputs, req.Input)
+
+	var r openai.Result
+	switch req.Input {
+	case "hate":
+		r = openai.Result{Flagged: true, Categories: openai.ResultCategories{Hate: true}, CategoryScores: openai.ResultCategoryScores{Hate: 0.9}}
+	case "violence":
+		r = openai.Result{CategoryScores: openai.ResultCategoryScores{Violence: 0.4, ViolenceGraphic: 0.2}}
+	}
+
+	return openai.ModerationResponse{Results: []openai.Result{r}}, nil
+}
+
+func TestParseRule(t *testing.T) {
+	r, err := ParseRule("violence/graphic:0.5:warn")
+	assert.Nil(t, err)
+	assert.Equal(t, Rule{Category: "violence/graphic", Threshold: 0.5, Action: ActionWarn}, r)
+
+	for _, s := range []string{"hate", "hate:high:block", "hate:0.5:ban"} {
+		_, err = ParseRule(s)
+		assert.NotNil(t, err, s)
+	}
+}
+
+func TestModerator_Check(t *testing.T) {
+	s, _ := store.Open("")
+
+	m := New(&MockModerations{}, nil, s)
+
+	v, err := m.Check(context.Background(), "hate")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{ActionBlock}, v.Actions)
+	assert.Equal(t, []string{"hate"}, v.Categories)
+
+	v, err = m.Check(context.Background(), "violence")
+	assert.Nil(t, err)
+	assert.Empty(t, v.Actions)
+
+	m = New(&MockModerations{}, []Rule{
+		{Category: "violence", Threshold: 0.3, Action: ActionWarn},
+		{Category: "*", Threshold: 0.2, Action: ActionNotify},
+		{Category: "hate", Threshold: 0.95, Action: ActionBlock},
+	}, s)
+
+	v, err = m.Check(context.Background(), "violence")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{ActionNotify, ActionWarn}, v.Actions)
+	assert.Equal(t, []string{"violence", "violence/graphic"}, v.Categories)
+	assert.True(t, v.Has(ActionWarn))
+	assert.False(t, v.Has(ActionBlock))
+
+	v, err = m.Check(context.Background(), "hate")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{ActionNotify}, v.Actions)
+}
+
+func TestModerator_Redact(t *testing.T) {
+	s, _ := store.Open("")
+	client := &MockModerations{}
+	m := New(client, nil, s)
+	m.Redact = func(text string) string {
+		return redact.New(redact.DefaultPatterns()).Redact(redact.NewMapping(), text)
+	}
+
+	_, err := m.Check(context.Background(), "Write to john@example.com")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Write to [EMAIL_1]"}, client.inputs)
+}
+
+func TestModerator_Events(t *testing.T) {
+	s, _ := store.Open("")
+	m := New(&MockModerations{}, nil, s)
+
+	now := time.Now()
+	for i, text := range []string{"first", "second", "third"} {
+		assert.Nil(t, m.Record(Event{Time: now.Add(time.Duration(i) * time.Second), Stage: StageInput, Text: text}))
+	}
+
+	events, err := m.Events(2)
+	assert.Nil(t, err)
+	assert.Len(t, events, 2)
+	assert.Equal(t, "third", events[0].Text)
+	assert.Equal(t, "second", events[1].Text)
+}
+
+func TestModerator_Prune(t *testing.T) {
+	s, _ := store.Open("")
+	m := New(&MockModerations{}, nil, s)
+
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+
+	// The events older than the retention are deleted
+	assert.Nil(t, m.Record(Event{Time: now.Add(-retention - time.Hour), Text: "old"}))
+	assert.Nil(t, m.Record(Event{Time: now.Add(-retention + time.Hour), Text: "recent"}))
+	assert.Len(t, s.Keys(eventsBucket), 1)
+
+	// The oldest events above the maximum are deleted
+	for i := range maxEvents {
+		assert.Nil(t, m.Record(Event{Time: now.Add(time.Duration(i) * time.Second), Text: strconv.Itoa(i)}))
+	}
+	assert.Len(t, s.Keys(eventsBucket), maxEvents)
+
+	events, err := m.Events(maxEvents)
+	assert.Nil(t, err)
+	assert.Equal(t, "0", events[len(events)-1].Text)
+}
@@ -0,0 +1,252 @@
+package oai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DateTime is a tool which returns the current date and time.
+type DateTime struct {
+	Now func() time.Time // time.Now if nil
+}
+
+// Name implements Tool.
+func (DateTime) Name() string { return "datetime" }
+
+// Description implements Tool.
+func (DateTime) Description() string {
+	return "Returns the current date, time and day of the week in the IANA time zone, UTC by default."
+}
+
+// Schema implements Tool.
+func (DateTime) Schema() json.RawMessage {
+	return json.RawMessage(`{"type":"object","properties":{"timezone":{"type":"string","description":"IANA time zone, e.g. Europe/Moscow"}}}`)
+}
+
+// Call implements Tool.
+func (d DateTime) Call(_ context.Context, arguments string) (string, error) {
+	var args struct {
+		Timezone string `json:"timezone"`
+	}
+
+	if arguments != "" {
+		if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+			return "", err
+		}
+	}
+
+	loc, err := time.LoadLocation(args.Timezone)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now
+	if d.Now != nil {
+		now = d.Now
+	}
+
+	return now().In(loc).Format("Monday, 2006-01-02 15:04:05 MST"), nil
+}
+
+// Calculator is a tool which evaluates arithmetic expressions.
+type Calculator struct{}
+
+// Name implements Tool.
+func (Calculator) Name() string { return "calculator" }
+
+// Description implements Tool.
+func (Calculator) Description() string {
+	return "Evaluates an arithmetic expression with numbers, parentheses and the + - * / % ^ operators."
+}
+
+// Schema implements Tool.
+func (Calculator) Schema() json.RawMessage {
+	return json.RawMessage(`{"type":"object","properties":{"expression":{"type":"string","description":"e.g. (2 + 3) * 4 ^ 2"}},"required":["expression"]}`)
+}
+
+// Call implements Tool.
+func (Calculator) Call(_ context.Context, arguments string) (string, error) {
+	var args struct {
+		Expression string `json:"expression"`
+	}
+
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return "", err
+	}
+
+	res, err := evaluate(args.Expression)
+	if err != nil {
+		return "", err
+	}
+
+	return strconv.FormatFloat(res, 'g', -1, 64), nil
+}
+
+// evaluate returns the value of the arithmetic expression.
+func evaluate(expression string) (float64, error) {
+	p := &parser{input: strings.ReplaceAll(expression, " ", "")}
+
+	res, err := p.sum()
+	if err != nil {
+		return 0, err
+	}
+
+	if p.pos < len(p.input) {
+		return 0, fmt.Errorf("unexpected %q at %d", p.input[p.pos], p.pos)
+	}
+
+	if math.IsInf(res, 0) || math.IsNaN(res) {
+		return 0, errors.New("the result is not a number")
+	}
+
+	return res, nil
+}
+
+// parser is a recursive descent parser of arithmetic expressions.
+type parser struct {
+	input string
+	pos   int
+}
+
+func (p *parser) peek() byte {
+	if p.pos < len(p.input) {
+		return p.input[p.pos]
+	}
+
+	return 0
+}
+
+// sum parses terms separated by + and -.
+func (p *parser) sum() (float64, error) {
+	res, err := p.product()
+	if err != nil {
+		return 0, err
+	}
+
+	for op := p.peek(); op == '+' || op == '-'; op = p.peek() {
+		p.pos++
+
+		v, err := p.product()
+		if err != nil {
+			return 0, err
+		}
+
+		if op == '+' {
+			res += v
+		} else {
+			res -= v
+		}
+	}
+
+	return res, nil
+}
+
+// product parses factors separated by *, / and %.
+func (p *parser) product() (float64, error) {
+	res, err := p.unary()
+	if err != nil {
+		return 0, err
+	}
+
+	for op := p.peek(); op == '*' || op == '/' || op == '%'; op = p.peek() {
+		p.pos++
+
+		v, err := p.unary()
+		if err != nil {
+			return 0, err
+		}
+
+		switch op {
+		case '*':
+			res *= v
+		case '/':
+			if v == 0 {
+				return 0, errors.New("division by zero")
+			}
+			res /= v
+		case '%':
+			if v == 0 {
+				return 0, errors.New("division by zero")
+			}
+			res = math.Mod(res, v)
+		}
+	}
+
+	return res, nil
+}
+
+// unary parses a signed power, so that -2 ^ 2 is -4.
+func (p *parser) unary() (float64, error) {
+	switch p.peek() {
+	case '-':
+		p.pos++
+		v, err := p.unary()
+		return -v, err
+	case '+':
+		p.pos++
+		return p.unary()
+	}
+
+	return p.power()
+}
+
+// power parses a right-associative exponentiation, the exponent may be signed.
+func (p *parser) power() (float64, error) {
+	base, err := p.primary()
+	if err != nil {
+		return 0, err
+	}
+
+	if p.peek() != '^' {
+		return base, nil
+	}
+
+	p.pos++
+
+	exp, err := p.unary()
+	if err != nil {
+		return 0, err
+	}
+
+	return math.Pow(base, exp), nil
+}
+
+// primary parses a number or a parenthesized expression.
+func (p *parser) primary() (float64, error) {
+	if p.peek() == '(' {
+		p.pos++
+
+		v, err := p.sum()
+		if err != nil {
+			return 0, err
+		}
+
+		if p.peek() != ')' {
+			return 0, fmt.Errorf("missing ) at %d", p.pos)
+		}
+		p.pos++
+
+		return v, nil
+	}
+
+	start := p.pos
+	for c := p.peek(); c >= '0' && c <= '9' || c == '.'; c = p.peek() {
+		p.pos++
+	}
+
+	if start == p.pos {
+		if p.pos < len(p.input) {
+			return 0, fmt.Errorf("unexpected %q at %d", p.input[p.pos], p.pos)
+		}
+
+		return 0, errors.New("unexpected end of expression")
+	}
+
+	return strconv.ParseFloat(p.input[start:p.pos], 64)
+}
@@ -0,0 +1,55 @@
+package oai
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDateTime_Call(t *testing.T) {
+	d := DateTime{Now: func() time.Time { return time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC) }}
+
+	res, err := d.Call(context.Background(), "")
+	assert.Nil(t, err)
+	assert.Equal(t, "Friday, 2024-03-01 12:30:00 UTC", res)
+
+	res, err = d.Call(context.Background(), `{"timezone":"Asia/Tokyo"}`)
+	assert.Nil(t, err)
+	assert.Equal(t, "Friday, 2024-03-01 21:30:00 JST", res)
+
+	_, err = d.Call(context.Background(), `{"timezone":"Mars/Olympus"}`)
+	assert.NotNil(t, err)
+}
+
+func TestCalculator_Call(t *testing.T) {
+	tests := []struct {
+		expression string
+		result     string
+		err        bool
+	}{
+		{"2 + 2", "4", false},
+		{"2 + 3 * 4", "14", false},
+		{"(2 + 3) * 4", "20", false},
+		{"2 ^ 3 ^ 2", "512", false},
+		{"-2 ^ 2", "-4", false},
+		{"(-2) ^ 2", "4", false},
+		{"2 ^ -1", "0.5", false},
+		{"-2 ^ -2 * 4", "-1", false},
+		{"10 % 4 - 1.5", "0.5", false},
+		{"7 / 2", "3.5", false},
+		{"1 / 0", "", true},
+		{"(1 + 2", "", true},
+		{"1 +", "", true},
+		{"2 x 3", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			res, err := Calculator{}.Call(context.Background(), `{"expression":"`+tt.expression+`"}`)
+			assert.Equal(t, tt.err, err != nil)
+			assert.Equal(t, tt.result, res)
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package oai
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// Types of the API.
+const (
+	APITypeOpenAI     = "openai"
+	APITypeAzure      = "azure"
+	APITypeCompatible = "compatible" // any OpenAI-compatible server, e.g. llama.cpp or Ollama
+)
+
+// Config is a configuration of the API endpoint.
+type Config struct {
+	APIType      string            // openai by default
+	BaseURL      string            // required for azure and compatible
+	Organization string            // openai only
+	APIVersion   string            // azure only
+	Deployments  map[string]string // model -> deployment, azure only
+	Model        string            // gpt-4o-mini by default
+	Timeout      time.Duration     // of a whole request including streaming, no timeout if zero
+	Proxy        string            // URL of the proxy, from the environment if empty
+}
+
+// NewClient makes a client for the API endpoint.
+func NewClient(authToken string, config Config) (*openai.Client, error) {
+	var c openai.ClientConfig
+
+	switch strings.ToLower(config.APIType) {
+	case "", APITypeOpenAI:
+		if authToken == "" {
+			return nil, errors.New("OPENAI_API_KEY is empty")
+		}
+
+		c = openai.DefaultConfig(authToken)
+		c.OrgID = config.Organization
+		if config.BaseURL != "" {
+			c.BaseURL = config.BaseURL
+		}
+	case APITypeAzure:
+		if authToken == "" || config.BaseURL == "" {
+			return nil, errors.New("API key and base URL are required for Azure OpenAI")
+		}
+
+		c = openai.DefaultAzureConfig(authToken, config.BaseURL)
+		if config.APIVersion != "" {
+			c.APIVersion = config.APIVersion
+		}
+
+		mapper := c.AzureModelMapperFunc
+		c.AzureModelMapperFunc = func(model string) string {
+			if deployment, ok := config.Deployments[model]; ok {
+				return deployment
+			}
+
+			return mapper(model)
+		}
+	case APITypeCompatible:
+		if config.BaseURL == "" {
+			return nil, errors.New("base URL is required for an OpenAI-compatible API")
+		}
+
+		// Local servers usually do not check the key
+		c = openai.DefaultConfig(authToken)
+		c.BaseURL = config.BaseURL
+	default:
+		return nil, fmt.Errorf("unknown API type %s", config.APIType)
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if config.Proxy != "" {
+		u, err := url.Parse(config.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %w", err)
+		}
+
+		proxy = http.ProxyURL(u)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy
+
+	c.HTTPClient = &http.Client{Transport: transport, Timeout: config.Timeout}
+
+	return openai.NewClientWithConfig(c), nil
+}
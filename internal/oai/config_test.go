@@ -0,0 +1,71 @@
+package oai
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewClient_Validation(t *testing.T) {
+	tests := []struct {
+		name      string
+		authToken string
+		config    Config
+		ok        bool
+	}{
+		{"openai", "OPENAI_API_KEY", Config{}, true},
+		{"openai without key", "", Config{}, false},
+		{"azure", "OPENAI_API_KEY", Config{APIType: APITypeAzure, BaseURL: "https://example.openai.azure.com"}, true},
+		{"azure without base URL", "OPENAI_API_KEY", Config{APIType: APITypeAzure}, false},
+		{"compatible without key", "", Config{APIType: APITypeCompatible, BaseURL: "http://localhost:8080/v1"}, true},
+		{"compatible without base URL", "", Config{APIType: APITypeCompatible}, false},
+		{"unknown", "OPENAI_API_KEY", Config{APIType: "unknown"}, false},
+		{"invalid proxy", "OPENAI_API_KEY", Config{Proxy: "://proxy"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewClient(tt.authToken, tt.config)
+			assert.Equal(t, tt.ok, err == nil)
+			assert.Equal(t, tt.ok, c != nil)
+		})
+	}
+}
+
+func TestNewClient_Endpoints(t *testing.T) {
+	var paths []string
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path+"?"+r.URL.RawQuery+" "+r.Header.Get("OpenAI-Organization"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"Pong"}}]}`))
+	}))
+	defer ts.Close()
+
+	req := openai.ChatCompletionRequest{Model: openai.GPT4oMini, Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Ping"}}}
+
+	configs := []Config{
+		{BaseURL: ts.URL + "/v1", Organization: "org"},
+		{APIType: APITypeAzure, BaseURL: ts.URL, APIVersion: "2024-06-01", Deployments: map[string]string{openai.GPT4oMini: "mini"}},
+		{APIType: APITypeCompatible, BaseURL: ts.URL + "/llama/v1"},
+	}
+
+	for _, config := range configs {
+		c, err := NewClient("OPENAI_API_KEY", config)
+		assert.Nil(t, err)
+
+		res, err := c.CreateChatCompletion(context.Background(), req)
+		assert.Nil(t, err)
+		assert.Equal(t, "Pong", res.Choices[0].Message.Content)
+	}
+
+	assert.Equal(t, []string{
+		"/v1/chat/completions? org",
+		"/openai/deployments/mini/chat/completions?api-version=2024-06-01 ",
+		"/llama/v1/chat/completions? ",
+	}, paths)
+}
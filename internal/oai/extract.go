@@ -0,0 +1,48 @@
+package oai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/ivanglie/chatgpt-bot/internal/redact"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// Extract asks the model to fill the JSON schema named name with the data of the text
+// following the instructions and decodes the answer into v.
+func (o *OpenAI) Extract(ctx context.Context, instructions, text, name string, schema json.RawMessage, v any) error {
+	var m *redact.Mapping
+	if o.redacting() {
+		m = redact.NewMapping()
+	}
+
+	req := openai.ChatCompletionRequest{
+		Model:     o.model,
+		MaxTokens: o.maxTokens,
+		Messages: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleSystem, Content: instructions},
+			{Role: openai.ChatMessageRoleUser, Content: o.redact(m, text)},
+		},
+		ResponseFormat: &openai.ChatCompletionResponseFormat{
+			Type:       openai.ChatCompletionResponseFormatTypeJSONSchema,
+			JSONSchema: &openai.ChatCompletionResponseFormatJSONSchema{Name: name, Schema: schema, Strict: true},
+		},
+	}
+
+	c, _, err := o.send(ctx, "", req, nil)
+	if err != nil {
+		return err
+	}
+
+	if c.message.Content == "" {
+		return errors.New("empty response")
+	}
+
+	content := c.message.Content
+	if m != nil {
+		content = m.Restore(content)
+	}
+
+	return json.Unmarshal([]byte(content), v)
+}
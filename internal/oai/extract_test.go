@@ -0,0 +1,36 @@
+package oai
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/rs/zerolog"
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenAI_Extract(t *testing.T) {
+	mock := &MockOpenAI{echo: true}
+	c, _ := New("OPENAI_API_KEY", 0, "", Config{}, zerolog.Nop())
+	c.router = NewRouter(Backend{Name: DefaultBackend, Client: mock})
+
+	schema := json.RawMessage(`{"type":"object","properties":{"name":{"type":"string"}},"required":["name"],"additionalProperties":false}`)
+
+	var v struct {
+		Name string `json:"name"`
+	}
+
+	err := c.Extract(context.Background(), "Extract the name", `{"name": "Ivan"}`, "person", schema, &v)
+	assert.Nil(t, err)
+	assert.Equal(t, "Ivan", v.Name)
+
+	format := mock.requests[0].ResponseFormat
+	assert.Equal(t, openai.ChatCompletionResponseFormatTypeJSONSchema, format.Type)
+	assert.Equal(t, "person", format.JSONSchema.Name)
+	assert.True(t, format.JSONSchema.Strict)
+	assert.Equal(t, openai.ChatMessageRoleSystem, mock.requests[0].Messages[0].Role)
+
+	err = c.Extract(context.Background(), "Extract the name", "not json", "person", schema, &v)
+	assert.Error(t, err)
+}
@@ -0,0 +1,141 @@
+package oai
+
+import (
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// node is a message of the conversation tree.
+type node struct {
+	message   openai.ChatCompletionMessage
+	steps     []openai.ChatCompletionMessage // tool calls and their results which preceded the message
+	parent    *node                          // nil for the first message of the conversation
+	messageID int                            // Telegram message ID, zero if unknown
+	time      time.Time                      // time of the message
+	model     string                         // model which answered, empty for requests
+	memory    bool                           // facts recalled about the user rather than a message
+}
+
+// history is a conversation stored as a tree of messages,
+// so that replies to older messages start new branches.
+type history struct {
+	nodes []*node // in order of creation
+	head  *node   // the last message of the most recent branch
+
+	used time.Time // time of the last change
+	size int       // estimated memory at the last change
+}
+
+// find returns the node with the Telegram message ID or nil.
+func (h *history) find(messageID int) *node {
+	if h == nil || messageID == 0 {
+		return nil
+	}
+
+	for i := len(h.nodes) - 1; i >= 0; i-- {
+		if h.nodes[i].messageID == messageID {
+			return h.nodes[i]
+		}
+	}
+
+	return nil
+}
+
+// has reports whether the node is in the conversation, a nil node is its root.
+func (h *history) has(n *node) bool {
+	if n == nil {
+		return true
+	}
+
+	for _, m := range h.nodes {
+		if m == n {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parent returns the node a new message continues: the replied-to
+// message if it is known, otherwise the head of the most recent branch.
+func (h *history) parent(replyToID int) *node {
+	if h == nil {
+		return nil
+	}
+
+	if n := h.find(replyToID); n != nil {
+		return n
+	}
+
+	return h.head
+}
+
+// add appends the message as a child of the parent and makes it the head.
+func (h *history) add(parent *node, message openai.ChatCompletionMessage, messageID int) *node {
+	n := &node{message: message, parent: parent, messageID: messageID}
+	h.nodes = append(h.nodes, n)
+	h.head = n
+
+	return n
+}
+
+// children returns the children of the node in order of creation.
+func (h *history) children(parent *node) []*node {
+	var children []*node
+	for _, n := range h.nodes {
+		if n.parent == parent {
+			children = append(children, n)
+		}
+	}
+
+	return children
+}
+
+// prune removes the descendants of the node.
+func (h *history) prune(parent *node) {
+	nodes := h.nodes[:0]
+	for _, n := range h.nodes {
+		if !descends(n, parent) {
+			nodes = append(nodes, n)
+		}
+	}
+
+	h.nodes = nodes
+
+	if descends(h.head, parent) {
+		h.head = parent
+	}
+}
+
+// descends reports whether the node is a descendant of the ancestor.
+func descends(n, ancestor *node) bool {
+	if n == nil {
+		return false
+	}
+
+	for n = n.parent; n != nil; n = n.parent {
+		if n == ancestor {
+			return true
+		}
+	}
+
+	return false
+}
+
+// path returns the messages from the beginning of the conversation to the node.
+func (h *history) path(n *node) []openai.ChatCompletionMessage {
+	var messages []openai.ChatCompletionMessage
+	for ; n != nil; n = n.parent {
+		messages = append(messages, n.message)
+		for i := len(n.steps) - 1; i >= 0; i-- {
+			messages = append(messages, n.steps[i])
+		}
+	}
+
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+
+	return messages
+}
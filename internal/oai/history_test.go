@@ -0,0 +1,58 @@
+package oai
+
+import (
+	"testing"
+
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistory_Branches(t *testing.T) {
+	msg := func(content string) openai.ChatCompletionMessage {
+		return openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: content}
+	}
+
+	h := &history{}
+	assert.Nil(t, h.parent(0))
+
+	q1 := h.add(nil, msg("q1"), 1)
+	a1 := h.add(q1, msg("a1"), 2)
+	q2 := h.add(h.parent(0), msg("q2"), 3)
+	h.add(q2, msg("a2"), 4)
+
+	assert.Equal(t, []openai.ChatCompletionMessage{msg("q1"), msg("a1"), msg("q2"), msg("a2")}, h.path(h.head))
+
+	// A reply to the first answer starts a new branch
+	assert.Equal(t, a1, h.parent(2))
+	q3 := h.add(h.parent(2), msg("q3"), 5)
+	assert.Equal(t, []openai.ChatCompletionMessage{msg("q1"), msg("a1"), msg("q3")}, h.path(q3))
+	assert.Equal(t, []*node{q2, q3}, h.children(a1))
+
+	// Unknown messages continue the most recent branch
+	assert.Equal(t, q3, h.parent(42))
+	assert.Nil(t, h.find(0))
+}
+
+func TestHistory_Prune(t *testing.T) {
+	msg := func(content string) openai.ChatCompletionMessage {
+		return openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: content}
+	}
+
+	h := &history{}
+	q1 := h.add(nil, msg("q1"), 1)
+	a1 := h.add(q1, msg("a1"), 2)
+	q2 := h.add(a1, msg("q2"), 3)
+	h.add(q2, msg("a2"), 4)
+	q3 := h.add(nil, msg("q3"), 5)
+
+	h.prune(q2)
+	assert.Equal(t, []*node{q1, a1, q2, q3}, h.nodes)
+	assert.Equal(t, q3, h.head)
+
+	h.prune(q1)
+	assert.Equal(t, []*node{q1, q3}, h.nodes)
+
+	h.head = q1
+	h.prune(nil)
+	assert.Equal(t, []*node{q1, q3}, h.nodes)
+}
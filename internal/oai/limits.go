@@ -0,0 +1,147 @@
+package oai
+
+import (
+	"context"
+	"time"
+)
+
+// Reasons of evictions of conversation histories.
+const (
+	EvictIdle     = "idle"
+	EvictCapacity = "capacity"
+)
+
+// nodeOverhead is the estimated memory of a message besides its text.
+const nodeOverhead = 128
+
+// maxEvicted limits the remembered keys of the evicted histories.
+const maxEvicted = 10000
+
+// Limits bound the memory used by the conversation histories.
+type Limits struct {
+	IdleTTL          time.Duration // evict the histories unused for longer, zero for no limit
+	MaxConversations int           // evict the least recently used histories above the number, zero for no limit
+	MaxBytes         int           // evict the least recently used histories above the estimated size, zero for no limit
+	OnEvict          func(reason string)
+}
+
+// SetLimits sets the limits of the histories which are enforced on every change and by RunJanitor.
+func (o *OpenAI) SetLimits(l Limits) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.limits = l
+	o.evictOverCapacity("")
+}
+
+// RunJanitor evicts the idle histories with the interval until ctx is done.
+func (o *OpenAI) RunJanitor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			o.mu.Lock()
+			o.evictIdle()
+			o.mu.Unlock()
+		}
+	}
+}
+
+// touch marks the history of the key as used and evicts other ones if the limits are exceeded.
+// It must be called with the lock held after every change of the history.
+func (o *OpenAI) touch(chatKey string, h *history) {
+	if o.chatHistories[chatKey] != h {
+		return // evicted during the generation
+	}
+
+	h.used = o.now()
+
+	size := h.measure()
+	o.bytes += size - h.size
+	h.size = size
+
+	o.evictOverCapacity(chatKey)
+}
+
+// evictIdle evicts the histories unused for longer than the idle TTL.
+func (o *OpenAI) evictIdle() {
+	if o.limits.IdleTTL <= 0 {
+		return
+	}
+
+	deadline := o.now().Add(-o.limits.IdleTTL)
+	for chatKey, h := range o.chatHistories {
+		if h.used.Before(deadline) {
+			o.evict(chatKey, EvictIdle)
+		}
+	}
+}
+
+// evictOverCapacity evicts the least recently used histories except the kept one
+// while the number or the size of the histories exceed the limits.
+func (o *OpenAI) evictOverCapacity(keep string) {
+	for o.overCapacity() {
+		var (
+			lru  string
+			used time.Time
+		)
+
+		for chatKey, h := range o.chatHistories {
+			if chatKey != keep && (lru == "" || h.used.Before(used)) {
+				lru, used = chatKey, h.used
+			}
+		}
+
+		if lru == "" {
+			return
+		}
+
+		o.evict(lru, EvictCapacity)
+	}
+}
+
+func (o *OpenAI) overCapacity() bool {
+	return o.limits.MaxConversations > 0 && len(o.chatHistories) > o.limits.MaxConversations ||
+		o.limits.MaxBytes > 0 && o.bytes > o.limits.MaxBytes
+}
+
+// evict forgets the history and remembers its key to tell the user about the reset.
+func (o *OpenAI) evict(chatKey, reason string) {
+	h := o.chatHistories[chatKey]
+	if h == nil {
+		return
+	}
+
+	o.bytes -= h.size
+	delete(o.chatHistories, chatKey)
+	delete(o.mappings, chatKey)
+
+	if len(o.evicted) >= maxEvicted {
+		o.evicted = make(map[string]bool)
+	}
+	o.evicted[chatKey] = true
+
+	if o.limits.OnEvict != nil {
+		o.limits.OnEvict(reason)
+	}
+}
+
+// measure estimates the memory of the history.
+func (h *history) measure() int {
+	var size int
+	for _, n := range h.nodes {
+		size += nodeOverhead + len(n.message.Content)
+		for _, step := range n.steps {
+			size += nodeOverhead + len(step.Content)
+			for _, call := range step.ToolCalls {
+				size += len(call.Function.Name) + len(call.Function.Arguments)
+			}
+		}
+	}
+
+	return size
+}
@@ -0,0 +1,100 @@
+package oai
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenAI_EvictIdle(t *testing.T) {
+	c, _ := New("OPENAI_API_KEY", 0, "", Config{}, zerolog.Nop())
+	c.router = NewRouter(Backend{Name: DefaultBackend, Client: &MockOpenAI{}})
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	var evictions []string
+	c.SetLimits(Limits{IdleTTL: time.Hour, OnEvict: func(reason string) { evictions = append(evictions, reason) }})
+
+	key := Key{UserID: "userID", ChatID: "chatID"}
+	other := Key{UserID: "other", ChatID: "chatID"}
+
+	res, err := c.Generate(context.Background(), key, Request{Text: "Ping"}, nil)
+	assert.Nil(t, err)
+	assert.False(t, res.Reset)
+
+	now = now.Add(45 * time.Minute)
+	_, err = c.Generate(context.Background(), other, Request{Text: "Ping"}, nil)
+	assert.Nil(t, err)
+	assert.Positive(t, c.bytes)
+
+	now = now.Add(30 * time.Minute)
+	c.evictIdle()
+	assert.Equal(t, 1, c.Conversations())
+	assert.Equal(t, []string{EvictIdle}, evictions)
+
+	res, err = c.Generate(context.Background(), key, Request{Text: "Ping"}, nil)
+	assert.Nil(t, err)
+	assert.True(t, res.Reset)
+
+	res, err = c.Generate(context.Background(), key, Request{Text: "Ping"}, nil)
+	assert.Nil(t, err)
+	assert.False(t, res.Reset)
+}
+
+func TestOpenAI_EvictCapacity(t *testing.T) {
+	c, _ := New("OPENAI_API_KEY", 0, "", Config{}, zerolog.Nop())
+	c.router = NewRouter(Backend{Name: DefaultBackend, Client: &MockOpenAI{}})
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	c.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+
+	var evictions []string
+	c.SetLimits(Limits{MaxConversations: 2, OnEvict: func(reason string) { evictions = append(evictions, reason) }})
+
+	for _, id := range []string{"a", "b", "a", "c"} {
+		_, err := c.Generate(context.Background(), Key{UserID: id, ChatID: "chatID"}, Request{Text: "Ping"}, nil)
+		assert.Nil(t, err)
+	}
+
+	assert.Equal(t, 2, c.Conversations())
+	assert.Contains(t, c.chatHistories, "a:chatID")
+	assert.Contains(t, c.chatHistories, "c:chatID")
+	assert.Equal(t, []string{EvictCapacity}, evictions)
+
+	c.SetLimits(Limits{MaxBytes: c.bytes - 1})
+	assert.Equal(t, 1, c.Conversations())
+	assert.Contains(t, c.chatHistories, "c:chatID")
+	assert.Equal(t, c.chatHistories["c:chatID"].size, c.bytes)
+
+	c.Delete(Key{UserID: "c", ChatID: "chatID"})
+	assert.Zero(t, c.bytes)
+}
+
+func TestOpenAI_RunJanitor(t *testing.T) {
+	c, _ := New("OPENAI_API_KEY", 0, "", Config{}, zerolog.Nop())
+	c.router = NewRouter(Backend{Name: DefaultBackend, Client: &MockOpenAI{}})
+	c.SetLimits(Limits{IdleTTL: time.Nanosecond})
+
+	_, err := c.Generate(context.Background(), Key{UserID: "userID", ChatID: "chatID"}, Request{Text: "Ping"}, nil)
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		c.RunJanitor(ctx, time.Millisecond)
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool { return c.Conversations() == 0 }, time.Second, time.Millisecond)
+
+	cancel()
+	<-done
+}
@@ -0,0 +1,73 @@
+package oai
+
+import (
+	"context"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// Memory recalls the facts about the users which are relevant to a request.
+type Memory interface {
+	Recall(userID, request string) ([]string, error)
+}
+
+type userKey struct{}
+
+// WithUser returns the context of a request of the Telegram user,
+// so that the tools and the memory know whom they serve.
+func WithUser(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, userKey{}, userID)
+}
+
+// User returns the Telegram user of the context or an empty string.
+func User(ctx context.Context) string {
+	userID, _ := ctx.Value(userKey{}).(string)
+	return userID
+}
+
+// personal returns the context without the user for the conversations shared by the members of a chat,
+// so that neither the memory nor the tools act for one member in the shared history.
+func personal(ctx context.Context, key Key) context.Context {
+	if key.UserID == "" && User(ctx) != "" {
+		return WithUser(ctx, "")
+	}
+
+	return ctx
+}
+
+// SetMemory makes new conversations start with the facts about the user recalled from the memory.
+func (o *OpenAI) SetMemory(m Memory) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.memory = m
+}
+
+// recall returns the system message with the facts about the user of the context
+// relevant to the request, or false if there are none.
+func (o *OpenAI) recall(ctx context.Context, request string) (openai.ChatCompletionMessage, bool) {
+	o.mu.RLock()
+	memory := o.memory
+	o.mu.RUnlock()
+
+	userID := User(ctx)
+	if memory == nil || userID == "" {
+		return openai.ChatCompletionMessage{}, false
+	}
+
+	facts, err := memory.Recall(userID, request)
+	if err != nil {
+		o.log(ctx).Warn().Err(err).Msg("failed to recall the facts about the user")
+		return openai.ChatCompletionMessage{}, false
+	}
+
+	if len(facts) == 0 {
+		return openai.ChatCompletionMessage{}, false
+	}
+
+	return openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleSystem,
+		Content: "Facts about the user you remember from previous conversations:\n- " + strings.Join(facts, "\n- "),
+	}, true
+}
@@ -0,0 +1,89 @@
+package oai
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/rs/zerolog"
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+type MockMemory map[string][]string
+
+func (m MockMemory) Recall(userID, _ string) ([]string, error) {
+	return m[userID], nil
+}
+
+// whoami is a tool which records the user it serves.
+type whoami struct {
+	users *[]string
+}
+
+func (whoami) Name() string            { return "calculator" }
+func (whoami) Description() string     { return "" }
+func (whoami) Schema() json.RawMessage { return json.RawMessage(`{"type":"object"}`) }
+func (w whoami) Call(ctx context.Context, _ string) (string, error) {
+	*w.users = append(*w.users, User(ctx))
+	return "ok", nil
+}
+
+func TestUser(t *testing.T) {
+	assert.Equal(t, "", User(context.Background()))
+	assert.Equal(t, "1", User(WithUser(context.Background(), "1")))
+}
+
+func TestOpenAI_Memory(t *testing.T) {
+	mock := &MockOpenAI{}
+	c, _ := New("OPENAI_API_KEY", 0, "", Config{}, zerolog.Nop())
+	c.router = NewRouter(Backend{Name: DefaultBackend, Client: mock})
+	c.SetMemory(MockMemory{"1": {"Writes Go"}})
+
+	key := Key{UserID: "1", ChatID: "chatID"}
+	ctx := WithUser(context.Background(), "1")
+
+	_, err := c.Generate(ctx, key, Request{Text: "Hi"}, nil)
+	assert.Nil(t, err)
+
+	messages := mock.requests[0].Messages
+	assert.Len(t, messages, 3)
+	assert.Equal(t, openai.ChatMessageRoleSystem, messages[1].Role)
+	assert.Contains(t, messages[1].Content, "- Writes Go")
+
+	// The facts stay in the conversation and are not recalled again
+	_, err = c.Generate(ctx, key, Request{Text: "And?"}, nil)
+	assert.Nil(t, err)
+	assert.Len(t, mock.requests[1].Messages, 5)
+	assert.Contains(t, mock.requests[1].Messages[1].Content, "- Writes Go")
+
+	exported, err := c.Export(key)
+	assert.Nil(t, err)
+	assert.Len(t, exported, 4)
+
+	// Another user has no facts
+	_, err = c.Generate(WithUser(context.Background(), "2"), Key{UserID: "2", ChatID: "chatID"}, Request{Text: "Hi"}, nil)
+	assert.Nil(t, err)
+	assert.Len(t, mock.requests[2].Messages, 2)
+}
+
+func TestOpenAI_MemoryShared(t *testing.T) {
+	mock := &MockOpenAI{tool: "calculator"}
+	c, _ := New("OPENAI_API_KEY", 0, "", Config{}, zerolog.Nop())
+	c.router = NewRouter(Backend{Name: DefaultBackend, Client: mock})
+	c.SetMemory(MockMemory{"1": {"Writes Go"}})
+
+	var users []string
+	c.Tools().Register(whoami{users: &users}, true)
+
+	// The facts of the member are neither recalled nor remembered in a shared conversation
+	ctx := WithUser(context.Background(), "1")
+	_, err := c.Generate(ctx, Key{ChatID: "chatID"}, Request{Text: "Hi"}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, openai.ChatMessageRoleUser, mock.requests[0].Messages[1].Role)
+	assert.Equal(t, []string{""}, users)
+
+	_, err = c.Generate(ctx, Key{UserID: "1", ChatID: "chatID"}, Request{Text: "Hi"}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"", "1"}, users)
+}
@@ -4,15 +4,77 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"io"
+	"net/http"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/ivanglie/chatgpt-bot/internal/redact"
+	"github.com/ivanglie/chatgpt-bot/internal/transcript"
+	"github.com/rs/zerolog"
 	openai "github.com/sashabaranov/go-openai"
+	"golang.org/x/exp/slices"
 )
 
 // OpenAIClient is interface for OpenAI with the possibility to mock it.
 type OpenAIClient interface {
 	CreateChatCompletion(context.Context, openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error)
+	CreateChatCompletionStream(context.Context, openai.ChatCompletionRequest) (*openai.ChatCompletionStream, error)
+	ListModels(context.Context) (openai.ModelsList, error)
+}
+
+// ErrNotFound is returned when the response or the conversation is not in memory anymore.
+var ErrNotFound = errors.New("response not found")
+
+// continuePrompt asks the model to continue the previous answer.
+const continuePrompt = "Continue your previous answer exactly from where it stopped."
+
+// DefaultBackend is the name of the backend configured in New.
+const DefaultBackend = "default"
+
+// ProgressFunc receives the text of the response generated so far.
+type ProgressFunc func(text string)
+
+// Key identifies a conversation history.
+type Key struct {
+	UserID       string // empty if the history is shared by all members of the chat
+	ChatID       string
+	ThreadID     string // empty outside of forum topics
+	Conversation string // ID of a named conversation, empty for the only one
+}
+
+// String returns the key of the chat history.
+func (k Key) String() string {
+	key := k.UserID + ":" + k.ChatID
+	if k.ThreadID != "" {
+		key += ":" + k.ThreadID
+	}
+
+	if k.Conversation != "" {
+		key += "#" + k.Conversation
+	}
+
+	return key
+}
+
+// Request is a message of the user to the bot.
+type Request struct {
+	Text      string
+	MessageID int // Telegram message ID of the request
+	ReplyToID int // Telegram message ID the request replies to, zero to continue the most recent branch
+}
+
+// Response is an answer of the model.
+type Response struct {
+	Text      string
+	Truncated bool   // the answer was cut off by the token limit or stopped by the user
+	Reset     bool   // the previous history of the conversation was evicted
+	Backend   string // name of the backend which answered
+	Model     string // model of the backend which answered
+	Usage     openai.Usage
+
+	steps []openai.ChatCompletionMessage // tool calls and their results which preceded the answer
 }
 
 // OpenAI is a wrapper for OpenAIClient.
@@ -20,90 +82,798 @@ type OpenAI struct {
 	mu sync.RWMutex
 
 	authToken     string
-	client        OpenAIClient
+	router        *Router
+	model         string
 	maxTokens     int
 	prompt        string
-	chatHistories map[string][]openai.ChatCompletionMessage
+	tools         *Tools
+	logger        zerolog.Logger
+	chatHistories map[string]*history
+
+	limits  Limits
+	bytes   int             // estimated memory of the histories
+	evicted map[string]bool // keys of the evicted histories until they are used again
+	now     func() time.Time
+
+	redactor *redact.Redactor           // nil if sensitive values are sent as is
+	mappings map[string]*redact.Mapping // redacted values of the conversations
+
+	memory Memory // nil if the facts about the users are not recalled
 }
 
-// New makes a client for ChatGPT.
-func New(authToken string, maxTokens int, prompt string) (*OpenAI, error) {
-	if len(authToken) == 0 {
-		return nil, errors.New("OPENAI_API_KEY is empty")
+// New makes a client for ChatGPT which logs to the logger.
+func New(authToken string, maxTokens int, prompt string, config Config, logger zerolog.Logger) (*OpenAI, error) {
+	client, err := NewClient(authToken, config)
+	if err != nil {
+		return nil, err
+	}
+
+	model := config.Model
+	if model == "" {
+		model = openai.GPT4oMini
 	}
 
-	client := openai.NewClient(authToken)
-	log.Printf("[DEBUG] OpenAI with prompt=%s, max=%d", prompt, maxTokens)
+	logger.Debug().Str("api", config.APIType).Str("model", model).Bool("prompt", prompt != "").Int("max_tokens", maxTokens).Msg("OpenAI client")
 
 	return &OpenAI{
 		authToken:     authToken,
-		client:        client,
+		router:        NewRouter(Backend{Name: DefaultBackend, Client: client}),
+		model:         model,
 		maxTokens:     maxTokens,
 		prompt:        prompt,
-		chatHistories: make(map[string][]openai.ChatCompletionMessage),
+		tools:         NewTools(),
+		logger:        logger,
+		chatHistories: make(map[string]*history),
+		evicted:       make(map[string]bool),
+		now:           time.Now,
+		mappings:      make(map[string]*redact.Mapping),
 	}, nil
 }
 
-// Generate returns a response for the specific user and chat.
-func (o *OpenAI) Generate(userID, chatID, request string) (response string, err error) {
-	chatKey := userID + ":" + chatID
+// SetRedactor makes the sensitive values in the requests be replaced with placeholders
+// before they are sent to the backends and restored in the responses.
+func (o *OpenAI) SetRedactor(r *redact.Redactor) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.redactor = r
+}
+
+// Router returns the router of the backends.
+func (o *OpenAI) Router() *Router {
+	return o.router
+}
+
+// Tools returns the registry of tools which the model can call.
+func (o *OpenAI) Tools() *Tools {
+	return o.tools
+}
+
+// Generate returns a response for the specific conversation.
+// The request continues the branch of the message it replies to.
+// If progress is not nil, the response is streamed to it.
+func (o *OpenAI) Generate(ctx context.Context, key Key, request Request, progress ProgressFunc) (Response, error) {
+	ctx = personal(ctx, key)
+	chatKey := key.String()
+	m := o.mapping(chatKey)
 
 	o.mu.RLock()
-	history, exists := o.chatHistories[chatKey]
+	h := o.chatHistories[chatKey]
+	parent := h.parent(request.ReplyToID)
+	messages := append(o.system(), h.path(parent)...)
 	o.mu.RUnlock()
 
-	if !exists {
-		history = []openai.ChatCompletionMessage{
-			{
-				Role:    openai.ChatMessageRoleSystem,
-				Content: "You answer with no more than 50 words",
-			},
+	// A new conversation starts with the facts about the user
+	facts, recalled := openai.ChatCompletionMessage{}, false
+	if h == nil {
+		if facts, recalled = o.recall(ctx, request.Text); recalled {
+			facts.Content = o.redact(m, facts.Content)
+			messages = append(messages, facts)
 		}
+	}
 
-		if o.prompt != "" {
-			history = append(history, openai.ChatCompletionMessage{
-				Role:    openai.ChatMessageRoleSystem,
-				Content: o.prompt,
-			})
-		}
+	req := openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleUser,
+		Content: o.redact(m, request.Text),
 	}
 
-	history = append(history, openai.ChatCompletionMessage{
+	res, err := o.complete(ctx, key.ChatID, m, append(messages, req), restoring(m, progress))
+	if err != nil {
+		return Response{}, err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if h != nil && !o.current(chatKey, h, parent) {
+		return Response{}, ErrNotFound
+	}
+
+	if h = o.chatHistories[chatKey]; h == nil {
+		h = &history{}
+		o.chatHistories[chatKey] = h
+
+		res.Reset = o.evicted[chatKey]
+		delete(o.evicted, chatKey)
+	}
+
+	if recalled && h.head == nil {
+		parent = h.add(nil, facts, 0)
+		parent.memory = true
+		parent.time = o.now()
+	}
+
+	n := h.add(parent, req, request.MessageID)
+	n.time = o.now()
+	o.addResponse(h, n, res, 0)
+	o.touch(chatKey, h)
+
+	return restore(m, res), nil
+}
+
+// Complete returns a response to the request out of any conversation.
+func (o *OpenAI) Complete(ctx context.Context, request string) (Response, error) {
+	var m *redact.Mapping
+	if o.redacting() {
+		m = redact.NewMapping()
+	}
+
+	res, err := o.complete(ctx, "", m, append(o.system(), openai.ChatCompletionMessage{
 		Role:    openai.ChatMessageRoleUser,
-		Content: request,
-	})
+		Content: o.redact(m, request),
+	}), nil)
+	if err != nil {
+		return Response{}, err
+	}
 
-	res, err := o.client.CreateChatCompletion(
-		context.Background(),
-		openai.ChatCompletionRequest{
-			Model:     openai.GPT4oMini,
-			MaxTokens: o.maxTokens,
-			Messages:  history,
-		},
+	return restore(m, res), nil
+}
+
+// Prompt is a stored request out of any conversation, e.g. a scheduled one.
+type Prompt struct {
+	Text    string
+	Persona string // system prompt, none if empty
+	Context string // text the request refers to, none if empty
+}
+
+// RunPrompt returns a response to the prompt with the backend and the tools of the chat.
+func (o *OpenAI) RunPrompt(ctx context.Context, chatID string, p Prompt) (Response, error) {
+	var m *redact.Mapping
+	if o.redacting() {
+		m = redact.NewMapping()
+	}
+
+	messages := o.system()
+	if p.Persona != "" {
+		messages = append(messages, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleSystem, Content: p.Persona})
+	}
+
+	text := p.Text
+	if p.Context != "" {
+		text = "Context:\n" + p.Context + "\n\n" + text
+	}
+
+	res, err := o.complete(ctx, chatID, m, append(messages, openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleUser,
+		Content: o.redact(m, text),
+	}), nil)
+	if err != nil {
+		return Response{}, err
+	}
+
+	return restore(m, res), nil
+}
+
+// Regenerate replaces the response with the Telegram message ID by a new one.
+// The previous response stays in the conversation as a separate branch.
+func (o *OpenAI) Regenerate(ctx context.Context, key Key, responseID int, progress ProgressFunc) (Response, error) {
+	ctx = personal(ctx, key)
+	chatKey := key.String()
+	m := o.mapping(chatKey)
+
+	o.mu.RLock()
+	h := o.chatHistories[chatKey]
+	n := h.find(responseID)
+	if n == nil || n.message.Role != openai.ChatMessageRoleAssistant {
+		o.mu.RUnlock()
+		return Response{}, ErrNotFound
+	}
+
+	messages := append(o.system(), h.path(n.parent)...)
+	if n.parent != nil && n.parent.message.Role == openai.ChatMessageRoleAssistant {
+		messages = append(messages, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: continuePrompt})
+	}
+	o.mu.RUnlock()
+
+	res, err := o.complete(ctx, key.ChatID, m, messages, restoring(m, progress))
+	if err != nil {
+		return Response{}, err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if !o.current(chatKey, h, n) {
+		return Response{}, ErrNotFound
+	}
+
+	n.messageID = 0
+	o.addResponse(h, n.parent, res, responseID)
+	o.touch(chatKey, h)
+
+	return restore(m, res), nil
+}
+
+// Continue asks the model to continue the truncated response with the Telegram message ID.
+// The continuation is a new response to bind to the Telegram message ID of the truncated one.
+func (o *OpenAI) Continue(ctx context.Context, key Key, responseID int, progress ProgressFunc) (Response, error) {
+	ctx = personal(ctx, key)
+	chatKey := key.String()
+	m := o.mapping(chatKey)
+
+	o.mu.RLock()
+	h := o.chatHistories[chatKey]
+	n := h.find(responseID)
+	if n == nil || n.message.Role != openai.ChatMessageRoleAssistant {
+		o.mu.RUnlock()
+		return Response{}, ErrNotFound
+	}
+
+	messages := append(o.system(), h.path(n)...)
+	messages = append(messages, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: continuePrompt})
+	o.mu.RUnlock()
+
+	res, err := o.complete(ctx, key.ChatID, m, messages, restoring(m, progress))
+	if err != nil {
+		return Response{}, err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if !o.current(chatKey, h, n) {
+		return Response{}, ErrNotFound
+	}
+
+	o.addResponse(h, n, res, 0)
+	o.touch(chatKey, h)
+
+	return restore(m, res), nil
+}
+
+// Edit rewrites the request with the Telegram message ID of the edited one,
+// discards the conversation which followed it and generates a new response.
+// The response is bound to the Telegram message ID of the previous one.
+func (o *OpenAI) Edit(ctx context.Context, key Key, request Request, progress ProgressFunc) (Response, error) {
+	ctx = personal(ctx, key)
+	chatKey := key.String()
+	m := o.mapping(chatKey)
+
+	req := openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleUser,
+		Content: o.redact(m, request.Text),
+	}
+
+	o.mu.RLock()
+	h := o.chatHistories[chatKey]
+	n := h.find(request.MessageID)
+	if n == nil || n.message.Role != openai.ChatMessageRoleUser {
+		o.mu.RUnlock()
+		return Response{}, ErrNotFound
+	}
+
+	messages := append(o.system(), h.path(n.parent)...)
+	o.mu.RUnlock()
+
+	res, err := o.complete(ctx, key.ChatID, m, append(messages, req), restoring(m, progress))
+	if err != nil {
+		return Response{}, err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if !o.current(chatKey, h, n) {
+		return Response{}, ErrNotFound
+	}
+
+	var responseID int
+	if resp := lastResponse(h, n); resp != nil {
+		responseID = resp.messageID
+	}
+
+	h.prune(n)
+	n.message = req
+	n.time = o.now()
+	o.addResponse(h, n, res, responseID)
+	o.touch(chatKey, h)
+
+	return restore(m, res), nil
+}
+
+// Ping checks the credentials with a request for the models of the backends.
+// It succeeds if any backend answers.
+func (o *OpenAI) Ping(ctx context.Context) error {
+	var errs []error
+	for _, b := range o.router.order("", "") {
+		if _, err := b.Client.ListModels(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("backend %s: %w", b.Name, err))
+			continue
+		}
+
+		return nil
+	}
+
+	if len(errs) == 0 {
+		return errors.New("no backends")
+	}
+
+	return errors.Join(errs...)
+}
+
+// Export returns the messages of the most recent branch of the conversation.
+func (o *OpenAI) Export(key Key) ([]transcript.Message, error) {
+	chatKey := key.String()
+
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	h := o.chatHistories[chatKey]
+	if h == nil {
+		return nil, ErrNotFound
+	}
+
+	m := o.mappings[chatKey]
+
+	var messages []transcript.Message
+	for n := h.head; n != nil; n = n.parent {
+		if n.memory {
+			continue
+		}
+
+		text := n.message.Content
+		if m != nil {
+			text = m.Restore(text)
+		}
+
+		messages = append(messages, transcript.Message{Role: n.message.Role, Content: text, Time: n.time, Model: n.model})
+	}
+
+	slices.Reverse(messages)
+
+	return messages, nil
+}
+
+// Import replaces the conversation history with the messages.
+func (o *OpenAI) Import(key Key, messages []transcript.Message) {
+	chatKey := key.String()
+	m := o.mapping(chatKey)
+
+	h := &history{}
+	for _, msg := range messages {
+		n := h.add(h.head, openai.ChatCompletionMessage{Role: msg.Role, Content: o.redact(m, msg.Content)}, 0)
+		n.time, n.model = msg.Time, msg.Model
+		if n.time.IsZero() {
+			n.time = o.now()
+		}
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if old := o.chatHistories[chatKey]; old != nil {
+		o.bytes -= old.size
+	}
+
+	o.chatHistories[chatKey] = h
+	delete(o.evicted, chatKey)
+	o.touch(chatKey, h)
+}
+
+// Delete forgets the conversation history.
+func (o *OpenAI) Delete(key Key) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if h := o.chatHistories[key.String()]; h != nil {
+		o.bytes -= h.size
+	}
+
+	delete(o.chatHistories, key.String())
+	delete(o.mappings, key.String())
+}
+
+// Conversations returns the number of conversation histories in memory.
+func (o *OpenAI) Conversations() int {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	return len(o.chatHistories)
+}
+
+// ResponseID returns the Telegram message ID of the response to the request or zero.
+func (o *OpenAI) ResponseID(key Key, requestID int) int {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	h := o.chatHistories[key.String()]
+
+	n := h.find(requestID)
+	if n == nil {
+		return 0
+	}
+
+	if resp := lastResponse(h, n); resp != nil {
+		return resp.messageID
+	}
+
+	return 0
+}
+
+// completion is an answer of a backend.
+type completion struct {
+	message   openai.ChatCompletionMessage
+	truncated bool
+	usage     openai.Usage
+	model     string
+}
+
+// complete requests the model and executes the tools it calls until it answers.
+// The tools get the arguments with the values redacted by the mapping restored,
+// and their results are redacted before they are sent to the model.
+// If progress is not nil, the response is streamed and a response stopped by ctx
+// is returned as truncated.
+func (o *OpenAI) complete(ctx context.Context, chatID string, m *redact.Mapping, messages []openai.ChatCompletionMessage, progress ProgressFunc) (Response, error) {
+	tools := o.tools.definitions(chatID)
+
+	var (
+		steps []openai.ChatCompletionMessage
+		usage openai.Usage
 	)
 
+	for i := 0; ; i++ {
+		req := openai.ChatCompletionRequest{
+			Model:     o.model,
+			MaxTokens: o.maxTokens,
+			Messages:  append(messages[:len(messages):len(messages)], steps...),
+		}
+
+		// The last round has no tools to make the model answer
+		if i < maxToolIterations {
+			req.Tools = tools
+		}
+
+		c, backend, err := o.send(ctx, chatID, req, progress)
+		if err != nil {
+			return Response{}, err
+		}
+
+		usage.PromptTokens += c.usage.PromptTokens
+		usage.CompletionTokens += c.usage.CompletionTokens
+		usage.TotalTokens += c.usage.TotalTokens
+
+		msg := c.message
+		if len(msg.ToolCalls) == 0 || c.truncated {
+			if len(msg.Content) == 0 {
+				return Response{}, fmt.Errorf("empty response")
+			}
+
+			return Response{Text: msg.Content, Truncated: c.truncated, Backend: backend, Model: c.model, Usage: usage, steps: steps}, nil
+		}
+
+		steps = append(steps, msg)
+		for _, call := range msg.ToolCalls {
+			o.log(ctx).Debug().Str("tool", call.Function.Name).Msg("tool called")
+
+			// The model sees the placeholders, the tool needs the values
+			if m != nil {
+				call.Function.Arguments = m.RestoreJSON(call.Function.Arguments)
+			}
+
+			steps = append(steps, openai.ChatCompletionMessage{
+				Role:       openai.ChatMessageRoleTool,
+				Content:    o.redact(m, o.tools.call(ctx, chatID, call)),
+				ToolCallID: call.ID,
+			})
+		}
+	}
+}
+
+// send requests the backends for the chat in order until one of them answers.
+// A stream which failed after a part of the response was shown is not retried,
+// so that another backend's response does not follow it.
+func (o *OpenAI) send(ctx context.Context, chatID string, req openai.ChatCompletionRequest, progress ProgressFunc) (completion, string, error) {
+	err := errors.New("no backends")
+
+	var shown bool
+	for _, b := range o.router.order(chatID, req.Model) {
+		r := req
+		r.Model = b.model(req.Model)
+
+		var c completion
+		if progress == nil {
+			c, err = request(ctx, b.Client, r)
+		} else {
+			c, err = stream(ctx, b.Client, r, func(text string) {
+				shown = true
+				progress(text)
+			})
+		}
+
+		if err == nil {
+			c.model = r.Model
+			o.log(ctx).Debug().Str("backend", b.Name).Str("model", r.Model).Int("tokens", c.usage.TotalTokens).Msg("backend answered")
+			return c, b.Name, nil
+		}
+
+		if ctx.Err() != nil {
+			break
+		}
+
+		o.log(ctx).Warn().Err(err).Str("backend", b.Name).Msg("backend failed")
+
+		if shown {
+			break
+		}
+	}
+
+	return completion{}, "", err
+}
+
+// request requests the model and returns its message.
+func request(ctx context.Context, client OpenAIClient, req openai.ChatCompletionRequest) (completion, error) {
+	res, err := client.CreateChatCompletion(ctx, req)
 	if err != nil {
-		return "", err
+		return completion{}, err
 	}
 
 	if len(res.Choices) == 0 {
-		return "", fmt.Errorf("no choices in response")
+		return completion{}, fmt.Errorf("no choices in response")
+	}
+
+	return completion{
+		message:   res.Choices[0].Message,
+		truncated: res.Choices[0].FinishReason == openai.FinishReasonLength,
+		usage:     res.Usage,
+	}, nil
+}
+
+// stream requests the model and passes the response generated so far to progress.
+func stream(ctx context.Context, client OpenAIClient, req openai.ChatCompletionRequest, progress ProgressFunc) (completion, error) {
+	req.Stream = true
+	req.StreamOptions = &openai.StreamOptions{IncludeUsage: true}
+
+	s, err := client.CreateChatCompletionStream(ctx, req)
+	if badRequest(err) {
+		// Some Azure API versions and compatible servers reject stream_options, the usage is unknown then
+		req.StreamOptions = nil
+		s, err = client.CreateChatCompletionStream(ctx, req)
+	}
+	if err != nil {
+		return completion{}, err
+	}
+	defer s.Close()
+
+	var (
+		sb    strings.Builder
+		calls []openai.ToolCall
+		c     completion
+	)
+
+	for {
+		r, err := s.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			if ctx.Err() != nil && sb.Len() > 0 {
+				c.truncated = true
+				break
+			}
+
+			return completion{}, err
+		}
+
+		if r.Usage != nil {
+			c.usage = *r.Usage
+		}
+
+		if len(r.Choices) == 0 {
+			continue
+		}
+
+		if r.Choices[0].FinishReason == openai.FinishReasonLength {
+			c.truncated = true
+		}
+
+		delta := r.Choices[0].Delta
+		calls = mergeToolCalls(calls, delta.ToolCalls)
+
+		if delta.Content != "" {
+			sb.WriteString(delta.Content)
+			progress(sb.String())
+		}
+	}
+
+	c.message = openai.ChatCompletionMessage{
+		Role:      openai.ChatMessageRoleAssistant,
+		Content:   sb.String(),
+		ToolCalls: calls,
+	}
+
+	return c, nil
+}
+
+// badRequest reports whether the API rejected the request as invalid.
+func badRequest(err error) bool {
+	var (
+		apiErr *openai.APIError
+		reqErr *openai.RequestError
+	)
+
+	return errors.As(err, &apiErr) && apiErr.HTTPStatusCode == http.StatusBadRequest ||
+		errors.As(err, &reqErr) && reqErr.HTTPStatusCode == http.StatusBadRequest
+}
+
+// mergeToolCalls adds the streamed fragments of tool calls to the calls.
+func mergeToolCalls(calls, fragments []openai.ToolCall) []openai.ToolCall {
+	for _, f := range fragments {
+		i := len(calls) - 1
+		if f.Index != nil {
+			i = *f.Index
+		} else if f.ID != "" || i < 0 {
+			i = len(calls)
+		}
+
+		for len(calls) <= i {
+			calls = append(calls, openai.ToolCall{Type: openai.ToolTypeFunction})
+		}
+
+		call := &calls[i]
+		if f.ID != "" {
+			call.ID = f.ID
+		}
+
+		call.Function.Name += f.Function.Name
+		call.Function.Arguments += f.Function.Arguments
 	}
 
-	resp := res.Choices[0].Message.Content
+	return calls
+}
+
+// Bind sets the Telegram message ID of the response to the request,
+// so that replies to the response continue its branch.
+func (o *OpenAI) Bind(key Key, requestID, responseID int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
 
-	if len(resp) == 0 {
-		return "", fmt.Errorf("empty response")
+	h := o.chatHistories[key.String()]
+
+	n := h.find(requestID)
+	if n == nil {
+		return
 	}
 
-	history = append(history, openai.ChatCompletionMessage{
+	if resp := lastResponse(h, n); resp != nil {
+		resp.messageID = responseID
+	}
+}
+
+// current reports whether the conversation and its node read before a request are still in memory,
+// as the conversation may be evicted or the branch discarded during the request.
+func (o *OpenAI) current(chatKey string, h *history, n *node) bool {
+	return o.chatHistories[chatKey] == h && h.has(n)
+}
+
+// addResponse adds the response of the model as a child of the parent.
+func (o *OpenAI) addResponse(h *history, parent *node, res Response, messageID int) *node {
+	n := h.add(parent, openai.ChatCompletionMessage{
 		Role:    openai.ChatMessageRoleAssistant,
-		Content: resp,
-	})
+		Content: res.Text,
+	}, messageID)
+	n.steps = res.steps
+	n.time = o.now()
+	n.model = res.Model
+
+	return n
+}
+
+// lastResponse returns the last response of the model to the node or nil.
+func lastResponse(h *history, n *node) *node {
+	var resp *node
+	for _, child := range h.children(n) {
+		if child.message.Role == openai.ChatMessageRoleAssistant {
+			resp = child
+		}
+	}
+
+	return resp
+}
+
+// log returns the logger of the context, e.g. with the correlation ID of the request,
+// or the logger of the client.
+func (o *OpenAI) log(ctx context.Context) *zerolog.Logger {
+	if l := zerolog.Ctx(ctx); l.GetLevel() != zerolog.Disabled {
+		return l
+	}
+
+	return &o.logger
+}
+
+// redacting reports whether the sensitive values are redacted.
+func (o *OpenAI) redacting() bool {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
 
+	return o.redactor != nil
+}
+
+// mapping returns the redacted values of the conversation or nil if redaction is disabled.
+func (o *OpenAI) mapping(chatKey string) *redact.Mapping {
 	o.mu.Lock()
-	o.chatHistories[chatKey] = history
-	o.mu.Unlock()
+	defer o.mu.Unlock()
+
+	if o.redactor == nil {
+		return nil
+	}
+
+	m := o.mappings[chatKey]
+	if m == nil {
+		m = redact.NewMapping()
+		o.mappings[chatKey] = m
+	}
+
+	return m
+}
+
+// redact replaces the sensitive values in the text with the placeholders of the mapping.
+func (o *OpenAI) redact(m *redact.Mapping, text string) string {
+	if m == nil {
+		return text
+	}
+
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	return o.redactor.Redact(m, text)
+}
+
+// restore returns the response with the original values in place of the placeholders.
+func restore(m *redact.Mapping, res Response) Response {
+	if m != nil {
+		res.Text = m.Restore(res.Text)
+	}
+
+	return res
+}
+
+// restoring restores the original values in the streamed text.
+func restoring(m *redact.Mapping, progress ProgressFunc) ProgressFunc {
+	if m == nil || progress == nil {
+		return progress
+	}
+
+	return func(text string) {
+		progress(m.Restore(text))
+	}
+}
+
+// system returns the system messages for a conversation.
+func (o *OpenAI) system() []openai.ChatCompletionMessage {
+	messages := []openai.ChatCompletionMessage{
+		{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: "You answer with no more than 50 words",
+		},
+	}
+
+	if o.prompt != "" {
+		messages = append(messages, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: o.prompt,
+		})
+	}
 
-	return resp, nil
+	return messages
 }
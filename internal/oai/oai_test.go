@@ -1,35 +1,678 @@
 package oai
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
+	"github.com/ivanglie/chatgpt-bot/internal/redact"
+	"github.com/ivanglie/chatgpt-bot/internal/transcript"
+	"github.com/rs/zerolog"
 	openai "github.com/sashabaranov/go-openai"
 	"github.com/stretchr/testify/assert"
 )
 
-type MockOpenAI struct{}
+type MockOpenAI struct {
+	requests     []openai.ChatCompletionRequest
+	finishReason openai.FinishReason
+	tool         string // calls the tool if it is available
+	arguments    string // of the tool call, {"expression":"2+2"} if empty
+	loop         bool   // calls the tool even after its result
+	echo         bool   // answers with the last message
+	err          error
+	during       func() // called during the request
+}
+
+func (m *MockOpenAI) CreateChatCompletion(_ context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	m.requests = append(m.requests, req)
+	if m.during != nil {
+		m.during()
+	}
+
+	if m.err != nil {
+		return openai.ChatCompletionResponse{}, m.err
+	}
+
+	last := req.Messages[len(req.Messages)-1]
+	if m.tool != "" && len(req.Tools) > 0 && (m.loop || last.Role != openai.ChatMessageRoleTool) {
+		args := m.arguments
+		if args == "" {
+			args = `{"expression":"2+2"}`
+		}
+
+		call := openai.ToolCall{ID: "call", Type: openai.ToolTypeFunction, Function: openai.FunctionCall{Name: m.tool, Arguments: args}}
+		return openai.ChatCompletionResponse{Choices: []openai.ChatCompletionChoice{{
+			Message:      openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, ToolCalls: []openai.ToolCall{call}},
+			FinishReason: openai.FinishReasonToolCalls,
+		}}}, nil
+	}
+
+	content := "Pong"
+	if m.echo {
+		content = last.Content
+	}
 
-func (m *MockOpenAI) CreateChatCompletion(context.Context, openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
-	res := openai.ChatCompletionResponse{Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{Content: "Pong"}}}}
+	res := openai.ChatCompletionResponse{Choices: []openai.ChatCompletionChoice{{
+		Message:      openai.ChatCompletionMessage{Content: content},
+		FinishReason: m.finishReason,
+	}}, Usage: openai.Usage{PromptTokens: 2, CompletionTokens: 1, TotalTokens: 3}}
 	return res, nil
 }
 
+func (m *MockOpenAI) CreateChatCompletionStream(context.Context, openai.ChatCompletionRequest) (*openai.ChatCompletionStream, error) {
+	return nil, errors.New("not supported")
+}
+
+func (m *MockOpenAI) ListModels(context.Context) (openai.ModelsList, error) {
+	if m.err != nil {
+		return openai.ModelsList{}, m.err
+	}
+
+	return openai.ModelsList{Models: []openai.Model{{ID: openai.GPT4oMini}}}, nil
+}
+
 func TestNewClient(t *testing.T) {
-	c, err := New("", 0, "")
+	c, err := New("", 0, "", Config{}, zerolog.Nop())
 	assert.Nil(t, c)
 	assert.NotNil(t, err)
 
-	c, err = New("OPENAI_API_KEY", 0, "")
+	c, err = New("OPENAI_API_KEY", 0, "", Config{}, zerolog.Nop())
 	assert.NotNil(t, c)
 	assert.Nil(t, err)
 }
 
 func TestOpenAI_Execute(t *testing.T) {
-	c, _ := New("OPENAI_API_KEY", 0, "")
-	c.client = &MockOpenAI{}
+	c, _ := New("OPENAI_API_KEY", 0, "", Config{}, zerolog.Nop())
+	c.router = NewRouter(Backend{Name: DefaultBackend, Client: &MockOpenAI{}})
+
+	res, err := c.Generate(context.Background(), Key{UserID: "userID", ChatID: "chatID"}, Request{Text: "Ping"}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, res.Text, "Pong")
+	assert.False(t, res.Truncated)
+	assert.Equal(t, openai.GPT4oMini, res.Model)
+	assert.Equal(t, 1, c.Conversations())
+
+	c.Delete(Key{UserID: "userID", ChatID: "chatID"})
+	assert.Equal(t, 0, c.Conversations())
+}
+
+func TestOpenAI_Complete(t *testing.T) {
+	c, _ := New("OPENAI_API_KEY", 0, "", Config{}, zerolog.Nop())
+	c.router = NewRouter(Backend{Name: DefaultBackend, Client: &MockOpenAI{}})
+
+	res, err := c.Complete(context.Background(), "Ping")
+	assert.Nil(t, err)
+	assert.Equal(t, "Pong", res.Text)
+	assert.Empty(t, c.chatHistories)
+}
+
+func TestOpenAI_SharedHistory(t *testing.T) {
+	c, _ := New("OPENAI_API_KEY", 0, "", Config{}, zerolog.Nop())
+	c.router = NewRouter(Backend{Name: DefaultBackend, Client: &MockOpenAI{}})
+
+	_, err := c.Generate(context.Background(), Key{ChatID: "chatID"}, Request{Text: "Ping"}, nil)
+	assert.Nil(t, err)
+	_, err = c.Generate(context.Background(), Key{ChatID: "chatID"}, Request{Text: "Ping"}, nil)
+	assert.Nil(t, err)
+	_, err = c.Generate(context.Background(), Key{UserID: "userID", ChatID: "chatID"}, Request{Text: "Ping"}, nil)
+	assert.Nil(t, err)
+
+	assert.Len(t, c.chatHistories[Key{ChatID: "chatID"}.String()].nodes, 4)
+	assert.Len(t, c.chatHistories[Key{UserID: "userID", ChatID: "chatID"}.String()].nodes, 2)
+}
+
+func TestOpenAI_ThreadHistory(t *testing.T) {
+	c, _ := New("OPENAI_API_KEY", 0, "", Config{}, zerolog.Nop())
+	c.router = NewRouter(Backend{Name: DefaultBackend, Client: &MockOpenAI{}})
+
+	_, err := c.Generate(context.Background(), Key{UserID: "userID", ChatID: "chatID", ThreadID: "1"}, Request{Text: "Ping"}, nil)
+	assert.Nil(t, err)
+	_, err = c.Generate(context.Background(), Key{UserID: "userID", ChatID: "chatID", ThreadID: "2"}, Request{Text: "Ping"}, nil)
+	assert.Nil(t, err)
+
+	assert.Len(t, c.chatHistories["userID:chatID:1"].nodes, 2)
+	assert.Len(t, c.chatHistories["userID:chatID:2"].nodes, 2)
+	assert.NotContains(t, c.chatHistories, "userID:chatID")
+}
+
+func TestOpenAI_Branching(t *testing.T) {
+	m := &MockOpenAI{}
+	c, _ := New("OPENAI_API_KEY", 0, "", Config{}, zerolog.Nop())
+	c.router = NewRouter(Backend{Name: DefaultBackend, Client: m})
+
+	key := Key{UserID: "userID", ChatID: "chatID"}
+
+	_, err := c.Generate(context.Background(), key, Request{Text: "q1", MessageID: 1}, nil)
+	assert.Nil(t, err)
+	c.Bind(key, 1, 2)
+
+	_, err = c.Generate(context.Background(), key, Request{Text: "q2", MessageID: 3}, nil)
+	assert.Nil(t, err)
+	c.Bind(key, 3, 4)
+
+	// The reply to the first response does not see the second request
+	_, err = c.Generate(context.Background(), key, Request{Text: "q3", MessageID: 5, ReplyToID: 2}, nil)
+	assert.Nil(t, err)
+
+	messages := m.requests[2].Messages
+	assert.Len(t, messages, 4)
+	assert.Equal(t, "q1", messages[1].Content)
+	assert.Equal(t, "Pong", messages[2].Content)
+	assert.Equal(t, "q3", messages[3].Content)
+
+	// An unreplied request continues the most recent branch
+	_, err = c.Generate(context.Background(), key, Request{Text: "q4", MessageID: 7}, nil)
+	assert.Nil(t, err)
+
+	messages = m.requests[3].Messages
+	assert.Len(t, messages, 6)
+	assert.Equal(t, "q3", messages[3].Content)
+	assert.Equal(t, "q4", messages[5].Content)
+}
+
+func TestOpenAI_RegenerateAndContinue(t *testing.T) {
+	m := &MockOpenAI{finishReason: openai.FinishReasonLength}
+	c, _ := New("OPENAI_API_KEY", 0, "", Config{}, zerolog.Nop())
+	c.router = NewRouter(Backend{Name: DefaultBackend, Client: m})
+
+	key := Key{UserID: "userID", ChatID: "chatID"}
+	ctx := context.Background()
+
+	_, err := c.Regenerate(ctx, key, 2, nil)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	res, err := c.Generate(ctx, key, Request{Text: "q1", MessageID: 1}, nil)
+	assert.Nil(t, err)
+	assert.True(t, res.Truncated)
+	c.Bind(key, 1, 2)
+
+	_, err = c.Continue(ctx, key, 2, nil)
+	assert.Nil(t, err)
+	c.Bind(key, 2, 3)
+
+	messages := m.requests[1].Messages
+	assert.Len(t, messages, 4)
+	assert.Equal(t, "Pong", messages[2].Content)
+	assert.Equal(t, continuePrompt, messages[3].Content)
+
+	// The regenerated response takes over the message and becomes the head
+	_, err = c.Regenerate(ctx, key, 2, nil)
+	assert.Nil(t, err)
+
+	messages = m.requests[2].Messages
+	assert.Len(t, messages, 2)
+	assert.Equal(t, "q1", messages[1].Content)
+
+	h := c.chatHistories[key.String()]
+	assert.Len(t, h.nodes, 4)
+	assert.Equal(t, h.head, h.find(2))
+	assert.Equal(t, "q1", h.head.parent.message.Content)
+
+	// The continuation is still available in its own branch
+	_, err = c.Regenerate(ctx, key, 3, nil)
+	assert.Nil(t, err)
+
+	messages = m.requests[3].Messages
+	assert.Len(t, messages, 4)
+	assert.Equal(t, continuePrompt, messages[3].Content)
+}
+
+func TestOpenAI_Edit(t *testing.T) {
+	m := &MockOpenAI{}
+	c, _ := New("OPENAI_API_KEY", 0, "", Config{}, zerolog.Nop())
+	c.router = NewRouter(Backend{Name: DefaultBackend, Client: m})
+
+	key := Key{UserID: "userID", ChatID: "chatID"}
+	ctx := context.Background()
+
+	_, err := c.Edit(ctx, key, Request{Text: "q1", MessageID: 1}, nil)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	for id := 1; id < 6; id += 2 {
+		_, err = c.Generate(ctx, key, Request{Text: fmt.Sprintf("q%d", id), MessageID: id}, nil)
+		assert.Nil(t, err)
+		c.Bind(key, id, id+1)
+	}
+
+	assert.Equal(t, 4, c.ResponseID(key, 3))
+	assert.Equal(t, 0, c.ResponseID(key, 42))
+
+	_, err = c.Edit(ctx, key, Request{Text: "q3 edited", MessageID: 3}, nil)
+	assert.Nil(t, err)
+
+	messages := m.requests[3].Messages
+	assert.Len(t, messages, 4)
+	assert.Equal(t, "q3 edited", messages[3].Content)
+
+	h := c.chatHistories[key.String()]
+	assert.Len(t, h.nodes, 4)
+	assert.Equal(t, "q3 edited", h.find(3).message.Content)
+	assert.Equal(t, h.head, h.find(4))
+	assert.Nil(t, h.find(5))
+	assert.Equal(t, 4, c.ResponseID(key, 3))
+}
+
+func TestOpenAI_Tools(t *testing.T) {
+	m := &MockOpenAI{tool: "calculator"}
+	c, _ := New("OPENAI_API_KEY", 0, "", Config{}, zerolog.Nop())
+	c.router = NewRouter(Backend{Name: DefaultBackend, Client: m})
+	c.Tools().Register(Calculator{}, true)
+
+	key := Key{UserID: "userID", ChatID: "chatID"}
+	ctx := context.Background()
+
+	res, err := c.Generate(ctx, key, Request{Text: "2+2?", MessageID: 1}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "Pong", res.Text)
+	assert.Len(t, m.requests, 2)
+
+	// The tool call and its result are kept in the history
+	messages := m.requests[1].Messages
+	assert.Len(t, messages, 4)
+	assert.Equal(t, "calculator", messages[2].ToolCalls[0].Function.Name)
+	assert.Equal(t, openai.ChatMessageRoleTool, messages[3].Role)
+	assert.Equal(t, "4", messages[3].Content)
+	assert.Equal(t, "call", messages[3].ToolCallID)
+
+	_, err = c.Generate(ctx, key, Request{Text: "Ping", MessageID: 3}, nil)
+	assert.Nil(t, err)
+	assert.Len(t, m.requests[2].Messages, 6)
+
+	// Disabled tools are not passed to the model
+	assert.Nil(t, c.Tools().Enable("chatID", "calculator", false))
+
+	_, err = c.Generate(ctx, key, Request{Text: "Ping", MessageID: 5}, nil)
+	assert.Nil(t, err)
+	assert.Len(t, m.requests, 5)
+	assert.Empty(t, m.requests[4].Tools)
+}
+
+func TestOpenAI_ToolsLimit(t *testing.T) {
+	m := &MockOpenAI{tool: "calculator", loop: true}
+	c, _ := New("OPENAI_API_KEY", 0, "", Config{}, zerolog.Nop())
+	c.router = NewRouter(Backend{Name: DefaultBackend, Client: m})
+	c.Tools().Register(Calculator{}, true)
+
+	res, err := c.Complete(context.Background(), "2+2?")
+	assert.Nil(t, err)
+	assert.Equal(t, "Pong", res.Text)
+	assert.Equal(t, 3, res.Usage.TotalTokens)
+	assert.Len(t, m.requests, maxToolIterations+1)
+	assert.Empty(t, m.requests[maxToolIterations].Tools)
+}
+
+func TestOpenAI_Fallback(t *testing.T) {
+	primary := &MockOpenAI{err: errors.New("rate limit")}
+	secondary := &MockOpenAI{}
+
+	c, _ := New("OPENAI_API_KEY", 0, "", Config{}, zerolog.Nop())
+	c.router = NewRouter(
+		Backend{Name: "primary", Client: primary},
+		Backend{Name: "secondary", Client: secondary, Models: map[string]string{openai.GPT4oMini: "llama"}},
+	)
+
+	res, err := c.Complete(context.Background(), "Ping")
+	assert.Nil(t, err)
+	assert.Equal(t, "secondary", res.Backend)
+	assert.Equal(t, 3, res.Usage.TotalTokens)
+	assert.Equal(t, openai.GPT4oMini, primary.requests[0].Model)
+	assert.Equal(t, "llama", secondary.requests[0].Model)
+
+	secondary.err = errors.New("timeout")
+
+	_, err = c.Complete(context.Background(), "Ping")
+	assert.EqualError(t, err, "timeout")
+
+	// A canceled request is not retried
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = c.Complete(ctx, "Ping")
+	assert.NotNil(t, err)
+	assert.Len(t, primary.requests, 3)
+	assert.Len(t, secondary.requests, 2)
+}
+
+func TestMergeToolCalls(t *testing.T) {
+	index := func(i int) *int { return &i }
+
+	calls := mergeToolCalls(nil, []openai.ToolCall{{Index: index(0), ID: "1", Function: openai.FunctionCall{Name: "calculator", Arguments: `{"expr`}}})
+	calls = mergeToolCalls(calls, []openai.ToolCall{{Index: index(0), Function: openai.FunctionCall{Arguments: `ession":"1"}`}}})
+	calls = mergeToolCalls(calls, []openai.ToolCall{{Index: index(1), ID: "2", Function: openai.FunctionCall{Name: "datetime"}}})
+
+	assert.Len(t, calls, 2)
+	assert.Equal(t, "1", calls[0].ID)
+	assert.Equal(t, `{"expression":"1"}`, calls[0].Function.Arguments)
+	assert.Equal(t, "datetime", calls[1].Function.Name)
+}
+
+func TestOpenAI_Stream(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, delta := range []string{"Po", "ng"} {
+			fmt.Fprintf(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":%q}}]}\n\n", delta)
+		}
+		fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"length\"}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer ts.Close()
+
+	config := openai.DefaultConfig("OPENAI_API_KEY")
+	config.BaseURL = ts.URL
+
+	c, _ := New("OPENAI_API_KEY", 0, "", Config{}, zerolog.Nop())
+	c.router = NewRouter(Backend{Name: DefaultBackend, Client: openai.NewClientWithConfig(config)})
+
+	var progress []string
+	res, err := c.Generate(context.Background(), Key{UserID: "userID", ChatID: "chatID"}, Request{Text: "Ping"}, func(text string) {
+		progress = append(progress, text)
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "Pong", res.Text)
+	assert.True(t, res.Truncated)
+	assert.Equal(t, []string{"Po", "Pong"}, progress)
+}
+
+func TestOpenAI_StreamFailed(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Po\"}}]}\n\n")
+		fmt.Fprint(w, "data: {\"error\":{\"message\":\"overloaded\",\"type\":\"server_error\"}}\n\n")
+	}))
+	defer failing.Close()
+
+	var requests int
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Pong\"}}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer fallback.Close()
+
+	client := func(url string) OpenAIClient {
+		config := openai.DefaultConfig("OPENAI_API_KEY")
+		config.BaseURL = url
+
+		return openai.NewClientWithConfig(config)
+	}
+
+	c, _ := New("OPENAI_API_KEY", 0, "", Config{}, zerolog.Nop())
+	c.router = NewRouter(Backend{Name: DefaultBackend, Client: client(failing.URL)}, Backend{Name: "fallback", Client: client(fallback.URL)})
+
+	// The shown part of the response is not followed by the response of another backend
+	var progress []string
+	_, err := c.Generate(context.Background(), Key{UserID: "userID", ChatID: "chatID"}, Request{Text: "Ping"}, func(text string) {
+		progress = append(progress, text)
+	})
+	assert.ErrorContains(t, err, "overloaded")
+	assert.Equal(t, []string{"Po"}, progress)
+	assert.Zero(t, requests)
+
+	// A backend which fails before the response starts is replaced
+	c.router = NewRouter(Backend{Name: DefaultBackend, Client: &MockOpenAI{}}, Backend{Name: "fallback", Client: client(fallback.URL)})
+
+	res, err := c.Generate(context.Background(), Key{UserID: "userID", ChatID: "chatID"}, Request{Text: "Ping"}, func(string) {})
+	assert.Nil(t, err)
+	assert.Equal(t, "Pong", res.Text)
+	assert.Equal(t, "fallback", res.Backend)
+}
+
+func TestOpenAI_StreamWithoutOptions(t *testing.T) {
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+
+		body, _ := io.ReadAll(r.Body)
+		if bytes.Contains(body, []byte("stream_options")) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":{"message":"Unrecognized request argument supplied: stream_options","type":"invalid_request_error"}}`)
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Pong\"}}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer ts.Close()
+
+	config := openai.DefaultConfig("OPENAI_API_KEY")
+	config.BaseURL = ts.URL
+
+	c, _ := New("OPENAI_API_KEY", 0, "", Config{}, zerolog.Nop())
+	c.router = NewRouter(Backend{Name: DefaultBackend, Client: openai.NewClientWithConfig(config)})
+
+	res, err := c.Generate(context.Background(), Key{UserID: "userID", ChatID: "chatID"}, Request{Text: "Ping"}, func(string) {})
+	assert.Nil(t, err)
+	assert.Equal(t, "Pong", res.Text)
+	assert.Equal(t, 2, requests)
+}
+
+func TestOpenAI_Redact(t *testing.T) {
+	m := &MockOpenAI{echo: true}
+	c, _ := New("OPENAI_API_KEY", 0, "", Config{}, zerolog.Nop())
+	c.router = NewRouter(Backend{Name: DefaultBackend, Client: m})
+	c.SetRedactor(redact.New(redact.DefaultPatterns()))
+
+	key := Key{UserID: "userID", ChatID: "chatID"}
+
+	res, err := c.Generate(context.Background(), key, Request{Text: "Mail a@example.com"}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "Mail a@example.com", res.Text)
+	assert.Equal(t, "Mail [EMAIL_1]", m.requests[0].Messages[1].Content)
+
+	res, err = c.Generate(context.Background(), key, Request{Text: "Call +1 555 123 4567 or a@example.com"}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "Call +1 555 123 4567 or a@example.com", res.Text)
+
+	for _, msg := range m.requests[1].Messages {
+		assert.NotContains(t, msg.Content, "example.com")
+	}
+	assert.Equal(t, "Call [PHONE_1] or [EMAIL_1]", m.requests[1].Messages[3].Content)
+
+	// Values are not shared between conversations
+	_, err = c.Generate(context.Background(), Key{UserID: "other", ChatID: "chatID"}, Request{Text: "Mail b@example.com"}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "Mail [EMAIL_1]", m.requests[2].Messages[1].Content)
+
+	res, err = c.Complete(context.Background(), "Mail c@example.com")
+	assert.Nil(t, err)
+	assert.Equal(t, "Mail c@example.com", res.Text)
+	assert.Equal(t, "Mail [EMAIL_1]", m.requests[3].Messages[1].Content)
+}
+
+// recorder is a tool which records its arguments and returns the result.
+type recorder struct {
+	result    string
+	arguments *[]string
+}
+
+func (recorder) Name() string            { return "recorder" }
+func (recorder) Description() string     { return "" }
+func (recorder) Schema() json.RawMessage { return json.RawMessage(`{"type":"object"}`) }
+func (r recorder) Call(_ context.Context, arguments string) (string, error) {
+	*r.arguments = append(*r.arguments, arguments)
+	return r.result, nil
+}
+
+func TestOpenAI_RedactTools(t *testing.T) {
+	m := &MockOpenAI{tool: "recorder", arguments: `{"fact":"Mail [EMAIL_1]"}`}
+	c, _ := New("OPENAI_API_KEY", 0, "", Config{}, zerolog.Nop())
+	c.router = NewRouter(Backend{Name: DefaultBackend, Client: m})
+	c.SetRedactor(redact.New(redact.DefaultPatterns()))
+
+	var arguments []string
+	c.Tools().Register(recorder{result: "Saved a@example.com and b@example.com", arguments: &arguments}, true)
+
+	_, err := c.Generate(context.Background(), Key{UserID: "userID", ChatID: "chatID"}, Request{Text: "Remember a@example.com"}, nil)
+	assert.Nil(t, err)
+
+	// The tool gets the values of the conversation, the model keeps seeing the placeholders
+	assert.Equal(t, []string{`{"fact":"Mail a@example.com"}`}, arguments)
+	assert.Equal(t, `{"fact":"Mail [EMAIL_1]"}`, m.requests[1].Messages[2].ToolCalls[0].Function.Arguments)
+
+	// The results of the tools are redacted with the same mapping
+	assert.Equal(t, "Saved [EMAIL_1] and [EMAIL_2]", m.requests[1].Messages[3].Content)
+}
+
+func TestOpenAI_Ping(t *testing.T) {
+	c, _ := New("OPENAI_API_KEY", 0, "", Config{}, zerolog.Nop())
+	c.router = NewRouter(Backend{Name: DefaultBackend, Client: &MockOpenAI{err: errors.New("unauthorized")}})
+	assert.ErrorContains(t, c.Ping(context.Background()), "backend default: unauthorized")
+
+	c.router.Add(Backend{Name: "secondary", Client: &MockOpenAI{}})
+	assert.Nil(t, c.Ping(context.Background()))
+}
+
+func TestOpenAI_Log(t *testing.T) {
+	var client, request bytes.Buffer
+
+	c, _ := New("OPENAI_API_KEY", 0, "", Config{}, zerolog.New(&client))
+	c.router = NewRouter(
+		Backend{Name: DefaultBackend, Client: &MockOpenAI{err: errors.New("rate limit")}},
+		Backend{Name: "secondary", Client: &MockOpenAI{}},
+	)
+
+	_, err := c.Complete(context.Background(), "Ping")
+	assert.Nil(t, err)
+	assert.Contains(t, client.String(), `"backend":"default"`)
+	assert.NotContains(t, client.String(), "Ping")
+
+	client.Reset()
+	logger := zerolog.New(&request).With().Str("cid", "abc").Logger()
+
+	_, err = c.Complete(logger.WithContext(context.Background()), "Ping")
+	assert.Nil(t, err)
+	assert.Empty(t, client.String())
+	assert.Contains(t, request.String(), `"cid":"abc"`)
+}
+
+func TestKey_String(t *testing.T) {
+	assert.Equal(t, "userID:chatID", Key{UserID: "userID", ChatID: "chatID"}.String())
+	assert.Equal(t, ":chatID:1", Key{ChatID: "chatID", ThreadID: "1"}.String())
+	assert.Equal(t, "userID:chatID:1#2", Key{UserID: "userID", ChatID: "chatID", ThreadID: "1", Conversation: "2"}.String())
+}
+
+func TestOpenAI_Export(t *testing.T) {
+	c, _ := New("OPENAI_API_KEY", 0, "", Config{}, zerolog.Nop())
+	c.router = NewRouter(Backend{Name: DefaultBackend, Client: &MockOpenAI{echo: true}})
+	c.SetRedactor(redact.New(redact.DefaultPatterns()))
+
+	key := Key{UserID: "userID", ChatID: "chatID"}
+
+	_, err := c.Export(key)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	for _, text := range []string{"Mail a@example.com", "Ping"} {
+		_, err = c.Generate(context.Background(), key, Request{Text: text}, nil)
+		assert.Nil(t, err)
+	}
+
+	messages, err := c.Export(key)
+	assert.Nil(t, err)
+	assert.Len(t, messages, 4)
+	assert.Equal(t, "user", messages[0].Role)
+	assert.Equal(t, "Mail a@example.com", messages[0].Content)
+	assert.Equal(t, "assistant", messages[1].Role)
+	assert.Equal(t, "Mail a@example.com", messages[1].Content)
+	assert.Equal(t, openai.GPT4oMini, messages[1].Model)
+	assert.False(t, messages[1].Time.IsZero())
+	assert.Equal(t, "Ping", messages[3].Content)
+}
+
+func TestOpenAI_Import(t *testing.T) {
+	c, _ := New("OPENAI_API_KEY", 0, "", Config{}, zerolog.Nop())
+	c.router = NewRouter(Backend{Name: DefaultBackend, Client: &MockOpenAI{echo: true}})
+	c.SetRedactor(redact.New(redact.DefaultPatterns()))
+
+	key := Key{UserID: "userID", ChatID: "chatID", Conversation: "imported"}
+
+	c.Import(key, []transcript.Message{
+		{Role: transcript.RoleUser, Content: "Mail a@example.com"},
+		{Role: transcript.RoleAssistant, Content: "Done", Model: openai.GPT4o},
+	})
+	assert.Equal(t, 1, c.Conversations())
+	assert.NotContains(t, c.chatHistories[key.String()].nodes[0].message.Content, "a@example.com")
+
+	_, err := c.Generate(context.Background(), key, Request{Text: "Ping"}, nil)
+	assert.Nil(t, err)
+
+	messages, err := c.Export(key)
+	assert.Nil(t, err)
+	assert.Len(t, messages, 4)
+	assert.Equal(t, "Mail a@example.com", messages[0].Content)
+	assert.False(t, messages[0].Time.IsZero())
+	assert.Equal(t, openai.GPT4o, messages[1].Model)
+	assert.Equal(t, "Ping", messages[2].Content)
+}
+
+func TestOpenAI_RunPrompt(t *testing.T) {
+	mock := &MockOpenAI{echo: true}
+	c, _ := New("OPENAI_API_KEY", 0, "", Config{}, zerolog.Nop())
+	c.router = NewRouter(Backend{Name: DefaultBackend, Client: mock})
+
+	res, err := c.RunPrompt(context.Background(), "chatID", Prompt{Text: "Summarize", Persona: "You are a scrum master", Context: "Fixed bugs"})
+	assert.Nil(t, err)
+	assert.Equal(t, "Context:\nFixed bugs\n\nSummarize", res.Text)
+
+	messages := mock.requests[0].Messages
+	assert.Len(t, messages, 3)
+	assert.Equal(t, "You are a scrum master", messages[1].Content)
+	assert.Equal(t, 0, c.Conversations())
+
+	_, err = c.RunPrompt(context.Background(), "chatID", Prompt{Text: "Summarize"})
+	assert.Nil(t, err)
+	assert.Len(t, mock.requests[1].Messages, 2)
+}
+
+func TestOpenAI_Evicted(t *testing.T) {
+	mock := &MockOpenAI{}
+	c, _ := New("OPENAI_API_KEY", 0, "", Config{}, zerolog.Nop())
+	c.router = NewRouter(Backend{Name: DefaultBackend, Client: mock})
+
+	key := Key{UserID: "userID", ChatID: "chatID"}
+	ctx := context.Background()
+
+	// The answer is not attached to the conversation evicted during the request
+	prepare := func() {
+		mock.during = nil
+		c.Delete(key)
+
+		_, err := c.Generate(ctx, key, Request{Text: "Ping", MessageID: 1}, nil)
+		assert.Nil(t, err)
+		c.Bind(key, 1, 2)
+
+		mock.during = func() { c.Delete(key) }
+	}
+
+	prepare()
+	_, err := c.Generate(ctx, key, Request{Text: "Ping", MessageID: 3}, nil)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, 0, c.Conversations())
+
+	prepare()
+	_, err = c.Regenerate(ctx, key, 2, nil)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	prepare()
+	_, err = c.Continue(ctx, key, 2, nil)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	prepare()
+	_, err = c.Edit(ctx, key, Request{Text: "Pong", MessageID: 1}, nil)
+	assert.ErrorIs(t, err, ErrNotFound)
 
-	res, err := c.Generate("userID", "chatID", "Ping")
+	// A new conversation is kept
+	mock.during = func() { c.Delete(key) }
+	_, err = c.Generate(ctx, key, Request{Text: "Ping", MessageID: 1}, nil)
 	assert.Nil(t, err)
-	assert.Equal(t, res, "Pong")
+	assert.Equal(t, 1, c.Conversations())
 }
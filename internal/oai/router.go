@@ -0,0 +1,177 @@
+package oai
+
+import (
+	"fmt"
+	"sync"
+
+	"golang.org/x/exp/slices"
+)
+
+// Backend is a named API endpoint.
+type Backend struct {
+	Name   string
+	Client OpenAIClient
+	Models map[string]string // model of the bot -> model of the backend
+}
+
+// model returns the name of the model in the backend.
+func (b Backend) model(model string) string {
+	if m, ok := b.Models[model]; ok {
+		return m
+	}
+
+	return model
+}
+
+// Router chooses the backend for a request and the ones to fall back to if it fails.
+type Router struct {
+	mu sync.RWMutex
+
+	backends map[string]Backend
+	chain    []string          // names of the backends in order of fallback
+	routes   map[string]string // model -> backend name
+	chats    map[string]string // chat ID -> backend name
+}
+
+// NewRouter makes a router with the fallback chain of the backends.
+func NewRouter(backends ...Backend) *Router {
+	r := &Router{
+		backends: make(map[string]Backend),
+		routes:   make(map[string]string),
+		chats:    make(map[string]string),
+	}
+
+	for _, b := range backends {
+		r.Add(b)
+	}
+
+	return r
+}
+
+// Add adds the backend to the end of the fallback chain.
+func (r *Router) Add(b Backend) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.backends[b.Name]; !ok {
+		r.chain = append(r.chain, b.Name)
+	}
+
+	r.backends[b.Name] = b
+}
+
+// Wrap replaces the clients of the backends with the ones returned by wrap,
+// e.g. to instrument them.
+func (r *Router) Wrap(wrap func(b Backend) OpenAIClient) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for name, b := range r.backends {
+		b.Client = wrap(b)
+		r.backends[name] = b
+	}
+}
+
+// Names returns the names of the backends in order of the fallback chain
+// followed by the ones out of the chain.
+func (r *Router) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := slices.Clone(r.chain)
+
+	var rest []string
+	for name := range r.backends {
+		if !slices.Contains(names, name) {
+			rest = append(rest, name)
+		}
+	}
+
+	slices.Sort(rest)
+
+	return append(names, rest...)
+}
+
+// SetChain sets the fallback chain. The backends out of the chain are used only if chosen explicitly.
+func (r *Router) SetChain(names ...string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, name := range names {
+		if _, ok := r.backends[name]; !ok {
+			return fmt.Errorf("unknown backend %s", name)
+		}
+	}
+
+	r.chain = slices.Clone(names)
+
+	return nil
+}
+
+// Route sends requests for the model to the backend first.
+func (r *Router) Route(model, name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.backends[name]; !ok {
+		return fmt.Errorf("unknown backend %s", name)
+	}
+
+	r.routes[model] = name
+
+	return nil
+}
+
+// Prefer sends requests from the chat to the backend first. An empty name resets the choice.
+func (r *Router) Prefer(chatID, name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if name == "" {
+		delete(r.chats, chatID)
+		return nil
+	}
+
+	if _, ok := r.backends[name]; !ok {
+		return fmt.Errorf("unknown backend %s", name)
+	}
+
+	r.chats[chatID] = name
+
+	return nil
+}
+
+// Preferred returns the backend chosen for the chat or an empty string.
+func (r *Router) Preferred(chatID string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.chats[chatID]
+}
+
+// order returns the backends to try for the request from the chat to the model:
+// the one chosen for the chat or the model, then the fallback chain.
+func (r *Router) order(chatID, model string) []Backend {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var names []string
+	if name, ok := r.chats[chatID]; ok {
+		names = append(names, name)
+	}
+
+	if name, ok := r.routes[model]; ok {
+		names = append(names, name)
+	}
+
+	names = append(names, r.chain...)
+
+	backends := make([]Backend, 0, len(names))
+	for _, name := range names {
+		if !slices.ContainsFunc(backends, func(b Backend) bool { return b.Name == name }) {
+			backends = append(backends, r.backends[name])
+		}
+	}
+
+	return backends
+}
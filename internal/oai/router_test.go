@@ -0,0 +1,63 @@
+package oai
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouter_Order(t *testing.T) {
+	r := NewRouter(Backend{Name: "a"}, Backend{Name: "b"}, Backend{Name: "c"})
+
+	names := func(backends []Backend) []string {
+		var names []string
+		for _, b := range backends {
+			names = append(names, b.Name)
+		}
+
+		return names
+	}
+
+	assert.Equal(t, []string{"a", "b", "c"}, r.Names())
+	assert.Equal(t, []string{"a", "b", "c"}, names(r.order("chatID", "model")))
+
+	assert.Nil(t, r.Route("model", "b"))
+	assert.Equal(t, []string{"b", "a", "c"}, names(r.order("chatID", "model")))
+	assert.Equal(t, []string{"a", "b", "c"}, names(r.order("chatID", "other")))
+
+	assert.Nil(t, r.Prefer("chatID", "c"))
+	assert.Equal(t, "c", r.Preferred("chatID"))
+	assert.Equal(t, []string{"c", "b", "a"}, names(r.order("chatID", "model")))
+
+	assert.Nil(t, r.Prefer("chatID", ""))
+	assert.Equal(t, "", r.Preferred("chatID"))
+
+	assert.Nil(t, r.SetChain("c", "a"))
+	assert.Equal(t, []string{"c", "a", "b"}, r.Names())
+	assert.Equal(t, []string{"c", "a"}, names(r.order("chatID", "other")))
+	assert.Equal(t, []string{"b", "c", "a"}, names(r.order("chatID", "model")))
+
+	assert.NotNil(t, r.SetChain("d"))
+	assert.NotNil(t, r.Route("model", "d"))
+	assert.NotNil(t, r.Prefer("chatID", "d"))
+}
+
+func TestBackend_Model(t *testing.T) {
+	b := Backend{Models: map[string]string{"gpt-4o-mini": "llama3.1"}}
+	assert.Equal(t, "llama3.1", b.model("gpt-4o-mini"))
+	assert.Equal(t, "gpt-4o", b.model("gpt-4o"))
+}
+
+func TestRouter_Wrap(t *testing.T) {
+	m := &MockOpenAI{}
+	r := NewRouter(Backend{Name: "a"}, Backend{Name: "b"})
+
+	r.Wrap(func(b Backend) OpenAIClient {
+		assert.Nil(t, b.Client)
+		return m
+	})
+
+	for _, b := range r.order("chatID", "model") {
+		assert.Equal(t, m, b.Client)
+	}
+}
@@ -0,0 +1,138 @@
+package oai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// maxToolIterations limits the rounds of tool calls for a single response.
+const maxToolIterations = 5
+
+// Tool is a function which the model can call.
+type Tool interface {
+	Name() string
+	Description() string
+	Schema() json.RawMessage // JSON schema of the arguments
+	Call(ctx context.Context, arguments string) (string, error)
+}
+
+// Tools is a registry of tools with their enablement per chat.
+type Tools struct {
+	mu sync.RWMutex
+
+	tools    []Tool
+	defaults map[string]bool            // tool name -> enabled by default
+	chats    map[string]map[string]bool // chat ID -> tool name -> enabled
+}
+
+// NewTools makes an empty registry.
+func NewTools() *Tools {
+	return &Tools{defaults: make(map[string]bool), chats: make(map[string]map[string]bool)}
+}
+
+// Register adds the tool to the registry. The tool is enabled in all chats by default if enabled is true.
+func (t *Tools) Register(tool Tool, enabled bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.tools = append(t.tools, tool)
+	t.defaults[tool.Name()] = enabled
+}
+
+// Names returns the names of the registered tools.
+func (t *Tools) Names() []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	names := make([]string, 0, len(t.tools))
+	for _, tool := range t.tools {
+		names = append(names, tool.Name())
+	}
+
+	return names
+}
+
+// Enable enables or disables the tool in the chat.
+func (t *Tools) Enable(chatID, name string, enabled bool) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.defaults[name]; !ok {
+		return fmt.Errorf("unknown tool %s", name)
+	}
+
+	if t.chats[chatID] == nil {
+		t.chats[chatID] = make(map[string]bool)
+	}
+
+	t.chats[chatID][name] = enabled
+
+	return nil
+}
+
+// Enabled reports whether the tool is enabled in the chat.
+func (t *Tools) Enabled(chatID, name string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.enabled(chatID, name)
+}
+
+func (t *Tools) enabled(chatID, name string) bool {
+	if enabled, ok := t.chats[chatID][name]; ok {
+		return enabled
+	}
+
+	return t.defaults[name]
+}
+
+// definitions returns the definitions of the tools enabled in the chat.
+func (t *Tools) definitions(chatID string) []openai.Tool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var definitions []openai.Tool
+	for _, tool := range t.tools {
+		if !t.enabled(chatID, tool.Name()) {
+			continue
+		}
+
+		definitions = append(definitions, openai.Tool{
+			Type: openai.ToolTypeFunction,
+			Function: &openai.FunctionDefinition{
+				Name:        tool.Name(),
+				Description: tool.Description(),
+				Parameters:  tool.Schema(),
+			},
+		})
+	}
+
+	return definitions
+}
+
+// call executes the tool call and returns its result for the model.
+func (t *Tools) call(ctx context.Context, chatID string, call openai.ToolCall) string {
+	t.mu.RLock()
+	var tool Tool
+	for _, tt := range t.tools {
+		if tt.Name() == call.Function.Name && t.enabled(chatID, tt.Name()) {
+			tool = tt
+		}
+	}
+	t.mu.RUnlock()
+
+	if tool == nil {
+		return fmt.Sprintf("error: unknown tool %s", call.Function.Name)
+	}
+
+	res, err := tool.Call(ctx, call.Function.Arguments)
+	if err != nil {
+		return fmt.Sprintf("error: %v", err)
+	}
+
+	return res
+}
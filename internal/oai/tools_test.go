@@ -0,0 +1,47 @@
+package oai
+
+import (
+	"context"
+	"testing"
+
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTools_Enable(t *testing.T) {
+	tools := NewTools()
+	tools.Register(Calculator{}, true)
+	tools.Register(DateTime{}, false)
+
+	assert.Equal(t, []string{"calculator", "datetime"}, tools.Names())
+	assert.True(t, tools.Enabled("chatID", "calculator"))
+	assert.False(t, tools.Enabled("chatID", "datetime"))
+
+	assert.Nil(t, tools.Enable("chatID", "datetime", true))
+	assert.Nil(t, tools.Enable("chatID", "calculator", false))
+	assert.NotNil(t, tools.Enable("chatID", "unknown", true))
+
+	definitions := tools.definitions("chatID")
+	assert.Len(t, definitions, 1)
+	assert.Equal(t, "datetime", definitions[0].Function.Name)
+
+	definitions = tools.definitions("otherChatID")
+	assert.Len(t, definitions, 1)
+	assert.Equal(t, "calculator", definitions[0].Function.Name)
+}
+
+func TestTools_Call(t *testing.T) {
+	tools := NewTools()
+	tools.Register(Calculator{}, true)
+
+	call := func(name, arguments string) openai.ToolCall {
+		return openai.ToolCall{Function: openai.FunctionCall{Name: name, Arguments: arguments}}
+	}
+
+	assert.Equal(t, "6", tools.call(context.Background(), "chatID", call("calculator", `{"expression":"2*3"}`)))
+	assert.Equal(t, "error: division by zero", tools.call(context.Background(), "chatID", call("calculator", `{"expression":"1/0"}`)))
+	assert.Equal(t, "error: unknown tool datetime", tools.call(context.Background(), "chatID", call("datetime", "")))
+
+	assert.Nil(t, tools.Enable("chatID", "calculator", false))
+	assert.Equal(t, "error: unknown tool calculator", tools.call(context.Background(), "chatID", call("calculator", `{"expression":"2*3"}`)))
+}
@@ -0,0 +1,194 @@
+package redact
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// placeholderRe matches the placeholders of the redacted values.
+var placeholderRe = regexp.MustCompile(`\[([A-Z][A-Z0-9_]*)_(\d+)\]`)
+
+// nameRe matches the valid names of patterns.
+var nameRe = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+// Pattern detects the values of a kind.
+type Pattern struct {
+	Name   string // kind of the values, e.g. email, used in the placeholders
+	Regexp *regexp.Regexp
+	Valid  func(string) bool // optional check of the matches
+}
+
+// DefaultPatterns detect API keys, emails, card numbers and phone numbers in this order.
+func DefaultPatterns() []Pattern {
+	return []Pattern{
+		{Name: "api_key", Regexp: regexp.MustCompile(`\b(?:sk-[A-Za-z0-9_\-]{20,}|AKIA[0-9A-Z]{16}|gh[pousr]_[A-Za-z0-9]{36,}|xox[baprs]-[A-Za-z0-9\-]{10,}|AIza[0-9A-Za-z_\-]{35})`)},
+		{Name: "email", Regexp: regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9\-]+(?:\.[A-Za-z0-9\-]+)*\.[A-Za-z]{2,}`)},
+		{Name: "card", Regexp: regexp.MustCompile(`\b\d(?:[ \-]?\d){12,18}\b`), Valid: luhn},
+		{Name: "phone", Regexp: regexp.MustCompile(`(?:\+\d{1,3}[\s.\-]?(?:\(\d{3}\)|\d{3})|\(\d{3}\)|\b\d{3})[\s.\-]?\d{3}[\s.\-]?\d{2}[\s.\-]?\d{2}\b`)},
+	}
+}
+
+// Patterns returns the default patterns with the custom ones, name to regexp.
+// A custom pattern replaces the default one with the same name, an empty regexp disables it.
+func Patterns(custom map[string]string) ([]Pattern, error) {
+	var patterns []Pattern
+	for _, p := range DefaultPatterns() {
+		if _, ok := custom[p.Name]; !ok {
+			patterns = append(patterns, p)
+		}
+	}
+
+	names := make([]string, 0, len(custom))
+	for name := range custom {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if custom[name] == "" {
+			continue
+		}
+
+		if !nameRe.MatchString(name) {
+			return nil, fmt.Errorf("invalid pattern name %s", name)
+		}
+
+		re, err := regexp.Compile(custom[name])
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %s: %w", name, err)
+		}
+
+		patterns = append(patterns, Pattern{Name: name, Regexp: re})
+	}
+
+	return patterns, nil
+}
+
+// Redactor replaces sensitive values in texts with placeholders.
+type Redactor struct {
+	patterns []Pattern
+}
+
+// New makes a redactor with the patterns applied in order.
+func New(patterns []Pattern) *Redactor {
+	return &Redactor{patterns: patterns}
+}
+
+// Mapping keeps the values redacted in a conversation, so that
+// the same value always gets the same placeholder and can be restored.
+// It must not be logged.
+type Mapping struct {
+	mu           sync.Mutex
+	placeholders map[string]string // value to placeholder
+	values       map[string]string // placeholder to value
+	counts       map[string]int    // values per kind
+}
+
+// NewMapping makes an empty mapping.
+func NewMapping() *Mapping {
+	return &Mapping{
+		placeholders: make(map[string]string),
+		values:       make(map[string]string),
+		counts:       make(map[string]int),
+	}
+}
+
+// String hides the values if the mapping gets into a log by mistake.
+func (m *Mapping) String() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return fmt.Sprintf("%d redacted values", len(m.values))
+}
+
+// Redact replaces the sensitive values in the text with the placeholders of the mapping.
+func (r *Redactor) Redact(m *Mapping, text string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, p := range r.patterns {
+		text = p.Regexp.ReplaceAllStringFunc(text, func(value string) string {
+			if p.Valid != nil && !p.Valid(value) {
+				return value
+			}
+
+			return m.placeholder(p.Name, value)
+		})
+	}
+
+	return text
+}
+
+// placeholder returns the placeholder of the value, adding it to the mapping if needed.
+func (m *Mapping) placeholder(kind, value string) string {
+	if placeholder, ok := m.placeholders[value]; ok {
+		return placeholder
+	}
+
+	m.counts[kind]++
+	placeholder := fmt.Sprintf("[%s_%d]", strings.ToUpper(kind), m.counts[kind])
+	m.placeholders[value] = placeholder
+	m.values[placeholder] = value
+
+	return placeholder
+}
+
+// Restore replaces the placeholders in the text with the original values.
+// Unknown placeholders are kept.
+func (m *Mapping) Restore(text string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return placeholderRe.ReplaceAllStringFunc(text, func(placeholder string) string {
+		if value, ok := m.values[placeholder]; ok {
+			return value
+		}
+
+		return placeholder
+	})
+}
+
+// RestoreJSON replaces the placeholders in the strings of the JSON document with the original values,
+// escaped to keep the document valid. Unknown placeholders are kept.
+func (m *Mapping) RestoreJSON(doc string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return placeholderRe.ReplaceAllStringFunc(doc, func(placeholder string) string {
+		value, ok := m.values[placeholder]
+		if !ok {
+			return placeholder
+		}
+
+		quoted, _ := json.Marshal(value)
+
+		return string(quoted[1 : len(quoted)-1])
+	})
+}
+
+// luhn reports whether the digits of the number pass the Luhn check.
+func luhn(number string) bool {
+	var sum, n int
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+
+		d := int(c - '0')
+		if n%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+
+		sum += d
+		n++
+	}
+
+	return n > 0 && sum%10 == 0
+}
@@ -0,0 +1,61 @@
+package redact
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedactor_Redact(t *testing.T) {
+	r := New(DefaultPatterns())
+
+	tbl := []struct {
+		text, redacted string
+	}{
+		{"write to john.doe@example.com", "write to [EMAIL_1]"},
+		{"call +1 555 123 4567 now", "call [PHONE_1] now"},
+		{"call (555) 123-45-67", "call [PHONE_1]"},
+		{"call +15551234567", "call [PHONE_1]"},
+		{"card 4111 1111 1111 1111", "card [CARD_1]"},
+		{"not a card 4111 1111 1111 1112", "not a card 4111 1111 1111 1112"},
+		{"key sk-abcdefghijklmnopqrstuvwxyz123456", "key [API_KEY_1]"},
+		{"on 2024-01-01 at 10:30", "on 2024-01-01 at 10:30"},
+	}
+
+	for _, tt := range tbl {
+		t.Run(tt.text, func(t *testing.T) {
+			m := NewMapping()
+			redacted := r.Redact(m, tt.text)
+			assert.Equal(t, tt.redacted, redacted)
+			assert.Equal(t, tt.text, m.Restore(redacted))
+		})
+	}
+}
+
+func TestMapping(t *testing.T) {
+	r := New(DefaultPatterns())
+	m := NewMapping()
+
+	assert.Equal(t, "[EMAIL_1] and [EMAIL_2]", r.Redact(m, "a@example.com and b@example.com"))
+	assert.Equal(t, "again [EMAIL_2]", r.Redact(m, "again b@example.com"))
+	assert.Equal(t, "b@example.com wrote to a@example.com, [EMAIL_3]", m.Restore("[EMAIL_2] wrote to [EMAIL_1], [EMAIL_3]"))
+	assert.Equal(t, `{"to":"b@example.com","cc":"[EMAIL_3]"}`, m.RestoreJSON(`{"to":"[EMAIL_2]","cc":"[EMAIL_3]"}`))
+	assert.Equal(t, "2 redacted values", m.String())
+	assert.NotContains(t, m.String(), "example.com")
+}
+
+func TestPatterns(t *testing.T) {
+	patterns, err := Patterns(map[string]string{"phone": "", "passport": `\b\d{2} \d{2} \d{6}\b`})
+	require.NoError(t, err)
+
+	r := New(patterns)
+	m := NewMapping()
+	assert.Equal(t, "[PASSPORT_1], +1 555 123 4567", r.Redact(m, "45 01 123456, +1 555 123 4567"))
+
+	_, err = Patterns(map[string]string{"bad": "("})
+	assert.Error(t, err)
+
+	_, err = Patterns(map[string]string{"bad-name": "x"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,52 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCron(t *testing.T) {
+	for _, spec := range []string{"* * * * *", "0 9 * * 1-5", "*/15 8-18 * * *", "0 0 1,15 * *", "@daily", "@WEEKLY", "5/10 * * * 7"} {
+		_, err := ParseCron(spec)
+		assert.NoError(t, err, spec)
+	}
+
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "5-1 * * * *", "*/0 * * * *", "a * * * *", "@never"} {
+		_, err := ParseCron(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestCron_Next(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+
+	// Friday
+	now := time.Date(2024, 3, 29, 10, 30, 15, 0, berlin)
+
+	for _, tt := range []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2024, 3, 29, 10, 31, 0, 0, berlin)},
+		{"0 9 * * 1-5", time.Date(2024, 4, 1, 9, 0, 0, 0, berlin)},
+		{"*/20 * * * *", time.Date(2024, 3, 29, 10, 40, 0, 0, berlin)},
+		{"@daily", time.Date(2024, 3, 30, 0, 0, 0, 0, berlin)},
+		{"0 17 * * 5", time.Date(2024, 3, 29, 17, 0, 0, 0, berlin)},
+		{"0 0 1 * *", time.Date(2024, 4, 1, 0, 0, 0, 0, berlin)},
+		{"0 0 13 * 5", time.Date(2024, 4, 5, 0, 0, 0, 0, berlin)}, // day of month or week
+		{"30 2 31 3 *", time.Date(2025, 3, 31, 2, 30, 0, 0, berlin)},
+		{"0 0 30 2 *", time.Time{}},
+	} {
+		c, err := ParseCron(tt.spec)
+		require.NoError(t, err, tt.spec)
+		assert.True(t, tt.want.Equal(c.Next(now)), "%s: %s", tt.spec, c.Next(now))
+	}
+
+	// 02:30 does not exist on the day of the switch to the summer time
+	c, _ := ParseCron("30 2 * * *")
+	assert.Equal(t, time.Date(2024, 4, 1, 2, 30, 0, 0, berlin), c.Next(time.Date(2024, 3, 31, 0, 0, 0, 0, berlin)))
+}
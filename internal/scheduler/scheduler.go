@@ -0,0 +1,483 @@
+// Package scheduler runs the jobs of the chats at their due time. The jobs are kept
+// in the store, so that they survive restarts, and the ones missed during downtime run once.
+package scheduler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ivanglie/chatgpt-bot/internal/store"
+	"github.com/rs/zerolog"
+)
+
+// Buckets of the store.
+const (
+	jobsBucket      = "jobs"
+	runsBucket      = "runs"
+	timezonesBucket = "timezones"
+)
+
+const (
+	maxAttempts = 3           // runs of a failing job which runs once
+	maxRuns     = 20          // kept runs of a job
+	maxCatchUp  = 10          // missed runs of a recurring job run by CatchUpAll
+	missedAfter = time.Minute // delay after which a run counts as missed during downtime
+)
+
+// Policies for the runs of the recurring jobs missed during downtime.
+const (
+	CatchUpSkip = "skip" // the missed runs are skipped
+	CatchUpOnce = "once" // the missed runs are replaced by a single one
+	CatchUpAll  = "all"  // every missed run is run, up to ten of them
+)
+
+// ErrNotFound is returned for an unknown job.
+var ErrNotFound = errors.New("job not found")
+
+// Job is a task for a chat due at a time.
+type Job struct {
+	ID       string    `json:"id"`
+	Kind     string    `json:"kind"` // selects the handler
+	ChatID   int64     `json:"chat_id"`
+	ThreadID int       `json:"thread_id,omitempty"`
+	UserID   int64     `json:"user_id"` // who scheduled the job
+	Text     string    `json:"text"`
+	Due      time.Time `json:"due"` // of the next run
+	Created  time.Time `json:"created"`
+	Attempts int       `json:"attempts,omitempty"` // failed runs
+
+	Cron     string `json:"cron,omitempty"`     // schedule of a recurring job, empty for a job which runs once
+	Location string `json:"location,omitempty"` // time zone of the schedule
+	Persona  string `json:"persona,omitempty"`  // system prompt of a recurring prompt
+	Context  string `json:"context,omitempty"`  // text attached to a recurring prompt
+}
+
+// Recurring reports whether the job runs on a schedule.
+func (j Job) Recurring() bool {
+	return j.Cron != ""
+}
+
+// next returns the time of the run of the recurring job after t.
+func (j Job) next(t time.Time) (time.Time, error) {
+	c, err := ParseCron(j.Cron)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	loc, err := time.LoadLocation(j.Location)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	next := c.Next(t.In(loc))
+	if next.IsZero() {
+		return time.Time{}, fmt.Errorf("schedule %q never runs", j.Cron)
+	}
+
+	return next, nil
+}
+
+// Run is a run of a job.
+type Run struct {
+	Due     time.Time `json:"due"`
+	Time    time.Time `json:"time"`
+	Error   string    `json:"error,omitempty"`
+	Skipped bool      `json:"skipped,omitempty"` // missed during downtime
+}
+
+// Handler runs the job. The time is when the job runs, later than due after downtime.
+type Handler func(ctx context.Context, job Job, now time.Time) error
+
+// Scheduler keeps the jobs in the store and runs them with the handlers of their kinds.
+type Scheduler struct {
+	mu       sync.Mutex
+	store    *store.Store
+	location *time.Location // default time zone of the users
+	catchUp  string         // policy for the missed runs of the recurring jobs
+	handlers map[string]Handler
+	logger   zerolog.Logger
+	now      func() time.Time
+}
+
+// New makes a scheduler of the jobs in the store with the default time zone of the users.
+func New(st *store.Store, location *time.Location, logger zerolog.Logger) *Scheduler {
+	return &Scheduler{
+		store:    st,
+		location: location,
+		catchUp:  CatchUpOnce,
+		handlers: make(map[string]Handler),
+		logger:   logger,
+		now:      time.Now,
+	}
+}
+
+// SetCatchUp sets the policy for the runs of the recurring jobs missed during downtime, CatchUpOnce by default.
+func (s *Scheduler) SetCatchUp(policy string) error {
+	switch policy {
+	case CatchUpSkip, CatchUpOnce, CatchUpAll:
+	default:
+		return fmt.Errorf("unknown catch-up policy %q", policy)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.catchUp = policy
+
+	return nil
+}
+
+// Handle sets the handler of the jobs of the kind.
+func (s *Scheduler) Handle(kind string, h Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.handlers[kind] = h
+}
+
+// Add saves the job with a new ID. The due time of a recurring job is its next run.
+func (s *Scheduler) Add(job Job) (Job, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return Job{}, err
+	}
+
+	job.ID = hex.EncodeToString(b)
+	job.Created = s.now()
+
+	if job.Recurring() {
+		next, err := job.next(job.Created)
+		if err != nil {
+			return Job{}, err
+		}
+
+		job.Due = next
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return job, s.store.Put(jobsBucket, job.ID, job)
+}
+
+// Get returns the job.
+func (s *Scheduler) Get(id string) (Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.get(id)
+}
+
+// Update saves the changed job.
+func (s *Scheduler) Update(job Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.get(job.ID); err != nil {
+		return err
+	}
+
+	return s.store.Put(jobsBucket, job.ID, job)
+}
+
+// Cancel deletes the job and its runs.
+func (s *Scheduler) Cancel(id string) (Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, err := s.get(id)
+	if err != nil {
+		return Job{}, err
+	}
+
+	if err := s.store.Delete(runsBucket, id); err != nil {
+		return Job{}, err
+	}
+
+	return job, s.store.Delete(jobsBucket, id)
+}
+
+// Runs returns the last runs of the job, the latest first.
+func (s *Scheduler) Runs(id string) ([]Run, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var runs []Run
+	if _, err := s.store.Get(runsBucket, id, &runs); err != nil {
+		return nil, err
+	}
+
+	return runs, nil
+}
+
+// Jobs returns the jobs of the kind in the chat, of the user unless userID is zero, the earliest first.
+func (s *Scheduler) Jobs(kind string, chatID, userID int64) ([]Job, error) {
+	return s.filter(func(job Job) bool {
+		return job.Kind == kind && job.ChatID == chatID && (userID == 0 || job.UserID == userID)
+	})
+}
+
+// JobsOf returns the jobs of the kind in all chats, the earliest first.
+func (s *Scheduler) JobsOf(kind string) ([]Job, error) {
+	return s.filter(func(job Job) bool { return job.Kind == kind })
+}
+
+// filter returns the jobs which match, the earliest first.
+func (s *Scheduler) filter(match func(Job) bool) ([]Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all, err := s.all()
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []Job
+	for _, job := range all {
+		if match(job) {
+			jobs = append(jobs, job)
+		}
+	}
+
+	return jobs, nil
+}
+
+// Run runs the due jobs every interval until ctx is done.
+func (s *Scheduler) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.runDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runDue runs the jobs due by now.
+func (s *Scheduler) runDue(ctx context.Context) {
+	now := s.now()
+
+	s.mu.Lock()
+	all, err := s.all()
+	s.mu.Unlock()
+
+	if err != nil {
+		s.logger.Error().Err(err).Msg("failed to load the jobs")
+		return
+	}
+
+	for _, job := range all {
+		if job.Due.After(now) || ctx.Err() != nil {
+			continue
+		}
+
+		logger := s.logger.With().Str("job", job.ID).Str("kind", job.Kind).Int64("chat_id", job.ChatID).Logger()
+		ctx := logger.WithContext(ctx)
+
+		if job.Recurring() {
+			s.runRecurring(ctx, job, now)
+		} else {
+			s.runOnce(ctx, job, now)
+		}
+	}
+}
+
+// runOnce runs the job which runs once. The job is deleted before it runs, so that it runs once
+// even if the bot stops during the run, and is saved again to be retried if it fails.
+func (s *Scheduler) runOnce(ctx context.Context, job Job, now time.Time) {
+	s.mu.Lock()
+	_, err := s.get(job.ID)
+	if err == nil {
+		err = s.store.Delete(jobsBucket, job.ID)
+	}
+	s.mu.Unlock()
+
+	if err != nil {
+		return // canceled meanwhile
+	}
+
+	if err := s.run(ctx, job, now); err != nil {
+		job.Attempts++
+		if job.Attempts < maxAttempts {
+			s.save(ctx, job)
+		}
+	}
+}
+
+// runRecurring runs the due recurring job according to the catch-up policy if its runs were missed
+// and schedules the next run. The next run is saved before the job runs, so that it runs once.
+func (s *Scheduler) runRecurring(ctx context.Context, job Job, now time.Time) {
+	next, err := job.next(now)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("invalid schedule, the job is canceled")
+		s.Cancel(job.ID)
+
+		return
+	}
+
+	// The due times of the runs until now
+	dues := []time.Time{job.Due}
+	for t := job.Due; len(dues) < maxCatchUp; {
+		if t, err = job.next(t); err != nil || t.After(now) {
+			break
+		}
+
+		dues = append(dues, t)
+	}
+
+	s.mu.Lock()
+	catchUp := s.catchUp
+	_, err = s.get(job.ID)
+	if err == nil {
+		updated := job
+		updated.Due = next
+		err = s.store.Put(jobsBucket, job.ID, updated)
+	}
+	s.mu.Unlock()
+
+	if err != nil {
+		return // canceled meanwhile
+	}
+
+	missed := now.Sub(job.Due) > missedAfter
+	switch {
+	case !missed:
+		dues = dues[len(dues)-1:]
+	case catchUp == CatchUpSkip:
+		zerolog.Ctx(ctx).Info().Int("runs", len(dues)).Msg("missed runs skipped")
+		s.record(ctx, job.ID, Run{Due: dues[len(dues)-1], Time: now, Skipped: true})
+
+		return
+	case catchUp == CatchUpOnce:
+		dues = dues[len(dues)-1:]
+	}
+
+	for _, due := range dues {
+		job.Due = due
+		s.run(ctx, job, now)
+	}
+}
+
+// run runs the job with the handler of its kind and records the run of a recurring job.
+func (s *Scheduler) run(ctx context.Context, job Job, now time.Time) error {
+	s.mu.Lock()
+	h := s.handlers[job.Kind]
+	s.mu.Unlock()
+
+	err := errors.New("no handler of the job")
+	if h != nil {
+		err = h(ctx, job, now)
+	}
+
+	run := Run{Due: job.Due, Time: now}
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Int("attempts", job.Attempts+1).Msg("job failed")
+		run.Error = err.Error()
+	} else {
+		zerolog.Ctx(ctx).Debug().Msg("job done")
+	}
+
+	if job.Recurring() {
+		s.record(ctx, job.ID, run)
+	}
+
+	return err
+}
+
+// record keeps the run of the job.
+func (s *Scheduler) record(ctx context.Context, id string, run Run) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var runs []Run
+	_, err := s.store.Get(runsBucket, id, &runs)
+	if err == nil {
+		runs = append([]Run{run}, runs...)
+		if len(runs) > maxRuns {
+			runs = runs[:maxRuns]
+		}
+
+		err = s.store.Put(runsBucket, id, runs)
+	}
+
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to record the run")
+	}
+}
+
+// save saves the job to run it again.
+func (s *Scheduler) save(ctx context.Context, job Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.store.Put(jobsBucket, job.ID, job); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to save the job")
+	}
+}
+
+// Location returns the time zone of the user, the default one if the user has not set it.
+func (s *Scheduler) Location(userID int64) *time.Location {
+	var name string
+	if ok, err := s.store.Get(timezonesBucket, fmt.Sprint(userID), &name); err != nil || !ok {
+		return s.location
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return s.location
+	}
+
+	return loc
+}
+
+// SetLocation sets the time zone of the user by its IANA name, e.g. Europe/Berlin.
+func (s *Scheduler) SetLocation(userID int64, name string) (*time.Location, error) {
+	loc, err := time.LoadLocation(name)
+	if err != nil || name == "" || name == "Local" {
+		return nil, fmt.Errorf("unknown time zone %q", name)
+	}
+
+	return loc, s.store.Put(timezonesBucket, fmt.Sprint(userID), loc.String())
+}
+
+func (s *Scheduler) get(id string) (Job, error) {
+	var job Job
+	ok, err := s.store.Get(jobsBucket, id, &job)
+	if err != nil {
+		return Job{}, err
+	}
+
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+
+	return job, nil
+}
+
+// all returns the jobs, the earliest first.
+func (s *Scheduler) all() ([]Job, error) {
+	var jobs []Job
+	for _, id := range s.store.Keys(jobsBucket) {
+		job, err := s.get(id)
+		if err != nil {
+			return nil, err
+		}
+
+		jobs = append(jobs, job)
+	}
+
+	sort.SliceStable(jobs, func(i, j int) bool { return jobs[i].Due.Before(jobs[j].Due) })
+
+	return jobs, nil
+}
@@ -0,0 +1,230 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ivanglie/chatgpt-bot/internal/store"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newScheduler(t *testing.T, path string) *Scheduler {
+	st, err := store.Open(path)
+	require.NoError(t, err)
+
+	return New(st, time.UTC, zerolog.Nop())
+}
+
+func TestScheduler_Jobs(t *testing.T) {
+	s := newScheduler(t, "")
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	later, err := s.Add(Job{Kind: "reminder", ChatID: 1, UserID: 10, Text: "later", Due: now.Add(2 * time.Hour)})
+	require.NoError(t, err)
+	assert.NotEmpty(t, later.ID)
+
+	_, err = s.Add(Job{Kind: "reminder", ChatID: 1, UserID: 10, Text: "sooner", Due: now.Add(time.Hour)})
+	require.NoError(t, err)
+	_, err = s.Add(Job{Kind: "reminder", ChatID: 1, UserID: 11, Text: "other user", Due: now})
+	require.NoError(t, err)
+	_, err = s.Add(Job{Kind: "other", ChatID: 1, UserID: 10, Text: "other kind", Due: now})
+	require.NoError(t, err)
+
+	jobs, err := s.Jobs("reminder", 1, 10)
+	require.NoError(t, err)
+	require.Len(t, jobs, 2)
+	assert.Equal(t, "sooner", jobs[0].Text)
+
+	jobs, err = s.Jobs("reminder", 1, 0)
+	require.NoError(t, err)
+	assert.Len(t, jobs, 3)
+
+	_, err = s.Add(Job{Kind: "reminder", ChatID: 2, UserID: 10, Text: "other chat", Due: now})
+	require.NoError(t, err)
+
+	jobs, err = s.JobsOf("reminder")
+	require.NoError(t, err)
+	assert.Len(t, jobs, 4)
+
+	job, err := s.Get(later.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "later", job.Text)
+
+	_, err = s.Cancel(later.ID)
+	require.NoError(t, err)
+
+	_, err = s.Cancel(later.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestScheduler_Run(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	s := newScheduler(t, path)
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	_, err := s.Add(Job{Kind: "reminder", ChatID: 1, Text: "due", Due: now.Add(-time.Hour)})
+	require.NoError(t, err)
+	_, err = s.Add(Job{Kind: "reminder", ChatID: 1, Text: "future", Due: now.Add(time.Hour)})
+	require.NoError(t, err)
+
+	// The job missed during downtime runs once after the restart
+	s = newScheduler(t, path)
+	s.now = func() time.Time { return now }
+
+	var done []string
+	s.Handle("reminder", func(_ context.Context, job Job, _ time.Time) error {
+		done = append(done, job.Text)
+		return nil
+	})
+
+	s.runDue(context.Background())
+	s.runDue(context.Background())
+	assert.Equal(t, []string{"due"}, done)
+
+	now = now.Add(2 * time.Hour)
+	s.runDue(context.Background())
+	assert.Equal(t, []string{"due", "future"}, done)
+
+	jobs, err := s.Jobs("reminder", 1, 0)
+	require.NoError(t, err)
+	assert.Empty(t, jobs)
+}
+
+func TestScheduler_Retry(t *testing.T) {
+	s := newScheduler(t, "")
+
+	var runs int
+	s.Handle("reminder", func(context.Context, Job, time.Time) error {
+		runs++
+		return errors.New("unavailable")
+	})
+
+	_, err := s.Add(Job{Kind: "reminder", ChatID: 1, Due: time.Now()})
+	require.NoError(t, err)
+
+	for i := 0; i < maxAttempts+2; i++ {
+		s.runDue(context.Background())
+	}
+
+	assert.Equal(t, maxAttempts, runs)
+}
+
+func TestScheduler_Location(t *testing.T) {
+	s := newScheduler(t, "")
+
+	assert.Equal(t, time.UTC, s.Location(1))
+
+	loc, err := s.SetLocation(1, "Europe/Berlin")
+	require.NoError(t, err)
+	assert.Equal(t, "Europe/Berlin", loc.String())
+	assert.Equal(t, "Europe/Berlin", s.Location(1).String())
+	assert.Equal(t, time.UTC, s.Location(2))
+
+	_, err = s.SetLocation(1, "Mars/Olympus")
+	assert.Error(t, err)
+	_, err = s.SetLocation(1, "")
+	assert.Error(t, err)
+}
+
+func TestScheduler_Recurring(t *testing.T) {
+	s := newScheduler(t, "")
+
+	now := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC) // Monday
+	s.now = func() time.Time { return now }
+
+	var dues []time.Time
+	fail := false
+	s.Handle("prompt", func(_ context.Context, job Job, _ time.Time) error {
+		dues = append(dues, job.Due)
+		if fail {
+			return errors.New("unavailable")
+		}
+
+		return nil
+	})
+
+	job, err := s.Add(Job{Kind: "prompt", ChatID: 1, Text: "standup", Cron: "0 9 * * *", Location: "UTC"})
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC), job.Due)
+
+	_, err = s.Add(Job{Kind: "prompt", Cron: "0 0 30 2 *", Location: "UTC"})
+	assert.Error(t, err)
+
+	now = time.Date(2024, 1, 1, 9, 0, 5, 0, time.UTC)
+	s.runDue(context.Background())
+	s.runDue(context.Background())
+	assert.Len(t, dues, 1)
+
+	job, err = s.Get(job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC), job.Due)
+
+	fail = true
+	now = time.Date(2024, 1, 2, 9, 0, 5, 0, time.UTC)
+	s.runDue(context.Background())
+
+	runs, err := s.Runs(job.ID)
+	require.NoError(t, err)
+	require.Len(t, runs, 2)
+	assert.Equal(t, "unavailable", runs[0].Error)
+	assert.Empty(t, runs[1].Error)
+
+	// The failed job still recurs
+	job, _ = s.Get(job.ID)
+	assert.Equal(t, time.Date(2024, 1, 3, 9, 0, 0, 0, time.UTC), job.Due)
+
+	_, err = s.Cancel(job.ID)
+	require.NoError(t, err)
+	runs, _ = s.Runs(job.ID)
+	assert.Empty(t, runs)
+}
+
+func TestScheduler_CatchUp(t *testing.T) {
+	for _, tt := range []struct {
+		policy string
+		runs   int
+	}{
+		{CatchUpSkip, 0},
+		{CatchUpOnce, 1},
+		{CatchUpAll, 3},
+	} {
+		t.Run(tt.policy, func(t *testing.T) {
+			s := newScheduler(t, "")
+			require.NoError(t, s.SetCatchUp(tt.policy))
+
+			now := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+			s.now = func() time.Time { return now }
+
+			var runs int
+			s.Handle("prompt", func(context.Context, Job, time.Time) error {
+				runs++
+				return nil
+			})
+
+			job, err := s.Add(Job{Kind: "prompt", ChatID: 1, Cron: "0 9 * * *", Location: "UTC"})
+			require.NoError(t, err)
+
+			// Down for three days
+			now = time.Date(2024, 1, 3, 12, 0, 0, 0, time.UTC)
+			s.runDue(context.Background())
+			assert.Equal(t, tt.runs, runs)
+
+			job, _ = s.Get(job.ID)
+			assert.Equal(t, time.Date(2024, 1, 4, 9, 0, 0, 0, time.UTC), job.Due)
+
+			history, _ := s.Runs(job.ID)
+			assert.NotEmpty(t, history)
+			assert.Equal(t, tt.policy == CatchUpSkip, history[0].Skipped)
+		})
+	}
+
+	s := newScheduler(t, "")
+	assert.Error(t, s.SetCatchUp("never"))
+}
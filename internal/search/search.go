@@ -0,0 +1,263 @@
+// Package search finds the earlier exchanges of the users by meaning with the embeddings API.
+package search
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ivanglie/chatgpt-bot/internal/store"
+	"github.com/rs/zerolog"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// bucket is the bucket of the store with the indexes of the users.
+const bucket = "search"
+
+const (
+	dimensions = 256  // of the embeddings of the models which can shorten them
+	maxEntries = 1000 // per user, the oldest ones are dropped
+	maxSnippet = 300  // length of the kept texts in runes
+	maxInput   = 8000 // length of the embedded text in runes
+	queueSize  = 100  // exchanges waiting to be indexed
+	minScore   = 0.2  // cosine similarity of a result
+)
+
+// Client is interface for the embeddings API with the possibility to mock it.
+type Client interface {
+	CreateEmbeddings(ctx context.Context, conv openai.EmbeddingRequestConverter) (openai.EmbeddingResponse, error)
+}
+
+// Entry is an indexed exchange of a conversation.
+type Entry struct {
+	Scope        string    `json:"scope"`        // key of the conversations of the chat
+	Conversation string    `json:"conversation"` // ID of the conversation
+	Request      string    `json:"request"`
+	Response     string    `json:"response"`
+	Time         time.Time `json:"time"`
+	Vector       []float32 `json:"vector"` // normalized embedding
+}
+
+// Result is an entry matching a query.
+type Result struct {
+	Entry
+	Score float64 // cosine similarity to the query
+}
+
+// entries are the indexed exchanges of a user.
+type entries struct {
+	Entries []Entry `json:"entries"`
+}
+
+type job struct {
+	userID string
+	entry  Entry
+}
+
+// Index keeps the embeddings of the exchanges of each user in the store.
+type Index struct {
+	// Redact is applied to the texts sent to the API, nil to send them as is.
+	Redact func(string) string
+
+	mu     sync.Mutex
+	client Client
+	model  openai.EmbeddingModel
+	store  *store.Store
+	logger zerolog.Logger
+	queue  chan job
+}
+
+// New makes an index which embeds the texts with the model and logs to the logger.
+func New(client Client, model string, st *store.Store, logger zerolog.Logger) *Index {
+	return &Index{
+		client: client,
+		model:  openai.EmbeddingModel(model),
+		store:  st,
+		logger: logger,
+		queue:  make(chan job, queueSize),
+	}
+}
+
+// Add queues the exchange of the user to be indexed in the background by Run.
+// It reports false if the queue is full and the exchange is dropped.
+func (ix *Index) Add(userID string, e Entry) bool {
+	select {
+	case ix.queue <- job{userID: userID, entry: e}:
+		return true
+	default:
+		return false
+	}
+}
+
+// Run indexes the queued exchanges until ctx is done.
+func (ix *Index) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case j := <-ix.queue:
+			if err := ix.index(ctx, j.userID, j.entry); err != nil {
+				ix.logger.Warn().Err(err).Msg("failed to index the exchange")
+			}
+		}
+	}
+}
+
+// index embeds the exchange and saves it in the index of the user.
+func (ix *Index) index(ctx context.Context, userID string, e Entry) error {
+	vector, err := ix.embed(ctx, e.Request+"\n\n"+e.Response)
+	if err != nil {
+		return err
+	}
+
+	e.Request, e.Response, e.Vector = cut(e.Request, maxSnippet), cut(e.Response, maxSnippet), vector
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	var es entries
+	if _, err := ix.store.Get(bucket, userID, &es); err != nil {
+		return err
+	}
+
+	es.Entries = append(es.Entries, e)
+	if len(es.Entries) > maxEntries {
+		es.Entries = es.Entries[len(es.Entries)-maxEntries:]
+	}
+
+	return ix.store.Put(bucket, userID, es)
+}
+
+// Search returns the exchanges of the user in the scope most similar to the query by meaning, the best first.
+func (ix *Index) Search(ctx context.Context, userID, scope, query string, limit int) ([]Result, error) {
+	vector, err := ix.embed(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	ix.mu.Lock()
+	var es entries
+	_, err = ix.store.Get(bucket, userID, &es)
+	ix.mu.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var results []Result
+	for _, e := range es.Entries {
+		if e.Scope != scope || len(e.Vector) != len(vector) {
+			continue
+		}
+
+		if score := dot(e.Vector, vector); score >= minScore {
+			results = append(results, Result{Entry: e, Score: score})
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+
+	if len(results) > limit {
+		results = results[:limit]
+	}
+
+	return results, nil
+}
+
+// Delete removes the exchanges of the conversation from the indexes of all users.
+func (ix *Index) Delete(scope, conversation string) error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	for _, userID := range ix.store.Keys(bucket) {
+		var es entries
+		if _, err := ix.store.Get(bucket, userID, &es); err != nil {
+			return err
+		}
+
+		kept := es.Entries[:0]
+		for _, e := range es.Entries {
+			if e.Scope != scope || e.Conversation != conversation {
+				kept = append(kept, e)
+			}
+		}
+
+		if len(kept) == len(es.Entries) {
+			continue
+		}
+
+		es.Entries = kept
+		if err := ix.store.Put(bucket, userID, es); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// embed returns the normalized embedding of the text.
+func (ix *Index) embed(ctx context.Context, text string) ([]float32, error) {
+	text = cut(text, maxInput)
+	if ix.Redact != nil {
+		text = ix.Redact(text)
+	}
+
+	req := openai.EmbeddingRequest{Input: []string{text}, Model: ix.model}
+	if strings.HasPrefix(string(ix.model), "text-embedding-3") {
+		req.Dimensions = dimensions
+	}
+
+	res, err := ix.client.CreateEmbeddings(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(res.Data) == 0 {
+		return nil, errors.New("no embedding")
+	}
+
+	return normalize(res.Data[0].Embedding), nil
+}
+
+// normalize scales the vector to the unit length, so that the dot product is the cosine similarity.
+func normalize(v []float32) []float32 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+
+	if sum == 0 {
+		return v
+	}
+
+	norm := math.Sqrt(sum)
+
+	out := make([]float32, len(v))
+	for i, x := range v {
+		out[i] = float32(float64(x) / norm)
+	}
+
+	return out
+}
+
+func dot(a, b []float32) float64 {
+	var sum float64
+	for i := range a {
+		sum += float64(a[i]) * float64(b[i])
+	}
+
+	return sum
+}
+
+// cut limits the text to the number of runes.
+func cut(text string, limit int) string {
+	if r := []rune(text); len(r) > limit {
+		return string(r[:limit]) + "…"
+	}
+
+	return text
+}
@@ -0,0 +1,129 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ivanglie/chatgpt-bot/internal/store"
+	"github.com/rs/zerolog"
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// MockEmbeddings embeds texts as bags of words hashed into the dimensions.
+type MockEmbeddings struct {
+	mu       sync.Mutex
+	requests []openai.EmbeddingRequest
+	err      error
+}
+
+func (m *MockEmbeddings) CreateEmbeddings(_ context.Context, conv openai.EmbeddingRequestConverter) (openai.EmbeddingResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	req := conv.Convert()
+	m.requests = append(m.requests, req)
+	if m.err != nil {
+		return openai.EmbeddingResponse{}, m.err
+	}
+
+	var res openai.EmbeddingResponse
+	for i, text := range req.Input.([]string) {
+		v := make([]float32, req.Dimensions)
+		for _, w := range strings.Fields(strings.ToLower(text)) {
+			h := fnv.New32a()
+			h.Write([]byte(strings.Trim(w, ".,?!")))
+			v[h.Sum32()%uint32(len(v))]++
+		}
+
+		res.Data = append(res.Data, openai.Embedding{Embedding: v, Index: i})
+	}
+
+	return res, nil
+}
+
+func newIndex(t *testing.T, client Client) *Index {
+	st, err := store.Open("")
+	require.NoError(t, err)
+
+	return New(client, string(openai.SmallEmbedding3), st, zerolog.Nop())
+}
+
+func TestIndex_Search(t *testing.T) {
+	ix := newIndex(t, &MockEmbeddings{})
+	ctx := context.Background()
+
+	for _, e := range []Entry{
+		{Scope: "chat", Conversation: "1", Request: "How do I cook pasta?", Response: "Boil water and add pasta"},
+		{Scope: "chat", Conversation: "2", Request: "Sort a slice in Go", Response: "Use slices.Sort"},
+		{Scope: "other", Conversation: "1", Request: "Cook pasta again", Response: "Boil water"},
+	} {
+		require.NoError(t, ix.index(ctx, "1", e))
+	}
+
+	results, err := ix.Search(ctx, "1", "chat", "pasta water", 5)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "1", results[0].Conversation)
+	assert.Greater(t, results[0].Score, 0.2)
+
+	results, err = ix.Search(ctx, "2", "chat", "pasta water", 5)
+	require.NoError(t, err)
+	assert.Empty(t, results)
+
+	require.NoError(t, ix.Delete("chat", "1"))
+	results, err = ix.Search(ctx, "1", "chat", "pasta water", 5)
+	require.NoError(t, err)
+	assert.Empty(t, results)
+
+	results, err = ix.Search(ctx, "1", "other", "pasta water", 5)
+	require.NoError(t, err)
+	assert.Len(t, results, 1)
+}
+
+func TestIndex_Redact(t *testing.T) {
+	mock := &MockEmbeddings{}
+	ix := newIndex(t, mock)
+	ix.Redact = func(text string) string { return strings.ReplaceAll(text, "secret", "[SECRET]") }
+
+	require.NoError(t, ix.index(context.Background(), "1", Entry{Request: "my secret", Response: "ok"}))
+	assert.Equal(t, []string{"my [SECRET]\n\nok"}, mock.requests[0].Input)
+	assert.Equal(t, dimensions, mock.requests[0].Dimensions)
+}
+
+func TestIndex_Run(t *testing.T) {
+	mock := &MockEmbeddings{}
+	ix := newIndex(t, mock)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		ix.Run(ctx)
+		close(done)
+	}()
+
+	assert.True(t, ix.Add("1", Entry{Scope: "chat", Conversation: "1", Request: "pasta", Response: "water"}))
+
+	assert.Eventually(t, func() bool {
+		results, err := ix.Search(context.Background(), "1", "chat", "pasta", 5)
+		return err == nil && len(results) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	<-done
+}
+
+func TestIndex_Error(t *testing.T) {
+	ix := newIndex(t, &MockEmbeddings{err: errors.New("unavailable")})
+
+	assert.Error(t, ix.index(context.Background(), "1", Entry{Request: "pasta"}))
+
+	_, err := ix.Search(context.Background(), "1", "chat", "pasta", 5)
+	assert.Error(t, err)
+}
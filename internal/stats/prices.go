@@ -0,0 +1,79 @@
+package stats
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Price is the price of a model in USD per million tokens.
+type Price struct {
+	Input  float64 `json:"input"`
+	Output float64 `json:"output"`
+}
+
+// Prices are the prices of the models by name. The price of a model applies to its versions, e.g. gpt-4o to gpt-4o-2024-08-06.
+type Prices map[string]Price
+
+// DefaultPrices are the prices of the common models of OpenAI.
+var DefaultPrices = Prices{
+	"gpt-3.5-turbo": {Input: 0.5, Output: 1.5},
+	"gpt-4o":        {Input: 2.5, Output: 10},
+	"gpt-4o-mini":   {Input: 0.15, Output: 0.6},
+	"gpt-4.1":       {Input: 2, Output: 8},
+	"gpt-4.1-mini":  {Input: 0.4, Output: 1.6},
+	"gpt-4.1-nano":  {Input: 0.1, Output: 0.4},
+	"o3-mini":       {Input: 1.1, Output: 4.4},
+	"o4-mini":       {Input: 1.1, Output: 4.4},
+}
+
+// ParsePrices returns the default prices with the prices of the specs model:input:output added or replaced.
+func ParsePrices(specs []string) (Prices, error) {
+	prices := make(Prices, len(DefaultPrices)+len(specs))
+	for m, p := range DefaultPrices {
+		prices[m] = p
+	}
+
+	for _, spec := range specs {
+		parts := strings.Split(spec, ":")
+		if len(parts) != 3 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid price %q, expected model:input:output", spec)
+		}
+
+		input, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || input < 0 {
+			return nil, fmt.Errorf("invalid input price of %s: %q", parts[0], parts[1])
+		}
+
+		output, err := strconv.ParseFloat(parts[2], 64)
+		if err != nil || output < 0 {
+			return nil, fmt.Errorf("invalid output price of %s: %q", parts[0], parts[2])
+		}
+
+		prices[parts[0]] = Price{Input: input, Output: output}
+	}
+
+	return prices, nil
+}
+
+// Cost returns the cost of the usage of the model in USD and whether its price is known.
+// The price of the longest name the model starts with applies.
+func (p Prices) Cost(model string, u Usage) (float64, bool) {
+	var (
+		name  string
+		price Price
+		found bool
+	)
+
+	for m, pr := range p {
+		if strings.HasPrefix(model, m) && len(m) > len(name) {
+			name, price, found = m, pr, true
+		}
+	}
+
+	if !found {
+		return 0, false
+	}
+
+	return (float64(u.PromptTokens)*price.Input + float64(u.CompletionTokens)*price.Output) / 1e6, true
+}
@@ -0,0 +1,498 @@
+// Package stats keeps the daily statistics of the usage of the bot.
+package stats
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ivanglie/chatgpt-bot/internal/store"
+)
+
+// bucket is the bucket of the store with the days.
+const bucket = "stats"
+
+// dateLayout is the layout of the dates of the days, the keys of the store.
+const dateLayout = "2006-01-02"
+
+// Retention is the number of the days of the statistics kept.
+const Retention = 400
+
+const (
+	saveInterval = time.Minute // limits the writes of the current day, so that the store is not written on every update
+	maxTop       = 10          // users in the report
+)
+
+// Usage is the usage of a model.
+type Usage struct {
+	Requests         int `json:"requests"`
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+func (u *Usage) add(v Usage) {
+	u.Requests += v.Requests
+	u.PromptTokens += v.PromptTokens
+	u.CompletionTokens += v.CompletionTokens
+}
+
+// Activity is the activity of a user.
+type Activity struct {
+	Updates int `json:"updates"`
+	Tokens  int `json:"tokens"`
+}
+
+// Day is the statistics of a day.
+type Day struct {
+	Date     string               `json:"date"`
+	Updates  map[string]int       `json:"updates,omitempty"`  // by type
+	Requests map[string]int       `json:"requests,omitempty"` // responses of the model by kind
+	Errors   map[string]int       `json:"errors,omitempty"`   // failed requests to the model by kind
+	Models   map[string]*Usage    `json:"models,omitempty"`
+	Backends map[string]*Usage    `json:"backends,omitempty"` // which answered
+	Users    map[string]*Activity `json:"users,omitempty"`
+}
+
+// init makes the maps of the day missing in the store.
+func (d *Day) init() {
+	if d.Updates == nil {
+		d.Updates = make(map[string]int)
+	}
+	if d.Requests == nil {
+		d.Requests = make(map[string]int)
+	}
+	if d.Errors == nil {
+		d.Errors = make(map[string]int)
+	}
+	if d.Models == nil {
+		d.Models = make(map[string]*Usage)
+	}
+	if d.Backends == nil {
+		d.Backends = make(map[string]*Usage)
+	}
+	if d.Users == nil {
+		d.Users = make(map[string]*Activity)
+	}
+}
+
+// clone returns a copy of the day.
+func (d *Day) clone() Day {
+	c := Day{Date: d.Date}
+	c.init()
+
+	for k, v := range d.Updates {
+		c.Updates[k] = v
+	}
+	for k, v := range d.Requests {
+		c.Requests[k] = v
+	}
+	for k, v := range d.Errors {
+		c.Errors[k] = v
+	}
+	for k, v := range d.Models {
+		u := *v
+		c.Models[k] = &u
+	}
+	for k, v := range d.Backends {
+		u := *v
+		c.Backends[k] = &u
+	}
+	for k, v := range d.Users {
+		a := *v
+		c.Users[k] = &a
+	}
+
+	return c
+}
+
+// user returns the activity of the user, added if it is new.
+func (d *Day) user(id string) *Activity {
+	a, ok := d.Users[id]
+	if !ok {
+		a = &Activity{}
+		d.Users[id] = a
+	}
+
+	return a
+}
+
+// Stats keeps the statistics in the store by day.
+type Stats struct {
+	mu     sync.Mutex
+	store  *store.Store
+	prices Prices
+	now    func() time.Time
+
+	today *Day      // kept in memory and saved at most every saveInterval
+	dirty bool      // today has changes not saved yet
+	saved time.Time // of today
+}
+
+// New makes the statistics in the store with the prices of the models.
+func New(st *store.Store, prices Prices) *Stats {
+	return &Stats{store: st, prices: prices, now: time.Now}
+}
+
+// Update counts an update of the type from the user.
+func (s *Stats) Update(user, typ string) error {
+	return s.update(func(d *Day) {
+		d.Updates[typ]++
+		if user != "" {
+			d.user(user).Updates++
+		}
+	})
+}
+
+// Usage counts a response of the kind by the model of the backend, if known, for the user, if known.
+func (s *Stats) Usage(user, kind, backend, model string, promptTokens, completionTokens int) error {
+	return s.update(func(d *Day) {
+		d.Requests[kind]++
+
+		usage := Usage{Requests: 1, PromptTokens: promptTokens, CompletionTokens: completionTokens}
+		addUsage(d.Models, model, usage)
+		if backend != "" {
+			addUsage(d.Backends, backend, usage)
+		}
+
+		if user != "" {
+			d.user(user).Tokens += promptTokens + completionTokens
+		}
+	})
+}
+
+// addUsage adds the usage to the one with the name, added if it is new.
+func addUsage(usages map[string]*Usage, name string, v Usage) {
+	u, ok := usages[name]
+	if !ok {
+		u = &Usage{}
+		usages[name] = u
+	}
+
+	u.add(v)
+}
+
+// Error counts a failed request of the kind to the model.
+func (s *Stats) Error(kind string) error {
+	return s.update(func(d *Day) {
+		d.Errors[kind]++
+	})
+}
+
+// Flush saves the changes of the current day.
+func (s *Stats) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.save()
+}
+
+// update applies the change to the current day and saves it if it is due.
+func (s *Stats) update(change func(d *Day)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	date := now.Format(dateLayout)
+
+	if s.today == nil || s.today.Date != date {
+		if err := s.save(); err != nil {
+			return err
+		}
+
+		d, err := s.load(date)
+		if err != nil {
+			return err
+		}
+
+		s.today = d
+		s.saved = now
+		s.prune(now)
+	}
+
+	change(s.today)
+	s.dirty = true
+
+	if now.Sub(s.saved) < saveInterval {
+		return nil
+	}
+
+	return s.save()
+}
+
+// save saves the current day if it has changes.
+func (s *Stats) save() error {
+	if !s.dirty {
+		return nil
+	}
+
+	if err := s.store.Put(bucket, s.today.Date, s.today); err != nil {
+		return err
+	}
+
+	s.dirty = false
+	s.saved = s.now()
+
+	return nil
+}
+
+// load returns the day of the date from the store, empty if there is none.
+func (s *Stats) load(date string) (*Day, error) {
+	d := &Day{Date: date}
+	if _, err := s.store.Get(bucket, date, d); err != nil {
+		return nil, err
+	}
+
+	d.init()
+
+	return d, nil
+}
+
+// prune deletes the days older than the retention.
+func (s *Stats) prune(now time.Time) {
+	oldest := now.AddDate(0, 0, -Retention).Format(dateLayout)
+	for _, date := range s.store.Keys(bucket) {
+		if date < oldest {
+			s.store.Delete(bucket, date)
+		}
+	}
+}
+
+// Days returns the last days up to today, oldest first.
+func (s *Stats) Days(days int) ([]Day, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	res := make([]Day, 0, days)
+
+	for i := days - 1; i >= 0; i-- {
+		date := now.AddDate(0, 0, -i).Format(dateLayout)
+
+		if s.today != nil && s.today.Date == date {
+			res = append(res, s.today.clone())
+			continue
+		}
+
+		d, err := s.load(date)
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, *d)
+	}
+
+	return res, nil
+}
+
+// ModelUsage is the usage of a model over a period.
+type ModelUsage struct {
+	Model string
+	Usage
+	Cost   float64 // in USD
+	Priced bool    // the price of the model is known
+}
+
+// UserActivity is the activity of a user over a period.
+type UserActivity struct {
+	User string
+	Activity
+}
+
+// Report is the statistics over the last days.
+type Report struct {
+	Days                               int
+	ActiveDay, ActiveWeek, ActiveMonth int // users active today and within the last 7 and 30 days
+	Updates                            map[string]int
+	Requests                           map[string]int
+	Errors                             map[string]int
+	Models                             []ModelUsage // most tokens first
+	Backends                           map[string]Usage
+	Users                              []UserActivity // most updates first, up to ten
+}
+
+// ErrorRate returns the share of the failed requests of the kind, of all requests if kind is empty.
+func (r Report) ErrorRate(kind string) float64 {
+	var requests, errors int
+	for k, n := range r.Requests {
+		if kind == "" || k == kind {
+			requests += n
+		}
+	}
+	for k, n := range r.Errors {
+		if kind == "" || k == kind {
+			errors += n
+		}
+	}
+
+	if requests+errors == 0 {
+		return 0
+	}
+
+	return float64(errors) / float64(requests+errors)
+}
+
+// Report returns the statistics over the last days including today.
+func (s *Stats) Report(days int) (Report, error) {
+	const month = 30
+
+	all, err := s.Days(max(days, month))
+	if err != nil {
+		return Report{}, err
+	}
+
+	r := Report{
+		Days:     days,
+		Updates:  make(map[string]int),
+		Requests: make(map[string]int),
+		Errors:   make(map[string]int),
+		Backends: make(map[string]Usage),
+	}
+
+	r.ActiveDay = active(all[len(all)-1:])
+	r.ActiveWeek = active(all[len(all)-7:])
+	r.ActiveMonth = active(all[len(all)-month:])
+
+	models := make(map[string]*Usage)
+	users := make(map[string]*Activity)
+
+	for _, d := range all[len(all)-days:] {
+		for k, n := range d.Updates {
+			r.Updates[k] += n
+		}
+		for k, n := range d.Requests {
+			r.Requests[k] += n
+		}
+		for k, n := range d.Errors {
+			r.Errors[k] += n
+		}
+		for m, u := range d.Models {
+			addUsage(models, m, *u)
+		}
+		for b, u := range d.Backends {
+			total := r.Backends[b]
+			total.add(*u)
+			r.Backends[b] = total
+		}
+		for id, a := range d.Users {
+			if users[id] == nil {
+				users[id] = &Activity{}
+			}
+			users[id].Updates += a.Updates
+			users[id].Tokens += a.Tokens
+		}
+	}
+
+	for m, u := range models {
+		cost, ok := s.prices.Cost(m, *u)
+		r.Models = append(r.Models, ModelUsage{Model: m, Usage: *u, Cost: cost, Priced: ok})
+	}
+	sort.Slice(r.Models, func(i, j int) bool {
+		ti := r.Models[i].PromptTokens + r.Models[i].CompletionTokens
+		tj := r.Models[j].PromptTokens + r.Models[j].CompletionTokens
+		if ti != tj {
+			return ti > tj
+		}
+
+		return r.Models[i].Model < r.Models[j].Model
+	})
+
+	for id, a := range users {
+		r.Users = append(r.Users, UserActivity{User: id, Activity: *a})
+	}
+	sort.Slice(r.Users, func(i, j int) bool {
+		if r.Users[i].Updates != r.Users[j].Updates {
+			return r.Users[i].Updates > r.Users[j].Updates
+		}
+		if r.Users[i].Tokens != r.Users[j].Tokens {
+			return r.Users[i].Tokens > r.Users[j].Tokens
+		}
+
+		return r.Users[i].User < r.Users[j].User
+	})
+	if len(r.Users) > maxTop {
+		r.Users = r.Users[:maxTop]
+	}
+
+	return r, nil
+}
+
+// active returns the number of the users active during the days.
+func active(days []Day) int {
+	users := make(map[string]bool)
+	for _, d := range days {
+		for id := range d.Users {
+			users[id] = true
+		}
+	}
+
+	return len(users)
+}
+
+// CSV returns the statistics of the last days including today as CSV,
+// a row of the totals of each day with an empty model followed by a row for each model.
+func (s *Stats) CSV(days int) ([]byte, error) {
+	all, err := s.Days(days)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"date", "model", "active_users", "messages", "requests", "errors", "prompt_tokens", "completion_tokens", "cost_usd"})
+
+	for _, d := range all {
+		var (
+			total  Usage
+			cost   float64
+			priced = true
+			rows   [][]string
+		)
+
+		models := make([]string, 0, len(d.Models))
+		for m := range d.Models {
+			models = append(models, m)
+		}
+		sort.Strings(models)
+
+		for _, m := range models {
+			u := *d.Models[m]
+			total.add(u)
+
+			c, ok := s.prices.Cost(m, u)
+			cost += c
+			priced = priced && ok
+
+			rows = append(rows, []string{d.Date, m, "", "", itoa(u.Requests), "", itoa(u.PromptTokens), itoa(u.CompletionTokens), money(c, ok)})
+		}
+
+		var errors int
+		for _, n := range d.Errors {
+			errors += n
+		}
+
+		w.Write([]string{d.Date, "", itoa(len(d.Users)), itoa(d.Updates["message"]), itoa(total.Requests), itoa(errors),
+			itoa(total.PromptTokens), itoa(total.CompletionTokens), money(cost, priced)})
+		w.WriteAll(rows)
+	}
+
+	w.Flush()
+
+	return buf.Bytes(), w.Error()
+}
+
+func itoa(n int) string {
+	return strconv.Itoa(n)
+}
+
+// money formats the cost, empty if the price is unknown.
+func money(cost float64, ok bool) string {
+	if !ok {
+		return ""
+	}
+
+	return fmt.Sprintf("%.4f", cost)
+}
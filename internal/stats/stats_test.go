@@ -0,0 +1,135 @@
+package stats
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ivanglie/chatgpt-bot/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStats(t *testing.T) {
+	st, err := store.Open("")
+	require.NoError(t, err)
+
+	now := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+	s := New(st, DefaultPrices)
+	s.now = func() time.Time { return now }
+
+	// A week ago
+	now = now.AddDate(0, 0, -7)
+	require.NoError(t, s.Update("1", "message"))
+	require.NoError(t, s.Usage("1", "generate", "default", "gpt-4o-mini", 1000000, 0))
+
+	// Today
+	now = now.AddDate(0, 0, 7)
+	require.NoError(t, s.Update("2", "message"))
+	require.NoError(t, s.Update("2", "message"))
+	require.NoError(t, s.Update("3", "callback_query"))
+	require.NoError(t, s.Update("", "message"))
+	require.NoError(t, s.Usage("2", "generate", "fallback", "gpt-4o-2024-08-06", 1000, 500))
+	require.NoError(t, s.Usage("", "prompt", "default", "custom", 10, 10))
+	require.NoError(t, s.Error("generate"))
+
+	r, err := s.Report(30)
+	require.NoError(t, err)
+	assert.Equal(t, 2, r.ActiveDay)
+	assert.Equal(t, 2, r.ActiveWeek)
+	assert.Equal(t, 3, r.ActiveMonth)
+	assert.Equal(t, map[string]int{"message": 4, "callback_query": 1}, r.Updates)
+	assert.Equal(t, map[string]int{"generate": 2, "prompt": 1}, r.Requests)
+	assert.InDelta(t, 1.0/3, r.ErrorRate("generate"), 1e-9)
+	assert.InDelta(t, 0.25, r.ErrorRate(""), 1e-9)
+	assert.Zero(t, r.ErrorRate("edit"))
+
+	require.Len(t, r.Models, 3)
+	assert.Equal(t, "gpt-4o-mini", r.Models[0].Model)
+	assert.InDelta(t, 0.15, r.Models[0].Cost, 1e-9)
+	assert.Equal(t, "gpt-4o-2024-08-06", r.Models[1].Model)
+	assert.InDelta(t, 0.0075, r.Models[1].Cost, 1e-9)
+	assert.False(t, r.Models[2].Priced)
+
+	assert.Equal(t, map[string]Usage{
+		"default":  {Requests: 2, PromptTokens: 1000010, CompletionTokens: 10},
+		"fallback": {Requests: 1, PromptTokens: 1000, CompletionTokens: 500},
+	}, r.Backends)
+
+	require.Len(t, r.Users, 3)
+	assert.Equal(t, UserActivity{User: "2", Activity: Activity{Updates: 2, Tokens: 1500}}, r.Users[0])
+	assert.Equal(t, "1", r.Users[1].User)
+
+	r, err = s.Report(1)
+	require.NoError(t, err)
+	assert.Equal(t, 3, r.ActiveMonth)
+	assert.Len(t, r.Models, 2)
+	assert.Equal(t, 3, r.Updates["message"])
+
+	// The current day is saved at most every minute and on flush
+	assert.Len(t, st.Keys(bucket), 1)
+	require.NoError(t, s.Flush())
+	assert.Len(t, st.Keys(bucket), 2)
+
+	s = New(st, DefaultPrices)
+	s.now = func() time.Time { return now }
+	require.NoError(t, s.Update("4", "message"))
+
+	r, err = s.Report(1)
+	require.NoError(t, err)
+	assert.Equal(t, 3, r.ActiveDay)
+	assert.Equal(t, 4, r.Updates["message"])
+
+	// Old days are deleted on the first update of a day
+	now = now.AddDate(0, 0, Retention-3)
+	require.NoError(t, s.Update("1", "message"))
+	assert.Equal(t, []string{"2024-01-10"}, st.Keys(bucket))
+}
+
+func TestStats_CSV(t *testing.T) {
+	st, err := store.Open("")
+	require.NoError(t, err)
+
+	now := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+	s := New(st, DefaultPrices)
+	s.now = func() time.Time { return now }
+
+	require.NoError(t, s.Update("1", "message"))
+	require.NoError(t, s.Usage("1", "generate", "default", "gpt-4o-mini", 1000000, 1000000))
+	require.NoError(t, s.Usage("1", "generate", "", "custom", 10, 20))
+	require.NoError(t, s.Error("generate"))
+
+	data, err := s.CSV(2)
+	require.NoError(t, err)
+	assert.Equal(t, strings.Join([]string{
+		"date,model,active_users,messages,requests,errors,prompt_tokens,completion_tokens,cost_usd",
+		"2024-01-09,,0,0,0,0,0,0,0.0000",
+		"2024-01-10,,1,1,2,1,1000010,1000020,",
+		"2024-01-10,custom,,,1,,10,20,",
+		"2024-01-10,gpt-4o-mini,,,1,,1000000,1000000,0.7500",
+		"",
+	}, "\n"), string(data))
+}
+
+func TestParsePrices(t *testing.T) {
+	prices, err := ParsePrices([]string{"gpt-4o:5:15", "llama:0:0.5"})
+	require.NoError(t, err)
+	assert.Equal(t, Price{Input: 5, Output: 15}, prices["gpt-4o"])
+	assert.Equal(t, Price{Output: 0.5}, prices["llama"])
+	assert.Equal(t, DefaultPrices["gpt-4o-mini"], prices["gpt-4o-mini"])
+	assert.Equal(t, Price{Input: 2.5, Output: 10}, DefaultPrices["gpt-4o"])
+
+	for _, spec := range []string{"gpt-4o", "gpt-4o:1", ":1:2", "gpt-4o:x:1", "gpt-4o:1:-1"} {
+		_, err := ParsePrices([]string{spec})
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestPrices_Cost(t *testing.T) {
+	cost, ok := DefaultPrices.Cost("gpt-4o-mini-2024-07-18", Usage{PromptTokens: 2000000, CompletionTokens: 1000000})
+	assert.True(t, ok)
+	assert.InDelta(t, 0.9, cost, 1e-9)
+
+	_, ok = DefaultPrices.Cost("llama", Usage{PromptTokens: 1})
+	assert.False(t, ok)
+}
@@ -0,0 +1,253 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ext is the extension of the files of the keys of a store in a directory.
+const ext = ".json"
+
+// Store is a key-value storage of JSON documents grouped in buckets.
+// The data is kept in memory and saved to a file on every change,
+// or to the file of the changed key only for a store in a directory.
+type Store struct {
+	mu sync.RWMutex
+
+	path string // no persistence if both path and dir are empty
+	dir  string // a subdirectory per bucket and a file per key
+	data map[string]map[string]json.RawMessage
+}
+
+// Open loads the store from the file. It makes an in-memory store if path is empty.
+func Open(path string) (*Store, error) {
+	s := &Store{path: path, data: make(map[string]map[string]json.RawMessage)}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, s.save()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &s.data); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// OpenDir loads the store from the directory, made if it is missing. It suits the large values,
+// as a change rewrites only the file of its key. It makes an in-memory store if dir is empty.
+func OpenDir(dir string) (*Store, error) {
+	s := &Store{dir: dir, data: make(map[string]map[string]json.RawMessage)}
+	if dir == "" {
+		return s, nil
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	buckets, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, b := range buckets {
+		if !b.IsDir() {
+			continue
+		}
+
+		bucket, err := url.PathUnescape(b.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		files, err := os.ReadDir(filepath.Join(dir, b.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, f := range files {
+			name, ok := strings.CutSuffix(f.Name(), ext)
+			if f.IsDir() || !ok {
+				continue
+			}
+
+			key, err := url.PathUnescape(name)
+			if err != nil {
+				return nil, err
+			}
+
+			data, err := os.ReadFile(filepath.Join(dir, b.Name(), f.Name()))
+			if err != nil {
+				return nil, err
+			}
+
+			if !json.Valid(data) {
+				return nil, errors.New("invalid JSON in " + filepath.Join(dir, b.Name(), f.Name()))
+			}
+
+			if s.data[bucket] == nil {
+				s.data[bucket] = make(map[string]json.RawMessage)
+			}
+
+			s.data[bucket][key] = data
+		}
+	}
+
+	return s, nil
+}
+
+// Persistent reports whether the store is saved to a file.
+func (s *Store) Persistent() bool {
+	return s.path != "" || s.dir != ""
+}
+
+// Put saves the value with the key in the bucket.
+func (s *Store) Put(bucket, key string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.data[bucket] == nil {
+		s.data[bucket] = make(map[string]json.RawMessage)
+	}
+
+	s.data[bucket][key] = data
+
+	if s.dir != "" {
+		return s.saveKey(bucket, key, data)
+	}
+
+	return s.save()
+}
+
+// Get loads the value with the key from the bucket and reports whether it exists.
+func (s *Store) Get(bucket, key string, v any) (bool, error) {
+	s.mu.RLock()
+	data, ok := s.data[bucket][key]
+	s.mu.RUnlock()
+
+	if !ok {
+		return false, nil
+	}
+
+	return true, json.Unmarshal(data, v)
+}
+
+// Delete removes the key from the bucket.
+func (s *Store) Delete(bucket, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.data[bucket][key]; !ok {
+		return nil
+	}
+
+	delete(s.data[bucket], key)
+
+	if s.dir != "" {
+		return os.Remove(s.keyPath(bucket, key))
+	}
+
+	return s.save()
+}
+
+// Keys returns the sorted keys of the bucket.
+func (s *Store) Keys(bucket string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]string, 0, len(s.data[bucket]))
+	for key := range s.data[bucket] {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// Ping checks that the file or the directory of the store is writable.
+func (s *Store) Ping() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.dir != "" {
+		tmp, err := os.CreateTemp(s.dir, ".ping.*")
+		if err != nil {
+			return err
+		}
+		tmp.Close()
+
+		return os.Remove(tmp.Name())
+	}
+
+	return s.save()
+}
+
+// save writes the data to the file of the store.
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(s.data)
+	if err != nil {
+		return err
+	}
+
+	return write(s.path, data)
+}
+
+// saveKey writes the value of the key to its file in the directory of the bucket.
+func (s *Store) saveKey(bucket, key string, data []byte) error {
+	path := s.keyPath(bucket, key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	return write(path, data)
+}
+
+// keyPath returns the file of the key in a store in a directory.
+func (s *Store) keyPath(bucket, key string) string {
+	return filepath.Join(s.dir, url.PathEscape(bucket), url.PathEscape(key)+ext)
+}
+
+// write writes the data to a temporary file and renames it, so that the file is never left half-written.
+func write(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,108 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type item struct {
+	Name string `json:"name"`
+}
+
+func TestStore_InMemory(t *testing.T) {
+	s, err := Open("")
+	assert.Nil(t, err)
+	assert.False(t, s.Persistent())
+
+	assert.Nil(t, s.Put("items", "b", item{Name: "B"}))
+	assert.Nil(t, s.Put("items", "a", item{Name: "A"}))
+	assert.Equal(t, []string{"a", "b"}, s.Keys("items"))
+	assert.Empty(t, s.Keys("other"))
+
+	var i item
+	ok, err := s.Get("items", "a", &i)
+	assert.True(t, ok)
+	assert.Nil(t, err)
+	assert.Equal(t, "A", i.Name)
+
+	assert.Nil(t, s.Delete("items", "a"))
+	assert.Nil(t, s.Delete("items", "c"))
+
+	ok, err = s.Get("items", "a", &i)
+	assert.False(t, ok)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Ping())
+}
+
+func TestStore_Persistent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+
+	s, err := Open(path)
+	assert.Nil(t, err)
+	assert.True(t, s.Persistent())
+	assert.FileExists(t, path)
+
+	assert.Nil(t, s.Put("items", "a", item{Name: "A"}))
+
+	s, err = Open(path)
+	assert.Nil(t, err)
+
+	var i item
+	ok, err := s.Get("items", "a", &i)
+	assert.True(t, ok)
+	assert.Nil(t, err)
+	assert.Equal(t, "A", i.Name)
+
+	files, _ := os.ReadDir(filepath.Dir(path))
+	assert.Len(t, files, 1, "no temporary files are left")
+
+	assert.Nil(t, os.WriteFile(path, []byte("{"), 0o600))
+	_, err = Open(path)
+	assert.NotNil(t, err)
+
+	_, err = Open(filepath.Join(t.TempDir(), "missing", "store.json"))
+	assert.NotNil(t, err)
+}
+
+func TestStore_Dir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "index")
+
+	s, err := OpenDir(dir)
+	assert.Nil(t, err)
+	assert.True(t, s.Persistent())
+	assert.DirExists(t, dir)
+	assert.Nil(t, s.Ping())
+
+	assert.Nil(t, s.Put("items", "a/b", item{Name: "A"}))
+	assert.Nil(t, s.Put("items", "c", item{Name: "C"}))
+	assert.Nil(t, s.Put("other", "a/b", item{Name: "O"}))
+
+	// Each key is kept in its own file
+	files, _ := os.ReadDir(filepath.Join(dir, "items"))
+	assert.Len(t, files, 2)
+	assert.FileExists(t, filepath.Join(dir, "items", "a%2Fb.json"))
+
+	assert.Nil(t, s.Delete("items", "c"))
+	assert.NoFileExists(t, filepath.Join(dir, "items", "c.json"))
+
+	s, err = OpenDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a/b"}, s.Keys("items"))
+
+	var i item
+	ok, err := s.Get("other", "a/b", &i)
+	assert.True(t, ok)
+	assert.Nil(t, err)
+	assert.Equal(t, "O", i.Name)
+
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "items", "d.json"), []byte("{"), 0o600))
+	_, err = OpenDir(dir)
+	assert.NotNil(t, err)
+
+	s, err = OpenDir("")
+	assert.Nil(t, err)
+	assert.False(t, s.Persistent())
+}
@@ -1,27 +1,101 @@
 package tg
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
-	"log"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/rs/zerolog"
 )
 
 // TelegramBotAPI is interface for TelegramBot with the possibility to mock it.
 type TelegramBotAPI interface {
-	GetUpdatesChan(config tgbotapi.UpdateConfig) tgbotapi.UpdatesChannel
-	Send(c tgbotapi.Chattable) (tgbotapi.Message, error)
+	Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error)
+	MakeRequest(endpoint string, params tgbotapi.Params) (*tgbotapi.APIResponse, error)
+	UploadFiles(endpoint string, params tgbotapi.Params, files []tgbotapi.RequestFile) (*tgbotapi.APIResponse, error)
+	GetFileDirectURL(fileID string) (string, error)
+	GetChatMember(config tgbotapi.GetChatMemberConfig) (tgbotapi.ChatMember, error)
 }
 
+// Update is an update from Telegram with the forum topic of its message.
+type Update struct {
+	tgbotapi.Update
+	ThreadID int // zero if the message is not in a forum topic
+}
+
+// UnmarshalJSON decodes the update and the message_thread_id of its message,
+// which is not supported by tgbotapi.
+func (u *Update) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &u.Update); err != nil {
+		return err
+	}
+
+	var raw struct {
+		Message       *topicMessage `json:"message"`
+		EditedMessage *topicMessage `json:"edited_message"`
+		CallbackQuery *struct {
+			Message *topicMessage `json:"message"`
+		} `json:"callback_query"`
+	}
+
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	msg := raw.Message
+	if msg == nil {
+		msg = raw.EditedMessage
+	}
+
+	if msg == nil && raw.CallbackQuery != nil {
+		msg = raw.CallbackQuery.Message
+	}
+
+	if msg != nil && msg.IsTopicMessage {
+		u.ThreadID = msg.MessageThreadID
+	}
+
+	return nil
+}
+
+type topicMessage struct {
+	MessageThreadID int  `json:"message_thread_id"`
+	IsTopicMessage  bool `json:"is_topic_message"`
+}
+
+// ErrFileTooLarge is returned for a file above the size limit.
+var ErrFileTooLarge = errors.New("file is too large")
+
+// downloadTimeout limits the download of a file.
+const downloadTimeout = 30 * time.Second
+
+// maxTextLength is the maximum length of a message text in Telegram.
+const maxTextLength = 4096
+
 // TelegramBot is a wrapper for TelegramBotAPI.
 type TelegramBot struct {
-	bot     TelegramBotAPI
-	offset  int
-	timeout int
+	bot      TelegramBotAPI
+	userName string
+	secret   []byte // signs callback data
+	offset   int
+	timeout  int
+	logger   zerolog.Logger
+	polled   atomic.Int64 // time of the last poll for updates in Unix nanoseconds
 }
 
-// New makes a bot for Telegram.
-func New(token string, debug bool, offset, timeout int) (*TelegramBot, error) {
+// New makes a bot for Telegram which logs to the logger.
+func New(token string, debug bool, offset, timeout int, logger zerolog.Logger) (*TelegramBot, error) {
 	if len(token) == 0 {
 		return nil, errors.New("token is empty")
 	}
@@ -30,36 +104,449 @@ func New(token string, debug bool, offset, timeout int) (*TelegramBot, error) {
 		timeout = 60 // By default, the timeout is 60 seconds
 	}
 
+	if err := tgbotapi.SetLogger(botLogger{logger}); err != nil {
+		return nil, err
+	}
+
 	b, err := tgbotapi.NewBotAPI(token)
 	if err != nil {
 		return nil, err
 	}
 
 	b.Debug = debug
-	if debug {
-		log.Printf("[ERROR] Authorized on account %s\n", b.Self.UserName)
-	}
+	logger.Info().Str("account", b.Self.UserName).Msg("authorized on Telegram")
+
+	secret := sha256.Sum256([]byte(token))
+
+	return &TelegramBot{bot: b, userName: b.Self.UserName, secret: secret[:], offset: offset, timeout: timeout, logger: logger}, nil
+}
+
+// botLogger writes the logs of tgbotapi to the logger.
+type botLogger struct {
+	logger zerolog.Logger
+}
+
+func (l botLogger) Println(v ...interface{}) {
+	l.logger.Debug().Msg(strings.TrimSpace(fmt.Sprintln(v...)))
+}
 
-	return &TelegramBot{bot: b, offset: offset, timeout: timeout}, nil
+func (l botLogger) Printf(format string, v ...interface{}) {
+	l.logger.Debug().Msgf(format, v...)
+}
+
+// UserName returns the username of the bot.
+func (b *TelegramBot) UserName() string {
+	return b.userName
 }
 
 // GetUpdatesChan returns a channel for receiving updates.
-func (b *TelegramBot) GetUpdatesChan() <-chan tgbotapi.Update {
+func (b *TelegramBot) GetUpdatesChan() <-chan Update {
 	u := tgbotapi.NewUpdate(b.offset)
 	u.Timeout = b.timeout
 
-	return b.bot.GetUpdatesChan(u)
+	ch := make(chan Update)
+
+	b.polled.Store(time.Now().UnixNano())
+
+	go func() {
+		for {
+			updates, err := b.getUpdates(u)
+			if err != nil {
+				b.logger.Error().Err(err).Msg("failed to get updates, retrying in 3 seconds")
+				time.Sleep(3 * time.Second)
+
+				continue
+			}
+
+			for _, update := range updates {
+				if update.UpdateID >= u.Offset {
+					u.Offset = update.UpdateID + 1
+					ch <- update
+				}
+			}
+
+			b.polled.Store(time.Now().UnixNano())
+		}
+	}()
+
+	return ch
 }
 
-// Send request to Telegram and returns the response.
-func (b *TelegramBot) Send(chatID int64, request string) (response string, err error) {
-	req := tgbotapi.NewMessage(chatID, request)
+// LastPoll returns the time when the updates were last received and handed over, zero before polling.
+func (b *TelegramBot) LastPoll() time.Time {
+	polled := b.polled.Load()
+	if polled == 0 {
+		return time.Time{}
+	}
 
-	var res tgbotapi.Message
-	res, err = b.bot.Send(req)
+	return time.Unix(0, polled)
+}
+
+// Ping checks the token with a getMe request.
+func (b *TelegramBot) Ping() error {
+	_, err := b.bot.MakeRequest("getMe", nil)
+	return err
+}
+
+func (b *TelegramBot) getUpdates(config tgbotapi.UpdateConfig) ([]Update, error) {
+	res, err := b.bot.Request(config)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	var updates []Update
+	err = json.Unmarshal(res.Result, &updates)
+
+	return updates, err
+}
+
+// Send request to Telegram and returns the sent message.
+// The message is sent to the forum topic if threadID is not zero.
+func (b *TelegramBot) Send(chatID int64, threadID int, request string, buttons ...tgbotapi.InlineKeyboardButton) (response tgbotapi.Message, err error) {
+	params := tgbotapi.Params{"text": limit(request)}
+	params.AddNonZero64("chat_id", chatID)
+	params.AddNonZero("message_thread_id", threadID)
+
+	if err := addKeyboard(params, buttons); err != nil {
+		return tgbotapi.Message{}, err
+	}
+
+	return b.send("sendMessage", params)
+}
+
+// SendMenu sends the message with the buttons one per row, e.g. to choose an item of a list.
+func (b *TelegramBot) SendMenu(chatID int64, threadID int, text string, buttons ...tgbotapi.InlineKeyboardButton) (tgbotapi.Message, error) {
+	params := tgbotapi.Params{"text": limit(text)}
+	params.AddNonZero64("chat_id", chatID)
+	params.AddNonZero("message_thread_id", threadID)
+
+	rows := make([][]tgbotapi.InlineKeyboardButton, 0, len(buttons))
+	for _, button := range buttons {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(button))
+	}
+
+	if err := params.AddInterface("reply_markup", tgbotapi.NewInlineKeyboardMarkup(rows...)); err != nil {
+		return tgbotapi.Message{}, err
+	}
+
+	return b.send("sendMessage", params)
+}
+
+// SendDocument sends the data as a file with the name and the caption.
+// The file is sent to the forum topic if threadID is not zero.
+func (b *TelegramBot) SendDocument(chatID int64, threadID int, name string, data []byte, caption string) (tgbotapi.Message, error) {
+	params := tgbotapi.Params{}
+	params.AddNonZero64("chat_id", chatID)
+	params.AddNonZero("message_thread_id", threadID)
+	params.AddNonEmpty("caption", caption)
+
+	res, err := b.bot.UploadFiles("sendDocument", params, []tgbotapi.RequestFile{
+		{Name: "document", Data: tgbotapi.FileBytes{Name: name, Bytes: data}},
+	})
+	if err != nil {
+		return tgbotapi.Message{}, err
+	}
+
+	var msg tgbotapi.Message
+	err = json.Unmarshal(res.Result, &msg)
+
+	return msg, err
+}
+
+// Download returns the content of the file sent to the bot if it is not larger than maxBytes.
+func (b *TelegramBot) Download(fileID string, maxBytes int64) ([]byte, error) {
+	url, err := b.bot.GetFileDirectURL(fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	client := http.Client{Timeout: downloadTimeout}
+
+	res, err := client.Get(url)
+	if err != nil {
+		return nil, errors.New("failed to download the file") // the error contains the token in the URL
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download the file: %s", res.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(res.Body, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(data)) > maxBytes {
+		return nil, ErrFileTooLarge
+	}
+
+	return data, nil
+}
+
+// Edit replaces the text and the buttons of the message.
+func (b *TelegramBot) Edit(chatID int64, messageID int, text string, buttons ...tgbotapi.InlineKeyboardButton) error {
+	params := tgbotapi.Params{"text": limit(text)}
+	params.AddNonZero64("chat_id", chatID)
+	params.AddNonZero("message_id", messageID)
+
+	if err := addKeyboard(params, buttons); err != nil {
+		return err
+	}
+
+	_, err := b.bot.MakeRequest("editMessageText", params)
+	if err != nil && strings.Contains(err.Error(), "message is not modified") {
+		return nil
 	}
 
-	return res.Text, nil
+	return err
+}
+
+// AnswerCallback notifies the user that the callback query is handled.
+func (b *TelegramBot) AnswerCallback(queryID, text string) error {
+	_, err := b.bot.Request(tgbotapi.NewCallback(queryID, text))
+	return err
+}
+
+// AnswerInline answers the inline query with the article which sends the text.
+func (b *TelegramBot) AnswerInline(queryID, resultID, title, text string) error {
+	article := tgbotapi.NewInlineQueryResultArticle(resultID, title, limit(text))
+	article.Description = text
+
+	_, err := b.bot.Request(tgbotapi.InlineConfig{
+		InlineQueryID: queryID,
+		Results:       []interface{}{article},
+		CacheTime:     300,
+		IsPersonal:    true,
+	})
+
+	return err
+}
+
+// AnswerInlineHint answers the inline query with no results and the hint above them, which opens
+// a private chat with the bot, e.g. until the answer is ready. Telegram does not cache the answer.
+func (b *TelegramBot) AnswerInlineHint(queryID, hint string) error {
+	// tgbotapi omits a zero cache time, which Telegram takes for the default of 300 seconds
+	params := tgbotapi.Params{
+		"inline_query_id":     queryID,
+		"results":             "[]",
+		"cache_time":          "0",
+		"is_personal":         "true",
+		"switch_pm_text":      hint,
+		"switch_pm_parameter": "inline",
+	}
+
+	_, err := b.bot.MakeRequest("answerInlineQuery", params)
+
+	return err
+}
+
+// Button returns an inline button with the action which only the user can use in the chat.
+func (b *TelegramBot) Button(text, action string, chatID, userID int64) tgbotapi.InlineKeyboardButton {
+	data := fmt.Sprintf("%s:%d:%s", action, userID, b.sign(action, chatID, userID))
+	return tgbotapi.NewInlineKeyboardButtonData(text, data)
+}
+
+// Action returns the action of the button pressed by its user.
+// It returns false if the callback data is forged or the button belongs to another user.
+func (b *TelegramBot) Action(query *tgbotapi.CallbackQuery) (action string, ok bool) {
+	if query == nil || query.From == nil || query.Message == nil || query.Message.Chat == nil {
+		return "", false
+	}
+
+	parts := strings.Split(query.Data, ":")
+	if len(parts) != 3 {
+		return "", false
+	}
+
+	userID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || userID != query.From.ID {
+		return "", false
+	}
+
+	if !hmac.Equal([]byte(parts[2]), []byte(b.sign(parts[0], query.Message.Chat.ID, userID))) {
+		return "", false
+	}
+
+	return parts[0], true
+}
+
+// sign returns the signature of the callback data.
+func (b *TelegramBot) sign(action string, chatID, userID int64) string {
+	mac := hmac.New(sha256.New, b.secret)
+	fmt.Fprintf(mac, "%s:%d:%d", action, chatID, userID)
+
+	return hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// addKeyboard adds a single row of inline buttons to the request.
+func addKeyboard(params tgbotapi.Params, buttons []tgbotapi.InlineKeyboardButton) error {
+	if len(buttons) == 0 {
+		return nil
+	}
+
+	return params.AddInterface("reply_markup", tgbotapi.NewInlineKeyboardMarkup(buttons))
+}
+
+// limit cuts the text to the maximum length of a message.
+func limit(text string) string {
+	if utf8.RuneCountInString(text) <= maxTextLength {
+		return text
+	}
+
+	return string([]rune(text)[:maxTextLength-1]) + "…"
+}
+
+// send makes the request and decodes the message from the response.
+func (b *TelegramBot) send(endpoint string, params tgbotapi.Params) (tgbotapi.Message, error) {
+	res, err := b.bot.MakeRequest(endpoint, params)
+	if err != nil {
+		return tgbotapi.Message{}, err
+	}
+
+	var msg tgbotapi.Message
+	err = json.Unmarshal(res.Result, &msg)
+
+	return msg, err
+}
+
+// IsBlocked reports whether the error means that the user blocked the bot or deleted the account.
+func IsBlocked(err error) bool {
+	var e *tgbotapi.Error
+	return errors.As(err, &e) && e.Code == http.StatusForbidden
+}
+
+// RetryAfter returns how long to wait after the error of too many requests, zero for other errors.
+func RetryAfter(err error) time.Duration {
+	var e *tgbotapi.Error
+	if !errors.As(err, &e) || e.Code != http.StatusTooManyRequests {
+		return 0
+	}
+
+	if e.RetryAfter <= 0 {
+		return time.Second
+	}
+
+	return time.Duration(e.RetryAfter) * time.Second
+}
+
+// IsAdmin reports whether the user is an administrator or the creator of the chat.
+func (b *TelegramBot) IsAdmin(chatID, userID int64) (bool, error) {
+	member, err := b.bot.GetChatMember(tgbotapi.GetChatMemberConfig{
+		ChatConfigWithUser: tgbotapi.ChatConfigWithUser{ChatID: chatID, UserID: userID},
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return member.IsAdministrator() || member.IsCreator(), nil
+}
+
+// Prompt returns the text of the message addressed to the bot.
+// In private chats every message is addressed to the bot. In groups the bot
+// has to be mentioned by @username, replied to or called with the /ask command.
+// The mention is stripped from the returned prompt.
+func (b *TelegramBot) Prompt(msg *tgbotapi.Message) (prompt string, ok bool) {
+	if msg == nil {
+		return "", false
+	}
+
+	if msg.IsCommand() {
+		if !b.isCommandToMe(msg) || msg.Command() != "ask" {
+			return "", false
+		}
+
+		prompt = strings.TrimSpace(msg.CommandArguments())
+		return prompt, prompt != ""
+	}
+
+	if msg.Chat == nil || msg.Chat.IsPrivate() {
+		return msg.Text, msg.Text != ""
+	}
+
+	prompt, mentioned := b.stripMention(msg.Text)
+	if !mentioned && !b.isReplyToMe(msg) {
+		return "", false
+	}
+
+	return prompt, prompt != ""
+}
+
+// isCommandToMe reports whether the command has no @username suffix or the suffix is the bot's username.
+func (b *TelegramBot) isCommandToMe(msg *tgbotapi.Message) bool {
+	command := msg.CommandWithAt()
+
+	i := strings.Index(command, "@")
+	if i == -1 {
+		return true
+	}
+
+	return strings.EqualFold(command[i+1:], b.userName)
+}
+
+// isReplyToMe reports whether the message is a reply to a message of the bot.
+func (b *TelegramBot) isReplyToMe(msg *tgbotapi.Message) bool {
+	reply := msg.ReplyToMessage
+	if reply == nil || reply.From == nil || b.userName == "" {
+		return false
+	}
+
+	return strings.EqualFold(reply.From.UserName, b.userName)
+}
+
+// stripMention removes @username of the bot from the text.
+func (b *TelegramBot) stripMention(text string) (string, bool) {
+	if b.userName == "" {
+		return strings.TrimSpace(text), false
+	}
+
+	mention := "@" + b.userName
+
+	var (
+		sb        strings.Builder
+		mentioned bool
+	)
+
+	for {
+		i := indexFold(text, mention)
+		if i == -1 {
+			sb.WriteString(text)
+			break
+		}
+
+		// Skip longer usernames that only start with the bot's one
+		end := i + len(mention)
+		if end < len(text) && isUserNameChar(text[end]) {
+			sb.WriteString(text[:end])
+			text = text[end:]
+			continue
+		}
+
+		mentioned = true
+		prefix := text[:i]
+		sb.WriteString(prefix)
+
+		text = strings.TrimLeft(text[end:], ",:")
+		if prefix == "" || strings.HasSuffix(prefix, " ") {
+			text = strings.TrimLeft(text, " ")
+		}
+	}
+
+	return strings.TrimSpace(sb.String()), mentioned
+}
+
+// indexFold returns the index of the first instance of substr in s ignoring case, or -1 if substr is not present.
+// The windows of s are compared in place, so that the index is valid in s whatever the case mapping of the other text.
+func indexFold(s, substr string) int {
+	for i := 0; i+len(substr) <= len(s); i++ {
+		if strings.EqualFold(s[i:i+len(substr)], substr) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func isUserNameChar(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
 }
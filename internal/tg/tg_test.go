@@ -1,7 +1,15 @@
 package tg
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
+	"unicode/utf8"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/stretchr/testify/assert"
@@ -9,17 +17,266 @@ import (
 
 type MockBotAPI tgbotapi.BotAPI
 
-func (m *MockBotAPI) GetUpdatesChan(config tgbotapi.UpdateConfig) tgbotapi.UpdatesChannel {
-	return nil
+func (m *MockBotAPI) Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error) {
+	return &tgbotapi.APIResponse{Ok: true, Result: json.RawMessage("true")}, nil
 }
 
-func (m *MockBotAPI) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
-	return tgbotapi.Message{Text: "Pong"}, nil
+func (m *MockBotAPI) MakeRequest(endpoint string, params tgbotapi.Params) (*tgbotapi.APIResponse, error) {
+	if endpoint == "answerInlineQuery" {
+		if params["results"] != "[]" || params["cache_time"] != "0" || params["switch_pm_text"] == "" {
+			return nil, errors.New("bad request")
+		}
+
+		return &tgbotapi.APIResponse{Ok: true, Result: json.RawMessage("true")}, nil
+	}
+
+	if endpoint == "editMessageText" && params["text"] == "Pong" {
+		return nil, &tgbotapi.Error{Code: 400, Message: "Bad Request: message is not modified"}
+	}
+
+	res, _ := json.Marshal(tgbotapi.Message{Text: "Pong"})
+	if params["message_thread_id"] != "" {
+		res, _ = json.Marshal(tgbotapi.Message{Text: "Pong in " + params["message_thread_id"]})
+	}
+
+	return &tgbotapi.APIResponse{Ok: true, Result: res}, nil
+}
+
+func (m *MockBotAPI) UploadFiles(endpoint string, params tgbotapi.Params, files []tgbotapi.RequestFile) (*tgbotapi.APIResponse, error) {
+	if len(files) != 1 || params["chat_id"] == "" {
+		return nil, errors.New("bad request")
+	}
+
+	doc := files[0].Data.(tgbotapi.FileBytes)
+	res, _ := json.Marshal(tgbotapi.Message{Caption: params["caption"], Document: &tgbotapi.Document{FileName: doc.Name, FileSize: len(doc.Bytes)}})
+
+	return &tgbotapi.APIResponse{Ok: true, Result: res}, nil
+}
+
+// fileURL is the URL of the files returned by the mock.
+var fileURL string
+
+func (m *MockBotAPI) GetFileDirectURL(fileID string) (string, error) {
+	if fileID == "" {
+		return "", errors.New("file not found")
+	}
+
+	return fileURL + "/" + fileID, nil
+}
+
+func (m *MockBotAPI) GetChatMember(config tgbotapi.GetChatMemberConfig) (tgbotapi.ChatMember, error) {
+	if config.UserID == 1 {
+		return tgbotapi.ChatMember{Status: "creator"}, nil
+	}
+
+	return tgbotapi.ChatMember{Status: "member"}, nil
 }
 
 func TestTelegramBot_Execute(t *testing.T) {
 	b := &TelegramBot{bot: &MockBotAPI{}}
-	res, err := b.Send(0, "Ping")
+	res, err := b.Send(0, 0, "Ping")
+	assert.Nil(t, err)
+	assert.Equal(t, res.Text, "Pong")
+
+	res, err = b.Send(0, 42, "Ping")
+	assert.Nil(t, err)
+	assert.Equal(t, res.Text, "Pong in 42")
+}
+
+func TestTelegramBot_SendMenu(t *testing.T) {
+	b := &TelegramBot{bot: &MockBotAPI{}}
+	res, err := b.SendMenu(0, 0, "Choose", tgbotapi.NewInlineKeyboardButtonData("A", "a"), tgbotapi.NewInlineKeyboardButtonData("B", "b"))
+	assert.Nil(t, err)
+	assert.Equal(t, res.Text, "Pong")
+}
+
+func TestTelegramBot_SendDocument(t *testing.T) {
+	b := &TelegramBot{bot: &MockBotAPI{}}
+	res, err := b.SendDocument(1, 0, "chat.md", []byte("# Chat"), "Conversation")
+	assert.Nil(t, err)
+	assert.Equal(t, "Conversation", res.Caption)
+	assert.Equal(t, "chat.md", res.Document.FileName)
+	assert.Equal(t, 6, res.Document.FileSize)
+
+	_, err = b.SendDocument(0, 0, "chat.md", nil, "")
+	assert.NotNil(t, err)
+}
+
+func TestTelegramBot_Download(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/chat.json" {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Write([]byte(`{"messages":[]}`))
+	}))
+	defer ts.Close()
+
+	fileURL = ts.URL
+	b := &TelegramBot{bot: &MockBotAPI{}}
+
+	data, err := b.Download("chat.json", 100)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"messages":[]}`, string(data))
+
+	_, err = b.Download("chat.json", 10)
+	assert.ErrorIs(t, err, ErrFileTooLarge)
+
+	_, err = b.Download("other.json", 100)
+	assert.ErrorContains(t, err, "404")
+
+	_, err = b.Download("", 100)
+	assert.NotNil(t, err)
+}
+
+func TestTelegramBot_Edit(t *testing.T) {
+	b := &TelegramBot{bot: &MockBotAPI{}}
+	assert.Nil(t, b.Edit(0, 1, "Ping", tgbotapi.NewInlineKeyboardButtonData("Stop", "stop")))
+	assert.Nil(t, b.Edit(0, 1, "Pong"))
+}
+
+func TestTelegramBot_AnswerInline(t *testing.T) {
+	b := &TelegramBot{bot: &MockBotAPI{}}
+	assert.Nil(t, b.AnswerInline("1", "2", "Ping", "Pong"))
+	assert.Nil(t, b.AnswerInlineHint("1", "Wait"))
+	assert.NotNil(t, b.AnswerInlineHint("1", ""))
+}
+
+func TestTelegramBot_Action(t *testing.T) {
+	b := &TelegramBot{bot: &MockBotAPI{}, secret: []byte("secret")}
+
+	button := b.Button("Stop", "stop", 10, 1)
+	query := func(data string, userID, chatID int64) *tgbotapi.CallbackQuery {
+		return &tgbotapi.CallbackQuery{
+			Data:    data,
+			From:    &tgbotapi.User{ID: userID},
+			Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: chatID}},
+		}
+	}
+
+	action, ok := b.Action(query(*button.CallbackData, 1, 10))
+	assert.True(t, ok)
+	assert.Equal(t, "stop", action)
+
+	_, ok = b.Action(query(*button.CallbackData, 2, 10))
+	assert.False(t, ok, "another user")
+
+	_, ok = b.Action(query(*button.CallbackData, 1, 11))
+	assert.False(t, ok, "another chat")
+
+	_, ok = b.Action(query("stop:2:0123456789abcdef", 2, 10))
+	assert.False(t, ok, "forged data")
+
+	_, ok = b.Action(query("stop", 1, 10))
+	assert.False(t, ok, "malformed data")
+}
+
+func TestLimit(t *testing.T) {
+	assert.Equal(t, "Ping", limit("Ping"))
+
+	text := limit(strings.Repeat("я", maxTextLength+1))
+	assert.Equal(t, maxTextLength, utf8.RuneCountInString(text))
+	assert.True(t, strings.HasSuffix(text, "…"))
+}
+
+func TestUpdate_UnmarshalJSON(t *testing.T) {
+	var u Update
+	err := json.Unmarshal([]byte(`{"update_id":1,"message":{"message_id":2,"text":"Ping","message_thread_id":3,"is_topic_message":true}}`), &u)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, u.UpdateID)
+	assert.Equal(t, "Ping", u.Message.Text)
+	assert.Equal(t, 3, u.ThreadID)
+
+	u = Update{}
+	err = json.Unmarshal([]byte(`{"update_id":1,"message":{"message_id":2,"text":"Ping","message_thread_id":3}}`), &u)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, u.ThreadID)
+
+	u = Update{}
+	err = json.Unmarshal([]byte(`{"update_id":1,"callback_query":{"id":"4","message":{"message_id":2,"message_thread_id":5,"is_topic_message":true}}}`), &u)
 	assert.Nil(t, err)
-	assert.Equal(t, res, "Pong")
+	assert.Equal(t, 5, u.ThreadID)
+}
+
+func TestTelegramBot_IsAdmin(t *testing.T) {
+	b := &TelegramBot{bot: &MockBotAPI{}}
+
+	ok, err := b.IsAdmin(0, 1)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	ok, err = b.IsAdmin(0, 2)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
+
+func TestTelegramBot_Prompt(t *testing.T) {
+	b := &TelegramBot{bot: &MockBotAPI{}, userName: "gpt_bot"}
+
+	private := &tgbotapi.Chat{Type: "private"}
+	group := &tgbotapi.Chat{Type: "supergroup"}
+	command := func(length int) []tgbotapi.MessageEntity {
+		return []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: length}}
+	}
+
+	tests := []struct {
+		name   string
+		msg    *tgbotapi.Message
+		prompt string
+		ok     bool
+	}{
+		{"private", &tgbotapi.Message{Chat: private, Text: "Ping"}, "Ping", true},
+		{"group", &tgbotapi.Message{Chat: group, Text: "Ping"}, "", false},
+		{"mention", &tgbotapi.Message{Chat: group, Text: "@GPT_bot, ping me"}, "ping me", true},
+		{"mention in the middle", &tgbotapi.Message{Chat: group, Text: "hey @gpt_bot ping"}, "hey ping", true},
+		{"other bot", &tgbotapi.Message{Chat: group, Text: "@gpt_bot_2 ping"}, "", false},
+		{"mention only", &tgbotapi.Message{Chat: group, Text: "@gpt_bot"}, "", false},
+		{"non-ASCII before mention", &tgbotapi.Message{Chat: group, Text: "ȺȺȺȺȺȺȺȺȺȺ @gpt_bot"}, "ȺȺȺȺȺȺȺȺȺȺ", true},
+		{"non-ASCII lowered to fewer bytes", &tgbotapi.Message{Chat: group, Text: "İİİİ hi @GPT_BOT there"}, "İİİİ hi there", true},
+		{
+			"reply",
+			&tgbotapi.Message{Chat: group, Text: "Ping", ReplyToMessage: &tgbotapi.Message{From: &tgbotapi.User{UserName: "gpt_bot"}}},
+			"Ping", true,
+		},
+		{
+			"reply to other user",
+			&tgbotapi.Message{Chat: group, Text: "Ping", ReplyToMessage: &tgbotapi.Message{From: &tgbotapi.User{UserName: "user"}}},
+			"", false,
+		},
+		{"ask", &tgbotapi.Message{Chat: group, Text: "/ask Ping", Entities: command(4)}, "Ping", true},
+		{"ask with at", &tgbotapi.Message{Chat: group, Text: "/ask@gpt_bot Ping", Entities: command(12)}, "Ping", true},
+		{"ask other bot", &tgbotapi.Message{Chat: group, Text: "/ask@other_bot Ping", Entities: command(14)}, "", false},
+		{"other command", &tgbotapi.Message{Chat: private, Text: "/start", Entities: command(6)}, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prompt, ok := b.Prompt(tt.msg)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.prompt, prompt)
+		})
+	}
+}
+
+func TestTelegramBot_Ping(t *testing.T) {
+	b := &TelegramBot{bot: &MockBotAPI{}}
+	assert.Nil(t, b.Ping())
+	assert.True(t, b.LastPoll().IsZero())
+
+	b.polled.Store(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano())
+	assert.Equal(t, 2024, b.LastPoll().Year())
+}
+
+func TestIsBlocked(t *testing.T) {
+	assert.True(t, IsBlocked(fmt.Errorf("send: %w", &tgbotapi.Error{Code: 403, Message: "Forbidden: bot was blocked by the user"})))
+	assert.False(t, IsBlocked(&tgbotapi.Error{Code: 400, Message: "Bad Request: chat not found"}))
+	assert.False(t, IsBlocked(errors.New("timeout")))
+}
+
+func TestRetryAfter(t *testing.T) {
+	assert.Equal(t, 5*time.Second, RetryAfter(&tgbotapi.Error{Code: 429, ResponseParameters: tgbotapi.ResponseParameters{RetryAfter: 5}}))
+	assert.Equal(t, time.Second, RetryAfter(&tgbotapi.Error{Code: 429}))
+	assert.Zero(t, RetryAfter(&tgbotapi.Error{Code: 403}))
+	assert.Zero(t, RetryAfter(errors.New("timeout")))
 }
@@ -0,0 +1,147 @@
+package transcript
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ErrSystemMessage is returned for system messages in a transcript which must not have them.
+var ErrSystemMessage = errors.New("system messages are not allowed")
+
+// rawMessage is a message of our format or of the OpenAI format.
+type rawMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"` // a string or an array of content parts
+	Time    time.Time       `json:"time"`
+	Model   string          `json:"model"`
+}
+
+// contentPart is a part of the content of a message in the OpenAI format.
+type contentPart struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// Parse reads a transcript of our JSON format or an OpenAI messages array,
+// bare or in an object with the messages field. Tool messages and empty
+// messages are skipped, developer messages are treated as system ones.
+func Parse(data []byte) (Transcript, error) {
+	data = bytes.TrimSpace(data)
+
+	var (
+		t   Transcript
+		raw []rawMessage
+	)
+
+	if bytes.HasPrefix(data, []byte("[")) {
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return Transcript{}, fmt.Errorf("invalid messages: %w", err)
+		}
+	} else {
+		var doc struct {
+			Version  int          `json:"version"`
+			Title    string       `json:"title"`
+			Exported time.Time    `json:"exported"`
+			Messages []rawMessage `json:"messages"`
+		}
+
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return Transcript{}, fmt.Errorf("invalid transcript: %w", err)
+		}
+
+		if doc.Version > Version {
+			return Transcript{}, fmt.Errorf("unsupported version %d", doc.Version)
+		}
+
+		t.Title, t.Exported, raw = doc.Title, doc.Exported, doc.Messages
+	}
+
+	t.Version = Version
+
+	for i, r := range raw {
+		role := r.Role
+		switch role {
+		case "tool", "function":
+			continue
+		case "developer":
+			role = RoleSystem
+		case RoleSystem, RoleUser, RoleAssistant:
+		default:
+			return Transcript{}, fmt.Errorf("message %d has invalid role %q", i+1, r.Role)
+		}
+
+		content, err := text(r.Content)
+		if err != nil {
+			return Transcript{}, fmt.Errorf("message %d has invalid content: %w", i+1, err)
+		}
+
+		if strings.TrimSpace(content) == "" {
+			continue
+		}
+
+		t.Messages = append(t.Messages, Message{Role: role, Content: content, Time: r.Time, Model: r.Model})
+	}
+
+	if len(t.Messages) == 0 {
+		return Transcript{}, errors.New("no messages")
+	}
+
+	return t, nil
+}
+
+// text returns the text of the content which is a string or an array of content parts.
+func text(content json.RawMessage) (string, error) {
+	if len(content) == 0 || string(content) == "null" {
+		return "", nil
+	}
+
+	var s string
+	if err := json.Unmarshal(content, &s); err == nil {
+		return s, nil
+	}
+
+	var parts []contentPart
+	if err := json.Unmarshal(content, &parts); err != nil {
+		return "", err
+	}
+
+	var texts []string
+	for _, p := range parts {
+		if p.Type == "text" {
+			texts = append(texts, p.Text)
+		}
+	}
+
+	return strings.Join(texts, "\n"), nil
+}
+
+// Validate checks that the transcript has no system messages unless they are allowed
+// and fits the budget of tokens.
+func Validate(t Transcript, allowSystem bool, maxTokens int) error {
+	for _, m := range t.Messages {
+		if m.Role == RoleSystem && !allowSystem {
+			return ErrSystemMessage
+		}
+	}
+
+	if tokens := Tokens(t.Messages); maxTokens > 0 && tokens > maxTokens {
+		return fmt.Errorf("the conversation has about %d tokens, more than %d", tokens, maxTokens)
+	}
+
+	return nil
+}
+
+// Tokens estimates the number of tokens of the messages.
+func Tokens(messages []Message) int {
+	var tokens int
+	for _, m := range messages {
+		tokens += 4 + (utf8.RuneCountInString(m.Content)+3)/4 // a token is about 4 characters
+	}
+
+	return tokens
+}
@@ -0,0 +1,64 @@
+package transcript
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	data, err := JSON(sample)
+	require.NoError(t, err)
+
+	got, err := Parse(data)
+	require.NoError(t, err)
+	assert.Equal(t, "Weather", got.Title)
+	assert.Equal(t, sample.Messages, got.Messages)
+
+	got, err = Parse([]byte(`[
+		{"role": "developer", "content": "Be brief"},
+		{"role": "user", "content": [{"type": "text", "text": "Hi"}, {"type": "image_url", "image_url": {"url": "x"}}]},
+		{"role": "assistant", "content": null, "tool_calls": [{"id": "1"}]},
+		{"role": "tool", "content": "42", "tool_call_id": "1"},
+		{"role": "assistant", "content": "Hello"}
+	]`))
+	require.NoError(t, err)
+	assert.Equal(t, []Message{
+		{Role: RoleSystem, Content: "Be brief"},
+		{Role: RoleUser, Content: "Hi"},
+		{Role: RoleAssistant, Content: "Hello"},
+	}, got.Messages)
+
+	got, err = Parse([]byte(`{"model": "gpt-4o", "messages": [{"role": "user", "content": "Hi"}]}`))
+	require.NoError(t, err)
+	assert.Len(t, got.Messages, 1)
+
+	for _, s := range []string{
+		`{"version": 2, "messages": [{"role": "user", "content": "Hi"}]}`,
+		`[{"role": "hacker", "content": "Hi"}]`,
+		`[{"role": "user", "content": 42}]`,
+		`[]`,
+		`not json`,
+	} {
+		_, err = Parse([]byte(s))
+		assert.Error(t, err, s)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tr := Transcript{Messages: []Message{{Role: RoleSystem, Content: "Be brief"}, {Role: RoleUser, Content: "Hi"}}}
+
+	assert.ErrorIs(t, Validate(tr, false, 0), ErrSystemMessage)
+	assert.NoError(t, Validate(tr, true, 0))
+	assert.NoError(t, Validate(tr, true, 100))
+
+	tr.Messages = append(tr.Messages, Message{Role: RoleUser, Content: strings.Repeat("word ", 100)})
+	assert.ErrorContains(t, Validate(tr, true, 100), "more than 100")
+}
+
+func TestTokens(t *testing.T) {
+	assert.Equal(t, 6, Tokens([]Message{{Content: "Hello"}}))
+	assert.Equal(t, 0, Tokens(nil))
+}
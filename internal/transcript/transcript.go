@@ -0,0 +1,121 @@
+package transcript
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"strings"
+	"time"
+)
+
+// Version of the JSON format. It changes only with incompatible changes of the format.
+const Version = 1
+
+// Roles of the messages.
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+// Transcript is a conversation in the stable export format.
+type Transcript struct {
+	Version  int       `json:"version"`
+	Title    string    `json:"title,omitempty"`
+	Exported time.Time `json:"exported"`
+	Messages []Message `json:"messages"`
+}
+
+// Message is a message of the conversation.
+type Message struct {
+	Role    string    `json:"role"`
+	Content string    `json:"content"`
+	Time    time.Time `json:"time,omitempty"`
+	Model   string    `json:"model,omitempty"` // model of the assistant which answered
+}
+
+// JSON renders the transcript as indented JSON.
+func JSON(t Transcript) ([]byte, error) {
+	t.Version = Version
+	return json.MarshalIndent(t, "", "  ")
+}
+
+// Markdown renders the transcript as a Markdown document.
+func Markdown(t Transcript) []byte {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "# %s\n\n", title(t))
+	fmt.Fprintf(&b, "_Exported on %s_\n", t.Exported.Format(time.RFC1123))
+
+	for _, m := range t.Messages {
+		fmt.Fprintf(&b, "\n### %s\n\n%s\n", heading(m), strings.TrimSpace(m.Content))
+	}
+
+	return b.Bytes()
+}
+
+var page = template.Must(template.New("page").Funcs(template.FuncMap{"heading": heading}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Roboto, sans-serif; max-width: 48rem; margin: 2rem auto; padding: 0 1rem; color: #222; }
+.message { border-radius: .5rem; padding: .75rem 1rem; margin: 1rem 0; }
+.user { background: #e8f0fe; }
+.assistant { background: #f1f3f4; }
+.system { background: #fff4e5; }
+.heading { font-size: .8rem; color: #666; margin-bottom: .5rem; }
+.content { white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p><em>Exported on {{.Exported}}</em></p>
+{{range .Messages}}<div class="message {{.Role}}">
+<div class="heading">{{heading .}}</div>
+<div class="content">{{.Content}}</div>
+</div>
+{{end}}</body>
+</html>
+`))
+
+// HTML renders the transcript as a standalone HTML page.
+func HTML(t Transcript) ([]byte, error) {
+	var b bytes.Buffer
+
+	err := page.Execute(&b, struct {
+		Title    string
+		Exported string
+		Messages []Message
+	}{title(t), t.Exported.Format(time.RFC1123), t.Messages})
+
+	return b.Bytes(), err
+}
+
+func title(t Transcript) string {
+	if t.Title == "" {
+		return "Conversation"
+	}
+
+	return t.Title
+}
+
+// heading describes the author and the time of the message.
+func heading(m Message) string {
+	h := "Unknown"
+	if m.Role != "" {
+		h = strings.ToUpper(m.Role[:1]) + m.Role[1:]
+	}
+	if m.Model != "" {
+		h += " (" + m.Model + ")"
+	}
+
+	if !m.Time.IsZero() {
+		h += ", " + m.Time.Format("2006-01-02 15:04")
+	}
+
+	return h
+}
@@ -0,0 +1,54 @@
+package transcript
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var sample = Transcript{
+	Title:    "Weather",
+	Exported: time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC),
+	Messages: []Message{
+		{Role: RoleUser, Content: "Is it <cold>?", Time: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)},
+		{Role: RoleAssistant, Content: "Yes.", Time: time.Date(2024, 1, 1, 12, 1, 0, 0, time.UTC), Model: "gpt-4o-mini"},
+	},
+}
+
+func TestJSON(t *testing.T) {
+	data, err := JSON(sample)
+	require.NoError(t, err)
+
+	var got Transcript
+	require.NoError(t, json.Unmarshal(data, &got))
+	assert.Equal(t, Version, got.Version)
+	assert.Equal(t, sample.Messages, got.Messages)
+	assert.Contains(t, string(data), `"role": "assistant"`)
+}
+
+func TestMarkdown(t *testing.T) {
+	assert.Equal(t, `# Weather
+
+_Exported on Tue, 02 Jan 2024 10:00:00 UTC_
+
+### User, 2024-01-01 12:00
+
+Is it <cold>?
+
+### Assistant (gpt-4o-mini), 2024-01-01 12:01
+
+Yes.
+`, string(Markdown(sample)))
+}
+
+func TestHTML(t *testing.T) {
+	data, err := HTML(sample)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "<title>Weather</title>")
+	assert.Contains(t, string(data), "Is it &lt;cold&gt;?")
+	assert.Contains(t, string(data), `<div class="message assistant">`)
+	assert.Contains(t, string(data), "Assistant (gpt-4o-mini), 2024-01-01 12:01")
+}
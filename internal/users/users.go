@@ -0,0 +1,144 @@
+// Package users keeps the registry of the users of the bot.
+package users
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ivanglie/chatgpt-bot/internal/store"
+)
+
+// bucket is the bucket of the store with the users.
+const bucket = "users"
+
+// seenInterval limits the updates of the time the user was last seen, so that the store is not written on every update.
+const seenInterval = 10 * time.Minute
+
+// User is a user of the bot.
+type User struct {
+	ID        int64     `json:"id"`
+	UserName  string    `json:"username,omitempty"`
+	FirstName string    `json:"first_name,omitempty"`
+	LastName  string    `json:"last_name,omitempty"`
+	Language  string    `json:"language,omitempty"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Private   bool      `json:"private,omitempty"` // has a private chat with the bot, so that the bot can message the user
+	Blocked   bool      `json:"blocked,omitempty"` // blocked the bot
+}
+
+// Name returns @username or the full name of the user.
+func (u User) Name() string {
+	if u.UserName != "" {
+		return "@" + u.UserName
+	}
+
+	if u.LastName != "" {
+		return u.FirstName + " " + u.LastName
+	}
+
+	return u.FirstName
+}
+
+// Filter selects users. The zero filter selects everyone.
+type Filter struct {
+	ActiveSince time.Time // seen since, any time if zero
+	Language    string    // language code, any if empty
+	Reachable   bool      // has a private chat with the bot and has not blocked it
+}
+
+// Match reports whether the user is selected by the filter.
+func (f Filter) Match(u User) bool {
+	return (f.ActiveSince.IsZero() || !u.LastSeen.Before(f.ActiveSince)) &&
+		(f.Language == "" || f.Language == u.Language) &&
+		(!f.Reachable || u.Private && !u.Blocked)
+}
+
+// Registry keeps the users in the store.
+type Registry struct {
+	mu    sync.Mutex
+	store *store.Store
+	now   func() time.Time
+}
+
+// New makes a registry in the store.
+func New(st *store.Store) *Registry {
+	return &Registry{store: st, now: time.Now}
+}
+
+// Seen records the activity of the user with the profile, in a private chat with the bot if private is true.
+// A user who writes in private again is not blocked anymore.
+func (r *Registry) Seen(profile User, private bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	key := strconv.FormatInt(profile.ID, 10)
+
+	var u User
+	ok, err := r.store.Get(bucket, key, &u)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		u = User{ID: profile.ID, FirstSeen: now}
+	}
+
+	changed := !ok || u.UserName != profile.UserName || u.FirstName != profile.FirstName ||
+		u.LastName != profile.LastName || u.Language != profile.Language ||
+		private && (!u.Private || u.Blocked) || now.Sub(u.LastSeen) >= seenInterval
+	if !changed {
+		return nil
+	}
+
+	u.UserName, u.FirstName, u.LastName, u.Language = profile.UserName, profile.FirstName, profile.LastName, profile.Language
+	u.LastSeen = now
+	if private {
+		u.Private, u.Blocked = true, false
+	}
+
+	return r.store.Put(bucket, key, u)
+}
+
+// SetBlocked marks the user who blocked the bot or unblocked it.
+func (r *Registry) SetBlocked(id int64, blocked bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := strconv.FormatInt(id, 10)
+
+	var u User
+	ok, err := r.store.Get(bucket, key, &u)
+	if err != nil || !ok || u.Blocked == blocked {
+		return err
+	}
+
+	u.Blocked = blocked
+
+	return r.store.Put(bucket, key, u)
+}
+
+// List returns the users selected by the filter, the recently seen first.
+func (r *Registry) List(f Filter) ([]User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var users []User
+	for _, key := range r.store.Keys(bucket) {
+		var u User
+		if _, err := r.store.Get(bucket, key, &u); err != nil {
+			return nil, err
+		}
+
+		if f.Match(u) {
+			users = append(users, u)
+		}
+	}
+
+	sort.SliceStable(users, func(i, j int) bool { return users[i].LastSeen.After(users[j].LastSeen) })
+
+	return users, nil
+}
@@ -0,0 +1,85 @@
+package users
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ivanglie/chatgpt-bot/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	st, err := store.Open("")
+	require.NoError(t, err)
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	r := New(st)
+	r.now = func() time.Time { return now }
+
+	require.NoError(t, r.Seen(User{ID: 1, UserName: "alice", Language: "en"}, true))
+	require.NoError(t, r.Seen(User{ID: 2, FirstName: "Bob", Language: "ru"}, false))
+
+	now = now.Add(time.Hour)
+	require.NoError(t, r.Seen(User{ID: 3, FirstName: "Carol", LastName: "White", Language: "en"}, true))
+
+	all, err := r.List(Filter{})
+	require.NoError(t, err)
+	require.Len(t, all, 3)
+	assert.Equal(t, "Carol White", all[0].Name())
+
+	reachable, err := r.List(Filter{Reachable: true, Language: "en"})
+	require.NoError(t, err)
+	assert.Len(t, reachable, 2)
+
+	recent, err := r.List(Filter{ActiveSince: now.Add(-time.Minute)})
+	require.NoError(t, err)
+	require.Len(t, recent, 1)
+	assert.Equal(t, int64(3), recent[0].ID)
+
+	require.NoError(t, r.SetBlocked(1, true))
+	require.NoError(t, r.SetBlocked(42, true))
+
+	reachable, err = r.List(Filter{Reachable: true})
+	require.NoError(t, err)
+	require.Len(t, reachable, 1)
+	assert.Equal(t, int64(3), reachable[0].ID)
+
+	// Writing in private again unblocks
+	require.NoError(t, r.Seen(User{ID: 1, UserName: "alice", Language: "en"}, true))
+	reachable, _ = r.List(Filter{Reachable: true})
+	assert.Len(t, reachable, 2)
+
+	// The first time seen stays
+	all, _ = r.List(Filter{})
+	assert.Equal(t, "@alice", all[0].Name())
+	assert.Equal(t, time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC), all[0].FirstSeen.UTC())
+}
+
+func TestRegistry_Seen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	st, _ := store.Open(path)
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	r := New(st)
+	r.now = func() time.Time { return now }
+
+	require.NoError(t, r.Seen(User{ID: 1, UserName: "alice"}, false))
+
+	// The time is updated at intervals
+	now = now.Add(time.Minute)
+	require.NoError(t, r.Seen(User{ID: 1, UserName: "alice"}, false))
+
+	st, _ = store.Open(path)
+	all, err := New(st).List(Filter{})
+	require.NoError(t, err)
+	require.Len(t, all, 1)
+	assert.Equal(t, 12, all[0].LastSeen.Hour())
+	assert.Equal(t, 0, all[0].LastSeen.Minute())
+
+	now = now.Add(seenInterval)
+	require.NoError(t, r.Seen(User{ID: 1, UserName: "alice"}, false))
+	all, _ = r.List(Filter{})
+	assert.Equal(t, 11, all[0].LastSeen.Minute())
+}
@@ -0,0 +1,269 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrForbidden is returned for URLs which resolve to private, loopback and other internal addresses.
+var ErrForbidden = errors.New("forbidden address")
+
+var (
+	skipRe  = regexp.MustCompile(`(?is)<(script|style|noscript|svg|head|template)\b.*?</(script|style|noscript|svg|head|template)\s*>|<!--.*?-->`)
+	titleRe = regexp.MustCompile(`(?is)<title\b[^>]*>(.*?)</title\s*>`)
+	blockRe = regexp.MustCompile(`(?i)</?(p|div|br|li|tr|h[1-6]|section|article|header|footer|blockquote|pre|table|ul|ol)\b[^>]*>`)
+	tagRe   = regexp.MustCompile(`(?s)<[^>]*>`)
+	spaceRe = regexp.MustCompile(`[ \t\r\f\v]+`)
+	linesRe = regexp.MustCompile(`\n\s*\n+`)
+	urlRe   = regexp.MustCompile(`https?://[^\s<>"']+`)
+)
+
+// Page is a fetched web page.
+type Page struct {
+	URL   string
+	Title string
+	Text  string
+}
+
+// Fetcher downloads web pages and extracts their readable text.
+type Fetcher struct {
+	client   *http.Client
+	maxBytes int64
+	maxText  int
+}
+
+// New makes a fetcher with the timeout of a request, the maximum size of a page
+// and the maximum length of the extracted text. Internal addresses are blocked.
+func New(timeout time.Duration, maxBytes int64, maxText int) *Fetcher {
+	dialer := &net.Dialer{Timeout: timeout, Control: control}
+	return newFetcher(dialer, timeout, maxBytes, maxText)
+}
+
+func newFetcher(dialer *net.Dialer, timeout time.Duration, maxBytes int64, maxText int) *Fetcher {
+	transport := &http.Transport{
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   timeout,
+		ResponseHeaderTimeout: timeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       time.Minute,
+	}
+
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("too many redirects")
+			}
+
+			return checkScheme(req.URL)
+		},
+	}
+
+	return &Fetcher{client: client, maxBytes: maxBytes, maxText: maxText}
+}
+
+// Fetch downloads the page and returns its title and text.
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (Page, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return Page{}, err
+	}
+
+	if err := checkScheme(u); err != nil {
+		return Page{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return Page{}, err
+	}
+	req.Header.Set("Accept", "text/html,text/plain")
+
+	res, err := f.client.Do(req)
+	if err != nil {
+		return Page{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return Page{}, fmt.Errorf("unexpected status %s", res.Status)
+	}
+
+	contentType := res.Header.Get("Content-Type")
+	if contentType != "" && !strings.HasPrefix(contentType, "text/") && !strings.Contains(contentType, "html") {
+		return Page{}, fmt.Errorf("unsupported content type %s", contentType)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, f.maxBytes))
+	if err != nil {
+		return Page{}, err
+	}
+
+	page := Page{URL: u.String(), Text: string(body)}
+	if strings.Contains(contentType, "html") || contentType == "" && strings.Contains(page.Text, "<html") {
+		page.Title, page.Text = extract(page.Text)
+	}
+
+	if r := []rune(page.Text); len(r) > f.maxText {
+		page.Text = string(r[:f.maxText]) + "…"
+	}
+
+	return page, nil
+}
+
+// extract returns the title and the readable text of the HTML document.
+func extract(doc string) (title, text string) {
+	if m := titleRe.FindStringSubmatch(doc); m != nil {
+		title = strings.TrimSpace(html.UnescapeString(tagRe.ReplaceAllString(m[1], "")))
+	}
+
+	if i := strings.Index(strings.ToLower(doc), "<body"); i != -1 {
+		doc = doc[i:]
+	}
+
+	doc = skipRe.ReplaceAllString(doc, "")
+	doc = blockRe.ReplaceAllString(doc, "\n")
+	doc = tagRe.ReplaceAllString(doc, "")
+	doc = html.UnescapeString(doc)
+	doc = spaceRe.ReplaceAllString(doc, " ")
+
+	lines := strings.Split(doc, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+
+	text = linesRe.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+
+	return title, strings.TrimSpace(text)
+}
+
+// URLs returns the http and https URLs in the text.
+func URLs(text string) []string {
+	var urls []string
+	for _, u := range urlRe.FindAllString(text, -1) {
+		urls = append(urls, strings.TrimRight(u, ".,;:!?)]}"))
+	}
+
+	return urls
+}
+
+func checkScheme(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme %s", u.Scheme)
+	}
+
+	return nil
+}
+
+// control blocks connections to internal addresses after DNS resolution,
+// so that neither redirects nor DNS rebinding can reach them.
+func control(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !public(ip) {
+		return fmt.Errorf("%w %s", ErrForbidden, host)
+	}
+
+	return nil
+}
+
+// denied are the special-purpose ranges which are global unicast by the checks of net.IP but not public.
+var denied = cidrs(
+	"0.0.0.0/8",       // this network
+	"100.64.0.0/10",   // shared address space of carrier-grade NAT
+	"192.0.0.0/24",    // IETF protocol assignments
+	"192.0.2.0/24",    // documentation
+	"198.18.0.0/15",   // benchmarking
+	"198.51.100.0/24", // documentation
+	"203.0.113.0/24",  // documentation
+	"240.0.0.0/4",     // reserved
+	"64:ff9b::/96",    // NAT64 of IPv4 addresses, possibly internal ones
+	"64:ff9b:1::/48",  // local NAT64
+	"100::/64",        // discard
+	"2001:db8::/32",   // documentation
+)
+
+func cidrs(ranges ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(ranges))
+	for _, r := range ranges {
+		_, n, err := net.ParseCIDR(r)
+		if err != nil {
+			panic(err)
+		}
+
+		nets = append(nets, n)
+	}
+
+	return nets
+}
+
+// public reports whether the IP is a public unicast address.
+func public(ip net.IP) bool {
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+
+	for _, n := range denied {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Tool lets the model fetch web pages. It implements oai.Tool.
+type Tool struct {
+	Fetcher *Fetcher
+}
+
+// Name implements oai.Tool.
+func (Tool) Name() string { return "fetch" }
+
+// Description implements oai.Tool.
+func (Tool) Description() string {
+	return "Fetches a web page by its http or https URL and returns its title and text."
+}
+
+// Schema implements oai.Tool.
+func (Tool) Schema() json.RawMessage {
+	return json.RawMessage(`{"type":"object","properties":{"url":{"type":"string"}},"required":["url"]}`)
+}
+
+// Call implements oai.Tool.
+func (t Tool) Call(ctx context.Context, arguments string) (string, error) {
+	var args struct {
+		URL string `json:"url"`
+	}
+
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return "", err
+	}
+
+	page, err := t.Fetcher.Fetch(ctx, args.URL)
+	if err != nil {
+		return "", err
+	}
+
+	if page.Title == "" {
+		return page.Text, nil
+	}
+
+	return page.Title + "\n\n" + page.Text, nil
+}
@@ -0,0 +1,125 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const page = `<!DOCTYPE html>
+<html>
+<head><title>Release &amp; notes</title><style>body { color: red; }</style></head>
+<body>
+<script>alert("Ping")</script>
+<h1>Version 2.0</h1>
+<p>The bot   answers in <b>groups</b> now.</p>
+<!-- comment -->
+<ul><li>Inline mode</li><li>Tools</li></ul>
+</body>
+</html>`
+
+func newServer() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, page)
+	})
+	mux.HandleFunc("/text", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, strings.Repeat("a", 100))
+	})
+	mux.HandleFunc("/image", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	})
+
+	return httptest.NewServer(mux)
+}
+
+// newTestFetcher makes a fetcher which can connect to the local test server.
+func newTestFetcher() *Fetcher {
+	return newFetcher(&net.Dialer{}, 100*time.Millisecond, 50, 20)
+}
+
+func TestFetcher_Fetch(t *testing.T) {
+	ts := newServer()
+	defer ts.Close()
+
+	f := newFetcher(&net.Dialer{}, time.Second, 1<<20, 1000)
+
+	p, err := f.Fetch(context.Background(), ts.URL+"/page")
+	assert.Nil(t, err)
+	assert.Equal(t, "Release & notes", p.Title)
+	assert.Equal(t, "Version 2.0\n\nThe bot answers in groups now.\n\nInline mode\n\nTools", p.Text)
+
+	_, err = f.Fetch(context.Background(), ts.URL+"/image")
+	assert.NotNil(t, err)
+
+	_, err = f.Fetch(context.Background(), "file:///etc/passwd")
+	assert.NotNil(t, err)
+}
+
+func TestFetcher_Limits(t *testing.T) {
+	ts := newServer()
+	defer ts.Close()
+
+	f := newTestFetcher()
+
+	p, err := f.Fetch(context.Background(), ts.URL+"/text")
+	assert.Nil(t, err)
+	assert.Equal(t, strings.Repeat("a", 20)+"…", p.Text)
+
+	_, err = f.Fetch(context.Background(), ts.URL+"/slow")
+	assert.NotNil(t, err)
+}
+
+func TestFetcher_Forbidden(t *testing.T) {
+	ts := newServer()
+	defer ts.Close()
+
+	f := New(time.Second, 1<<20, 1000)
+
+	_, err := f.Fetch(context.Background(), ts.URL+"/page")
+	assert.ErrorIs(t, err, ErrForbidden)
+
+	_, err = f.Fetch(context.Background(), "http://localhost:1/")
+	assert.ErrorIs(t, err, ErrForbidden)
+}
+
+func TestPublic(t *testing.T) {
+	for _, ip := range []string{
+		"127.0.0.1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254", "100.64.0.1", "0.0.0.0", "::1", "fc00::1", "fe80::1",
+		"0.1.2.3", "192.0.0.8", "192.0.2.1", "198.18.0.1", "198.19.255.255", "198.51.100.1", "203.0.113.1",
+		"240.0.0.1", "255.255.255.255", "224.0.0.1", "::ffff:127.0.0.1", "::ffff:10.0.0.1",
+		"64:ff9b::7f00:1", "64:ff9b::a9fe:a9fe", "64:ff9b:1::1", "100::1", "2001:db8::1", "ff02::1", "::",
+	} {
+		assert.False(t, public(net.ParseIP(ip)), ip)
+	}
+
+	for _, ip := range []string{"8.8.8.8", "1.1.1.1", "198.20.0.1", "192.0.1.1", "::ffff:8.8.8.8", "2001:4860:4860::8888"} {
+		assert.True(t, public(net.ParseIP(ip)), ip)
+	}
+}
+
+func TestURLs(t *testing.T) {
+	assert.Equal(t, []string{"https://example.com/a?b=c", "http://example.org"}, URLs("What does https://example.com/a?b=c say (see http://example.org)?"))
+	assert.Empty(t, URLs("Ping"))
+}
+
+func TestTool_Call(t *testing.T) {
+	ts := newServer()
+	defer ts.Close()
+
+	res, err := Tool{Fetcher: newTestFetcher()}.Call(context.Background(), fmt.Sprintf(`{"url":%q}`, ts.URL+"/text"))
+	assert.Nil(t, err)
+	assert.Equal(t, strings.Repeat("a", 20)+"…", res)
+}